@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+//跨链原子交换，两条链上各创建一个使用同一个秘密值hash的HTLC
+//1.发起方生成秘密值，在自己的链上创建合约，收款方为参与方（超时时间较长）
+//2.参与方审核合约后，使用同一个秘密值hash在另一条链上创建合约，收款方为发起方（超时时间较短）
+//3.发起方在参与方的链上用秘密值兑换，秘密值随兑换交易公开
+//4.参与方从兑换交易中提取秘密值，在发起方的链上兑换
+//任何一方不配合时，双方都可以在超时后取回自己的资金
+
+//发起方合约默认的超时时间（秒），必须比参与方长，保证参与方有时间兑换
+const initiatorLockDuration = 48 * 3600
+
+//参与方合约默认的超时时间（秒）
+const participantLockDuration = 24 * 3600
+
+//生成随机的秘密值，返回秘密值和它的sha256
+func NewSwapSecret() ([]byte, []byte) {
+	secret := make([]byte, htlcSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(secret)
+	return secret, hash[:]
+}
+
+//创建原子交换的合约脚本，recipient提供秘密值即可兑换，超时后refund可以取回
+func NewSwapContract(secretHash []byte, recipient, refund string, lockTime int64) ([]byte, error) {
+	if len(secretHash) != sha256.Size {
+		return nil, errors.New("秘密值hash必须是32字节")
+	}
+	recipientHash, recipientType, err := DecodeAddress(recipient)
+	if err != nil || recipientType != AddressPubKeyHash {
+		return nil, fmt.Errorf("收款方必须是P2PKH地址: %s", recipient)
+	}
+	refundHash, refundType, err := DecodeAddress(refund)
+	if err != nil || refundType != AddressPubKeyHash {
+		return nil, fmt.Errorf("退款地址必须是P2PKH地址: %s", refund)
+	}
+	if lockTime <= 0 {
+		return nil, errors.New("超时时间必须大于0")
+	}
+	return NewHTLCScript(&HTLC{secretHash, recipientHash, refundHash, lockTime}), nil
+}
+
+//默认的超时时间，当前时间加上duration
+func defaultSwapLockTime(duration int64) int64 {
+	return time.Now().Unix() + duration
+}
+
+//创建花费合约的交易并用钱包中的私钥签名
+//secret不为空时是兑换交易，由收款方签名；为空时是退款交易，由付款方签名，交易的LockTime设置为合约的超时时间
+func NewSwapSpendTransaction(contract, secret []byte, to string, ws *Wallets, bc *BlockChain) (*Transaction, error) {
+	htlc, ok := ExtractHTLC(contract)
+	if !ok {
+		return nil, errors.New("不是有效的原子交换合约")
+	}
+	pubKeyHash := htlc.RefundHash
+	var lockTime uint64
+	if secret != nil {
+		hash := sha256.Sum256(secret)
+		if !bytes.Equal(hash[:], htlc.SecretHash) {
+			return nil, errors.New("秘密值与合约中的hash不匹配")
+		}
+		pubKeyHash = htlc.RecipientHash
+	} else {
+		lockTime = uint64(htlc.LockTime)
+	}
+	wallet := ws.WalletsMap[PubKeyHashToAddress(pubKeyHash)]
+	if wallet == nil {
+		return nil, errors.New("钱包中没有合约对应的私钥")
+	}
+
+	//合约地址上的所有utxo
+	utxos := bc.FindUTXOList(hash160(contract))
+	if len(utxos) == 0 {
+		return nil, errors.New("合约没有可以花费的output")
+	}
+	var inputs []TXInput
+	total := 0.0
+	for _, utxo := range utxos {
+		inputs = append(inputs, TXInput{utxo.TXID, utxo.Index, nil, nil, nil, SequenceDefault})
+		total += utxo.Output.Value
+	}
+	tx := Transaction{[]byte{}, inputs, []TXOutput{*NewTXOutput(total, to)}, lockTime}
+	tx.SetHash()
+
+	//解锁脚本: <签名> <公钥> <秘密值> OP_1 <合约> 或者 <签名> <公钥> OP_0 <合约>
+	for i := range tx.TXInputs {
+		signature := tx.SignInputWithScript(i, wallet, contract)
+		builder := NewScriptBuilder().AddData(signature).AddData(wallet.PubKey)
+		if secret != nil {
+			builder.AddData(secret).AddOp(OP_1)
+		} else {
+			builder.AddOp(OP_0)
+		}
+		tx.TXInputs[i].ScriptSig = builder.AddData(contract).Script()
+	}
+	return &tx, nil
+}
+
+//从兑换交易的解锁脚本中提取秘密值
+func ExtractSwapSecret(tx *Transaction, secretHash []byte) ([]byte, error) {
+	for _, input := range tx.TXInputs {
+		ops, err := ParseScript(input.ScriptSig)
+		if err != nil {
+			continue
+		}
+		for _, op := range ops {
+			hash := sha256.Sum256(op.Data)
+			if len(op.Data) == htlcSecretSize && bytes.Equal(hash[:], secretHash) {
+				return op.Data, nil
+			}
+		}
+	}
+	return nil, errors.New("交易中没有找到秘密值")
+}
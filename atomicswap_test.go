@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+//只包含指定钱包的钱包集合，不读写钱包文件
+func newTestWallets(wallets ...*Wallet) *Wallets {
+	ws := NewWallets()
+	for _, wallet := range wallets {
+		ws.WalletsMap[wallet.NewAddress()] = wallet
+	}
+	return ws
+}
+
+//打包一个只有挖矿交易的区块
+func mineTestBlock(t *testing.T, bc *BlockChain, miner string, txs ...*Transaction) bool {
+	t.Helper()
+	return bc.AddBlock(append([]*Transaction{NewCoinbaseTX(miner, "")}, txs...))
+}
+
+//在prevTX的第index个output（属于wallet）上创建原子交换合约并打包，返回合约
+func fundTestSwap(t *testing.T, bc *BlockChain, wallet *Wallet, prevTX *Transaction, index int64, secretHash []byte, recipient string, lockTime int64) []byte {
+	t.Helper()
+	contract, err := NewSwapContract(secretHash, recipient, wallet.NewAddress(), lockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := newTestSpend(t, wallet, prevTX, index, prevTX.TXOutputs[index].Value, ScriptHashToAddress(hash160(contract)))
+	if !mineTestBlock(t, bc, wallet.NewAddress(), tx) {
+		t.Fatal("合约交易打包失败")
+	}
+	return contract
+}
+
+//两条独立的链: 发起方在链A上创建合约，参与方在链B上创建合约
+//发起方在链B上兑换，参与方从兑换交易中提取秘密值后在链A上兑换
+func TestAtomicSwap(t *testing.T) {
+	initiator := NewWallet(KeyTypeP256)
+	participant := NewWallet(KeyTypeP256)
+	chainA := newTestBlockChain(t, initiator.NewAddress())
+	chainB := newTestBlockChain(t, participant.NewAddress())
+	for _, bc := range []*BlockChain{chainA, chainB} {
+		if err := bc.SetCoinbaseMaturity(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret, secretHash := NewSwapSecret()
+
+	contractA := fundTestSwap(t, chainA, initiator, chainA.NewIterator().Next().Transactions[0], 0, secretHash, participant.NewAddress(), 100)
+	contractB := fundTestSwap(t, chainB, participant, chainB.NewIterator().Next().Transactions[0], 0, secretHash, initiator.NewAddress(), 50)
+
+	//秘密值错误时无法创建兑换交易
+	wrongSecret, _ := NewSwapSecret()
+	if _, err := NewSwapSpendTransaction(contractB, wrongSecret, initiator.NewAddress(), newTestWallets(initiator), chainB); err == nil {
+		t.Fatal("秘密值错误时应该失败")
+	}
+	redeemB, err := NewSwapSpendTransaction(contractB, secret, initiator.NewAddress(), newTestWallets(initiator), chainB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//把解锁脚本中的秘密值换成错误的值，脚本校验失败
+	forged := *redeemB
+	forged.TXInputs = []TXInput{redeemB.TXInputs[0]}
+	forged.TXInputs[0].ScriptSig = bytes.Replace(redeemB.TXInputs[0].ScriptSig, secret, wrongSecret, 1)
+	if mineTestBlock(t, chainB, participant.NewAddress(), &forged) {
+		t.Fatal("秘密值错误的兑换交易应该无效")
+	}
+	if !mineTestBlock(t, chainB, participant.NewAddress(), redeemB) {
+		t.Fatal("链B上的兑换失败")
+	}
+
+	//参与方在链B上找到兑换交易，提取秘密值
+	published, err := chainB.FindTransactionByTXid(redeemB.TXID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ExtractSwapSecret(&published, secretHash)
+	if err != nil || !bytes.Equal(extracted, secret) {
+		t.Fatalf("提取秘密值失败: %x %v", extracted, err)
+	}
+	redeemA, err := NewSwapSpendTransaction(contractA, extracted, participant.NewAddress(), newTestWallets(participant), chainA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mineTestBlock(t, chainA, initiator.NewAddress(), redeemA) {
+		t.Fatal("链A上的兑换失败")
+	}
+	participantHash, _, _ := DecodeAddress(participant.NewAddress())
+	initiatorHash, _, _ := DecodeAddress(initiator.NewAddress())
+	if chainA.GetBalanceByPubKeyHash(participantHash) != reward || chainB.GetBalanceByPubKeyHash(initiatorHash) != reward {
+		t.Fatal("兑换之后的余额错误")
+	}
+}
+
+//参与方不配合时，发起方在超时之后取回资金，超时之前退款无效
+func TestAtomicSwapRefund(t *testing.T) {
+	initiator := NewWallet(KeyTypeP256)
+	participant := NewWallet(KeyTypeP256)
+	bc := newTestBlockChain(t, initiator.NewAddress())
+	if err := bc.SetCoinbaseMaturity(1); err != nil {
+		t.Fatal(err)
+	}
+	_, secretHash := NewSwapSecret()
+	lockTime := int64(3)
+	contract := fundTestSwap(t, bc, initiator, bc.NewIterator().Next().Transactions[0], 0, secretHash, participant.NewAddress(), lockTime)
+
+	refund, err := NewSwapSpendTransaction(contract, nil, initiator.NewAddress(), newTestWallets(initiator), bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//参与方没有秘密值，也不能用退款路径
+	if _, err := NewSwapSpendTransaction(contract, nil, participant.NewAddress(), newTestWallets(participant), bc); err == nil {
+		t.Fatal("参与方不能退款")
+	}
+	for {
+		height, _ := bc.NextBlockInfo()
+		if height > uint64(lockTime) {
+			break
+		}
+		if mineTestBlock(t, bc, participant.NewAddress(), refund) {
+			t.Fatalf("高度%d的退款交易应该无效，超时高度是%d", height, lockTime)
+		}
+		if !mineTestBlock(t, bc, participant.NewAddress()) {
+			t.Fatal("空区块打包失败")
+		}
+	}
+	if !mineTestBlock(t, bc, participant.NewAddress(), refund) {
+		t.Fatal("超时之后退款失败")
+	}
+	initiatorHash, _, _ := DecodeAddress(initiator.NewAddress())
+	if bc.GetBalanceByPubKeyHash(initiatorHash) != reward*2 {
+		t.Fatalf("退款之后的余额错误: %f", bc.GetBalanceByPubKeyHash(initiatorHash))
+	}
+}
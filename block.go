@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
 	"log"
@@ -25,6 +24,8 @@ type Block struct {
 	Nonce uint64
 	//当前区块hash
 	Hash []byte
+	//区块高度，创世区块为0，用于交易的时间锁校验
+	Height uint64
 	//data
 	//Data []byte
 	Transactions []*Transaction
@@ -44,8 +45,13 @@ func Uint64ToByte(num uint64) []byte {
 	return buffer.Bytes()
 }
 
+//区块头的规范编码，与挖矿时计算hash的数据相同
+func (block *Block) HeaderData() []byte {
+	return NewProofOfWork(block).prepareData(block.Nonce)
+}
+
 //2.创建区块
-func NewBlock(txs []*Transaction, prevBlockHash []byte) *Block {
+func NewBlock(txs []*Transaction, prevBlockHash []byte, height uint64) *Block {
 	block := Block{
 		Version:      00,
 		PrevHash:     prevBlockHash,
@@ -54,9 +60,12 @@ func NewBlock(txs []*Transaction, prevBlockHash []byte) *Block {
 		Difficulty:   0,
 		Nonce:        0,
 		Hash:         []byte{},
+		Height:       height,
 		Transactions: txs,
 	}
 
+	//见证承诺会修改挖矿交易，要在计算默克尔根之前加入
+	block.AddWitnessCommitment()
 	block.MerkelRoot = block.MakeMerkelRoot()
 	//block.SetHash()
 	//创建一个pow对象
@@ -131,14 +140,12 @@ block.Hash = hash[:]
 }
 */
 
-//模拟MerkelRoot，只是对交易数据做简单拼接，不做二叉树处理
+//交易id组成的默克尔树的根（见merkle.go），挖矿交易是第一个叶子
+//外部挖矿程序只需要挖矿交易和默克尔路径就可以计算默克尔根（见stratum.go）
 func (block *Block) MakeMerkelRoot() []byte {
-	var info []byte
-	//var finalInfo [][]byte
+	var leaves [][]byte
 	for _, tx := range block.Transactions {
-		//将交易的hash值拼接起来，再整体求一个hash
-		info = append(info, tx.TXID...)
+		leaves = append(leaves, tx.TXID)
 	}
-	hash := sha256.Sum256(info)
-	return hash[:]
+	return MerkleRoot(leaves)
 }
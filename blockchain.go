@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"github.com/ShersBlockChain/bolt"
@@ -53,7 +52,8 @@ func NewBlockChain(address string) *BlockChain {
 			bucket.Put([]byte(blockLastHashKey), genesisBlock.Hash)
 			lastHash = genesisBlock.Hash
 		} else {
-			lastHash = bucket.Get([]byte(blockLastHashKey))
+			//bolt返回的数据只在事务中有效，需要复制，否则长期运行的进程（例如stratum服务器）写入数据库后会被覆盖
+			lastHash = append([]byte{}, bucket.Get([]byte(blockLastHashKey))...)
 		}
 		return nil
 	})
@@ -63,33 +63,138 @@ func NewBlockChain(address string) *BlockChain {
 //创世区块
 func GenesisBlock(address string) *Block {
 	coinbase := NewCoinbaseTX(address, "创世区块")
-	return NewBlock([]*Transaction{coinbase}, []byte{})
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
 }
 
-//6.添加区块
-func (bc *BlockChain) AddBlock(txs []*Transaction) {
+//6.添加区块，交易无效时不添加并返回false
+func (bc *BlockChain) AddBlock(txs []*Transaction) bool {
+	//新区块的高度和中位时间，用于校验交易的时间锁
+	height, medianTime := bc.NextBlockInfo()
+	sigCache, err := bc.checkBlockTransactions(txs, height, medianTime)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	block := NewBlock(txs, bc.tail, height)
+	if err := bc.connectBlock(block, sigCache); err != nil {
+		fmt.Printf("区块无效: %s\n", err)
+		return false
+	}
+	return true
+}
+
+//把txs（不包含挖矿交易）打包进新的区块，挖矿交易领取出块奖励和txs的手续费
+//不经过交易池直接打包的命令使用它，否则手续费会作废
+func (bc *BlockChain) MineBlock(miner, data string, txs ...*Transaction) bool {
+	prevTXs, err := bc.FindPrevTransactionsForBlock(txs)
+	if err != nil {
+		fmt.Printf("矿工发现无效交易: %s\n", err)
+		return false
+	}
+	fees := 0.0
 	for _, tx := range txs {
-		if !bc.VerifyTransaction(tx) {
-			fmt.Printf("矿工发现无效交易\n")
-			return
+		fees += tx.Fee(prevTXs)
+	}
+	return bc.AddBlock(append([]*Transaction{NewCoinbaseTXWithFees(miner, data, fees)}, txs...))
+}
+
+//添加外部挖矿程序（例如stratum矿机）找到的区块，区块头和交易都需要校验
+func (bc *BlockChain) SubmitBlock(block *Block) error {
+	height, medianTime := bc.NextBlockInfo()
+	if !bytes.Equal(block.PrevHash, bc.tail) || block.Height != height {
+		return errors.New("区块没有连接到最新的区块")
+	}
+	if len(block.Transactions) == 0 {
+		return errors.New("区块中没有挖矿交易")
+	}
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() != (i == 0) {
+			return errors.New("区块的第一笔交易必须是唯一的挖矿交易")
 		}
 	}
+	if !bytes.Equal(block.MerkelRoot, block.MakeMerkelRoot()) {
+		return errors.New("默克尔根与区块中的交易不一致")
+	}
+	if !NewProofOfWork(block).IsValid() {
+		return errors.New("区块的工作量证明无效")
+	}
+	sigCache, err := bc.checkBlockTransactions(block.Transactions, height, medianTime)
+	if err != nil {
+		return err
+	}
+	return bc.connectBlock(block, sigCache)
+}
 
-	//区块链数据库
-	db := bc.db
-	//最后一个区块的hash
-	lastHash := bc.tail
-	db.Update(func(tx *bolt.Tx) error {
+//校验区块中的交易能否打包进指定高度的区块，返回校验时使用的签名缓存
+func (bc *BlockChain) checkBlockTransactions(txs []*Transaction, height, medianTime uint64) (*SigCache, error) {
+	//交易池中校验过的签名不再重复校验
+	sigCache := bc.LoadSigCache()
+	prevTXs, err := bc.VerifyBlockTransactions(txs, sigCache, verifyWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("矿工发现无效交易: %s", err)
+	}
+	//权重和签名操作成本在挖矿之前检查，序列化之后的大小在挖矿之后检查
+	if err := CheckBlockCost(txs, prevTXs); err != nil {
+		return nil, fmt.Errorf("区块无效: %s", err)
+	}
+	if err := CheckCoinbaseValue(txs, prevTXs); err != nil {
+		return nil, fmt.Errorf("区块无效: %s", err)
+	}
+	if err := bc.CheckInputsSpendable(txs, height); err != nil {
+		return nil, fmt.Errorf("矿工发现无效交易: %s", err)
+	}
+	for _, tx := range txs {
+		if err := bc.CheckTransactionLocks(tx, height, medianTime); err != nil {
+			return nil, fmt.Errorf("矿工发现未解锁的交易: %s", err)
+		}
+	}
+	return sigCache, nil
+}
+
+//挖矿交易的金额不能超过出块奖励加上区块中所有交易的手续费，少领的部分作废
+//txs的第一笔交易是挖矿交易，prevTXs包含其他交易引用的所有交易
+func CheckCoinbaseValue(txs []*Transaction, prevTXs map[string]Transaction) error {
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return nil
+	}
+	fees := 0.0
+	for _, tx := range txs[1:] {
+		fees += tx.Fee(prevTXs)
+	}
+	value := 0.0
+	for _, output := range txs[0].TXOutputs {
+		value += output.Value
+	}
+	//手续费之和有浮点误差，允许feePrecision以内的差别
+	if value > reward+fees+feePrecision {
+		return fmt.Errorf("挖矿交易金额%.8f超过了出块奖励加手续费%.8f", value, reward+fees)
+	}
+	return nil
+}
+
+//把已经挖矿成功的区块写入数据库，成为最新的区块
+func (bc *BlockChain) connectBlock(block *Block, sigCache *SigCache) error {
+	if err := block.CheckWitnessCommitment(); err != nil {
+		return err
+	}
+	if size := block.Size(); size > maxBlockSize {
+		return fmt.Errorf("区块大小%d超过了最大值%d", size, maxBlockSize)
+	}
+	return bc.db.Update(func(tx *bolt.Tx) error {
 		//完成数据添加
 		bucket := tx.Bucket([]byte(blockBucket))
 		if bucket == nil {
 			log.Panic("bucket不应该为空，请检查")
 		}
-
-		block := NewBlock(txs, lastHash)
 		//更新区块链数据库--写区块
 		bucket.Put(block.Hash, block.Serialize())
 		bucket.Put([]byte(blockLastHashKey), block.Hash)
+		//已经打包的交易从交易池中删除
+		removeFromMempool(tx, block.Transactions)
+		sigCache.RemoveUsed(tx)
+		if err := updateFeeEstimator(tx, block); err != nil {
+			return err
+		}
 		//更新内存中的区块链
 		bc.tail = block.Hash
 		return nil
@@ -112,7 +217,21 @@ func (bc *BlockChain) PrintBlockChain() {
 		fmt.Printf("难度值: %d\n", block.Difficulty)
 		fmt.Printf("随机数: %d\n", block.Nonce)
 		fmt.Printf("当前区块的hash值： %x\n", block.Hash)
-		fmt.Printf("区块数据:  %s\n", block.Transactions[0].TXInputs[0].PubKey)
+		fmt.Printf("区块大小: %d 区块权重: %d\n", block.Size(), block.Weight())
+		if commitment, ok := ExtractWitnessCommitment(block.Transactions[0]); ok {
+			fmt.Printf("见证承诺: %x\n", commitment)
+		}
+		//区块数据保存在交易的数据output中
+		for _, tx := range block.Transactions {
+			for _, output := range tx.TXOutputs {
+				if IsWitnessCommitment(output.ScriptPubKey) {
+					continue
+				}
+				if data, ok := ExtractData(output.ScriptPubKey); ok {
+					fmt.Printf("区块数据:  %s\n", data)
+				}
+			}
+		}
 
 		if len(block.PrevHash) == 0 {
 			fmt.Printf("区块遍历结束\n")
@@ -124,110 +243,173 @@ func (bc *BlockChain) PrintBlockChain() {
 //找到指定地址的所有UTXO
 func (bc *BlockChain) FindUTXOs(senderPubKeyHash []byte) []TXOutput {
 	var UTXO []TXOutput
-	txs := bc.FindUTXOTransactions(senderPubKeyHash)
-	for _, tx := range txs {
-		for _, output := range tx.TXOutputs {
-			//if address == output.PubKeyHash {
-			if bytes.Equal(senderPubKeyHash, output.PubKeyHash) {
-				UTXO = append(UTXO, output)
+	for _, utxo := range bc.FindUTXOList(senderPubKeyHash) {
+		UTXO = append(UTXO, utxo.Output)
+	}
+	return UTXO
+}
+
+//一个未花费的output以及它的位置
+type UTXO struct {
+	TXID   []byte
+	Index  int64
+	Output TXOutput
+	//所在区块的高度，以及是否是挖矿交易的output，用于判断是否成熟
+	Height   uint64
+	Coinbase bool
+}
+
+//找到指定地址的所有UTXO，同时返回它们所在的交易id和索引
+func (bc *BlockChain) FindUTXOList(pubKeyHash []byte) []UTXO {
+	var utxos []UTXO
+	//保存已经花费的output，key是txid:index
+	spentOutputs := make(map[string]bool)
+	//1.遍历区块，从最新的区块开始，花费output的交易一定在它之后（或者在同一个区块中）
+	it := bc.NewIterator()
+	for {
+		block := it.Next()
+		//2.先标记区块中所有input花费的output，不管input属于谁（P2SH的input没有公钥）
+		markSpentOutputs(spentOutputs, block.Transactions)
+		//3.再找到属于该地址并且没有被花费的output
+		for _, tx := range block.Transactions {
+			for i, output := range tx.TXOutputs {
+				//数据output不可花费，不计入utxo
+				if output.IsUnspendable() || !bytes.Equal(pubKeyHash, output.PubKeyHash) {
+					continue
+				}
+				if spentOutputs[outpointKey(tx.TXID, int64(i))] {
+					continue
+				}
+				utxos = append(utxos, UTXO{tx.TXID, int64(i), output, block.Height, tx.IsCoinbase()})
 			}
 		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
 	}
-	return UTXO
+	return utxos
 }
-func (bc *BlockChain) FindNeedUTXOS(senderPubKeyHash []byte, amount float64) (map[string][]uint64, float64) {
-	//找到合理的UTXO集合
-	utxos := make(map[string][]uint64)
-	//找到的UTXOS包含的钱的总数
-	var calc float64
-	txs := bc.FindUTXOTransactions(senderPubKeyHash)
-	for _, tx := range txs {
-		for i, output := range tx.TXOutputs {
-			//if from == output.PubKeyHash {
-			//直接比较是否相同，返回true或false
-			if bytes.Equal(senderPubKeyHash, output.PubKeyHash) {
-				//UTXO = append(UTXO, output)
-				//我们要实现的逻辑：找到自己需要的最少UTXO
-				//3.比较一下是否满足转账需求 -- a.满足直接返回 b.不满足继续创建
-				if calc < amount {
-					//1.把utxo加入集合
-					utxos[string(tx.TXID)] = append(utxos[string(tx.TXID)], uint64(i))
-					//2.统计utxo当前总额
-					calc += output.Value
-					//如果余额满足条件
-					if calc >= amount {
-						fmt.Printf("找到了满足的金额: %f\n", calc)
-						return utxos, calc
-					}
-				} else {
-					fmt.Printf("不满足转账金额，当前金额为: %f,目标金额为: %f\n", calc, amount)
+
+//找到指定地址可以打包进下一个区块的UTXO，不包括未成熟的挖矿交易output
+func (bc *BlockChain) FindSpendableUTXOList(pubKeyHash []byte) []UTXO {
+	height, _ := bc.NextBlockInfo()
+	maturity := bc.CoinbaseMaturity()
+	var utxos []UTXO
+	for _, utxo := range bc.FindUTXOList(pubKeyHash) {
+		if utxo.IsMature(height, maturity) {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos
+}
+
+//校验手动选择的utxo都属于指定地址、没有花费过并且已经成熟，返回对应的utxo
+func (bc *BlockChain) CheckSelectedInputs(pubKeyHash []byte, inputs []TXInput) ([]UTXO, error) {
+	utxos := bc.FindUTXOList(pubKeyHash)
+	height, _ := bc.NextBlockInfo()
+	maturity := bc.CoinbaseMaturity()
+	var selected []UTXO
+	used := make(map[string]bool)
+	for _, input := range inputs {
+		key := outpointKey(input.TXid, input.Index)
+		if used[key] {
+			return nil, fmt.Errorf("重复选择了utxo: %s", key)
+		}
+		used[key] = true
+		found := false
+		for _, utxo := range utxos {
+			if bytes.Equal(utxo.TXID, input.TXid) && utxo.Index == input.Index {
+				if !utxo.IsMature(height, maturity) {
+					return nil, fmt.Errorf("挖矿交易的output还未成熟，需要%d个区块: %s", maturity, key)
 				}
+				selected = append(selected, utxo)
+				found = true
+				break
 			}
 		}
+		if !found {
+			return nil, fmt.Errorf("utxo不存在或者不属于付款地址: %s", key)
+		}
 	}
-	return utxos, calc
+	return selected, nil
 }
 
-func (bc *BlockChain) FindUTXOTransactions(senderPubKeyHash []byte) []*Transaction {
-	//包含存储所有utxo交易的集合
-	var txs []*Transaction
-	//我们定义一个map来保存消费过的utxo，key是这个output的交易id，value是这个交易中索引的数组
-	spentOutputs := make(map[string][]int64)
-	//1.遍历区块
-	//创建迭代器
+//计算指定公钥hash的余额
+func (bc *BlockChain) GetBalanceByPubKeyHash(pubKeyHash []byte) float64 {
+	total := 0.0
+	for _, utxo := range bc.FindUTXOList(pubKeyHash) {
+		total += utxo.Output.Value
+	}
+	return total
+}
+
+//地址相关的一条交易记录
+type TXHistory struct {
+	TXID []byte
+	//所在区块的hash和时间戳
+	BlockHash []byte
+	TimeStamp uint64
+	//该地址在这笔交易中收到的金额
+	Received float64
+	//该地址在这笔交易中花费的金额
+	Sent float64
+}
+
+//查找与指定公钥hash相关的所有交易记录（收款或者付款），按区块从新到旧排列
+func (bc *BlockChain) FindTransactionHistory(pubKeyHash []byte) []TXHistory {
+	var history []TXHistory
+	//遍历过程中记录所有交易，用于计算input花费的金额
+	allTXs := make(map[string]*Transaction)
+	//需要在遍历结束后再计算花费金额的交易
+	var spendTXs []*Transaction
+
 	it := bc.NewIterator()
 	for {
 		block := it.Next()
-		//2.遍历交易
 		for _, tx := range block.Transactions {
-			//fmt.Printf("current txid: %x\n", tx.TXID)
-			//3.遍历output，找到和自己相关的utxo（在添加output之前检查是否已经消耗过）
-		OUTPUT:
-			for i, output := range tx.TXOutputs {
-				//fmt.Printf("current index: %d\n", i)
-				//做一个过滤，将所有消耗过的outputs和即将添加的output对比一下
-				//如果相同，则跳过，否则添加
-				//如果当前的交易id存在于我们已经标识的map，说明这个交易里面有消耗过得output
-				//map[3333]=[]int64{0,1}
-				if spentOutputs[string(tx.TXID)] != nil {
-					for _, j := range spentOutputs[string(tx.TXID)] {
-						if int64(i) == j {
-							//当前准备添加的output已经消耗过了
-							continue OUTPUT
-						}
-					}
-				}
-				//获取与目标地址相同的output，加到返回utxo数组中
-				//if output.PubKeyHash == address {
-				if bytes.Equal(output.PubKeyHash, senderPubKeyHash) {
-					//UTXO = append(UTXO, output)
-					//返回所有包含address的utxo的交易记录
-					txs = append(txs, tx)
+			allTXs[string(tx.TXID)] = tx
+
+			record := TXHistory{TXID: tx.TXID, BlockHash: block.Hash, TimeStamp: block.TimeStamp}
+			related := false
+			for _, output := range tx.TXOutputs {
+				if bytes.Equal(output.PubKeyHash, pubKeyHash) {
+					record.Received += output.Value
+					related = true
 				}
 			}
-			//如果当前交易是挖矿交易的话，那么不做遍历，直接跳过
 			if !tx.IsCoinbase() {
-				//4.遍历input，找到自己花费过的utxo的集合（把自己消耗过得给标识出来）
 				for _, input := range tx.TXInputs {
-					//判断当前input和目标地址是否一致，如果相同，则是该地址消耗过的output，如果是就加入map
-					//if input.Sig == address {
-					if bytes.Equal(HashPubKey(input.PubKey), senderPubKeyHash) {
-						indexArray := spentOutputs[string(input.TXid)]
-						indexArray = append(indexArray, input.Index)
+					if bytes.Equal(HashPubKey(input.PubKey), pubKeyHash) {
+						related = true
 					}
 				}
-			} else {
-				fmt.Printf("这是Coinbase 不做input遍历\n")
 			}
-
+			if related {
+				history = append(history, record)
+				spendTXs = append(spendTXs, tx)
+			}
 		}
 		if len(block.PrevHash) == 0 {
-			fmt.Printf("区块遍历完成退出\n")
 			break
 		}
 	}
 
-	return txs
+	//引用的交易一定在更早的区块中，遍历结束后都已经记录下来了
+	for i, tx := range spendTXs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.TXInputs {
+			if !bytes.Equal(HashPubKey(input.PubKey), pubKeyHash) {
+				continue
+			}
+			prevTX := allTXs[string(input.TXid)]
+			if prevTX != nil && int(input.Index) < len(prevTX.TXOutputs) {
+				history[i].Sent += prevTX.TXOutputs[input.Index].Value
+			}
+		}
+	}
+	return history
 }
 
 //根据id查找交易本身，需要遍历区块链
@@ -253,46 +435,71 @@ func (bc *BlockChain) FindTransactionByTXid(id []byte) (Transaction, error) {
 	return Transaction{}, errors.New("无效的交易id，请检查!")
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey *ecdsa.PrivateKey) {
-	//签名，交易创建的最后进行签名
-	prevTXs := make(map[string]Transaction)
+//找到交易所有input引用的交易，key是交易id
+//引用的交易不存在或者索引越界时返回错误
+func (bc *BlockChain) FindPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	//所有引用的交易在一次遍历中找到
+	prevTXs, err := bc.FindPrevTransactionsForBlock([]*Transaction{tx})
+	if err != nil {
+		return nil, err
+	}
+	return prevTXs, checkInputIndexes(tx, prevTXs)
+}
 
-	//找到所有引用的交易
-	//1.根据inputs来找，有多少个input就遍历多少次
-	//2.找到目标的交易，根据TXID来找
-	//3.添加到prevTXs
+//找到交易引用的所有交易，包括交易池中还没有打包的交易
+func (bc *BlockChain) FindPrevTransactionsWithMempool(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs, err := bc.FindPrevTransactionsForBlock(append(bc.GetMempoolTransactions(), tx))
+	if err != nil {
+		return nil, err
+	}
+	return prevTXs, checkInputIndexes(tx, prevTXs)
+}
+
+func checkInputIndexes(tx *Transaction, prevTXs map[string]Transaction) error {
 	for _, input := range tx.TXInputs {
-		//根据id查找交易本身，我们需要遍历整个区块链
-		tx, err := bc.FindTransactionByTXid(input.TXid)
-		if err != nil {
-			log.Panic(err)
+		if input.Index < 0 || int(input.Index) >= len(prevTXs[string(input.TXid)].TXOutputs) {
+			return errors.New("引用的output索引无效")
 		}
+	}
+	return nil
+}
 
-		prevTXs[string(input.TXid)] = tx
+func (bc *BlockChain) SignTransaction(tx *Transaction, wallet *Wallet) {
+	//签名，交易创建的最后进行签名
+	//找到所有引用的交易
+	prevTXs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	tx.Sign(privateKey, prevTXs)
+	tx.Sign(wallet, prevTXs)
 }
 
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+	return bc.verifyTransaction(tx, nil)
+}
+
+//sigCache不为空时使用签名缓存，校验通过的签名加入缓存
+//区块中的交易使用VerifyBlockTransactions并行校验
+func (bc *BlockChain) verifyTransaction(tx *Transaction, sigCache *SigCache) bool {
+	//交易id必须与内容一致，否则可以用别人的交易id冒充
+	if !tx.HasValidTXID() {
+		fmt.Printf("交易id与交易内容不一致: %x\n", tx.TXID)
+		return false
+	}
 	if tx.IsCoinbase() {
 		return true
 	}
-	//签名，交易创建的最后进行签名
-	prevTXs := make(map[string]Transaction)
-
 	//找到所有引用的交易
-	//1.根据inputs来找，有多少个input就遍历多少次
-	//2.找到目标的交易，根据TXID来找
-	//3.添加到prevTXs
-	for _, input := range tx.TXInputs {
-		//根据id查找交易本身，我们需要遍历整个区块链
-		tx, err := bc.FindTransactionByTXid(input.TXid)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		prevTXs[string(input.TXid)] = tx
+	prevTXs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	//输出总额不能超过输入总额
+	if tx.Fee(prevTXs) < 0 {
+		fmt.Printf("交易输出总额大于输入总额\n")
+		return false
 	}
-	return tx.Verify(prevTXs)
+	return tx.VerifyWithCache(prevTXs, nil, sigCache)
 }
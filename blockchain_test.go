@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+//在临时目录中创建区块链（数据库文件名是固定的），创世区块奖励给address
+//测试结束时关闭数据库并恢复工作目录
+func newTestBlockChain(t testing.TB, address string) *BlockChain {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockChain(address)
+	t.Cleanup(func() {
+		bc.db.Close()
+		os.Chdir(wd)
+	})
+	return bc
+}
+
+//重新打开数据库，与命令行每次执行一个新进程相同
+func reopenTestBlockChain(t testing.TB, bc *BlockChain) *BlockChain {
+	t.Helper()
+	bc.db.Close()
+	reopened := NewBlockChain("")
+	bc.db, bc.tail = reopened.db, reopened.tail
+	return bc
+}
+
+//创建一笔花费prevTX第index个output的交易，value付给to，由wallet签名
+func newTestSpend(t testing.TB, wallet *Wallet, prevTX *Transaction, index int64, value float64, to string) *Transaction {
+	t.Helper()
+	input := TXInput{prevTX.TXID, index, nil, wallet.PubKey, nil, SequenceFinal}
+	tx := Transaction{nil, []TXInput{input}, []TXOutput{*NewTXOutput(value, to)}, 0}
+	tx.SetHash()
+	tx.Sign(wallet, map[string]Transaction{string(prevTX.TXID): *prevTX})
+	return &tx
+}
+
+//挖矿交易可以领取区块中交易的手续费，超过出块奖励加手续费的区块无效
+func TestCoinbaseValue(t *testing.T) {
+	wallet := NewWallet(KeyTypeP256)
+	address := wallet.NewAddress()
+	bc := newTestBlockChain(t, address)
+	if err := bc.SetCoinbaseMaturity(1); err != nil {
+		t.Fatal(err)
+	}
+	genesisCoinbase := bc.NewIterator().Next().Transactions[0]
+	tx := newTestSpend(t, wallet, genesisCoinbase, 0, reward-0.5, address)
+
+	if bc.AddBlock([]*Transaction{NewCoinbaseTXWithFees(address, "", 0.5+feePrecision*10), tx}) {
+		t.Fatal("挖矿交易领取的金额超过了手续费，区块应该无效")
+	}
+	if !bc.AddBlock([]*Transaction{NewCoinbaseTXWithFees(address, "", 0.5), tx}) {
+		t.Fatal("挖矿交易领取全部手续费的区块应该有效")
+	}
+	if _, _, err := bc.VerifyChain(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//直接打包交易时矿工领取交易的手续费
+func TestMineBlockPaysFees(t *testing.T) {
+	wallet := NewWallet(KeyTypeP256)
+	address := wallet.NewAddress()
+	miner := NewWallet(KeyTypeP256).NewAddress()
+	bc := newTestBlockChain(t, address)
+	if err := bc.SetCoinbaseMaturity(1); err != nil {
+		t.Fatal(err)
+	}
+	genesisCoinbase := bc.NewIterator().Next().Transactions[0]
+	tx := newTestSpend(t, wallet, genesisCoinbase, 0, reward-0.5, address)
+	if !bc.MineBlock(miner, "", tx) {
+		t.Fatal("打包失败")
+	}
+	minerHash, _, _ := DecodeAddress(miner)
+	if balance := bc.GetBalanceByPubKeyHash(minerHash); balance != reward+0.5 {
+		t.Fatalf("矿工余额%f，应该是出块奖励加手续费", balance)
+	}
+}
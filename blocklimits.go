@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+)
+
+//区块的大小和签名操作限制（共识规则）
+//1.区块按规范编码（区块头与计算区块hash的数据相同，交易与计算wtxid的数据相同）不能超过maxBlockSize字节
+//2.区块权重（见witness.go）不能超过maxBlockWeight
+//3.签名操作成本不能超过maxBlockSigOpsCost，限制校验一个区块需要的签名校验次数
+//  output锁定脚本中的签名操作在创建output的交易中计算，P2SH赎回脚本中的签名操作在花费它的交易中计算，都乘以witnessScaleFactor
+//  OP_CHECKSIG计1次，OP_CHECKMULTISIG按前面的公钥个数计算，无法确定公钥个数时按maxPubKeysPerMultiSig计算
+
+//区块规范编码的最大字节数
+const maxBlockSize = 1000000
+
+//区块的最大权重
+const maxBlockWeight = 4000000
+
+//区块的最大签名操作成本
+const maxBlockSigOpsCost = 80000
+
+//计算脚本中的签名操作数
+//accurate为true时OP_CHECKMULTISIG使用前面的OP_1..OP_16作为公钥个数，用于赎回脚本
+func CountSigOps(script []byte, accurate bool) int {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for i, op := range ops {
+		switch op.Opcode {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if accurate && i > 0 && ops[i-1].Opcode >= OP_1 && ops[i-1].Opcode <= OP_16 {
+				count += int(ops[i-1].Opcode - OP_1 + 1)
+			} else {
+				count += maxPubKeysPerMultiSig
+			}
+		}
+	}
+	return count
+}
+
+//交易的签名操作成本，prevTXs是input引用的交易
+func (tx *Transaction) SigOpCost(prevTXs map[string]Transaction) int {
+	count := 0
+	for _, output := range tx.TXOutputs {
+		count += CountSigOps(output.LockingScript(), false)
+	}
+	if tx.IsCoinbase() {
+		return count * witnessScaleFactor
+	}
+	for _, input := range tx.TXInputs {
+		prevOutput := prevTXs[string(input.TXid)].TXOutputs[input.Index]
+		if _, ok := ExtractP2SHScriptHash(prevOutput.LockingScript()); !ok {
+			continue
+		}
+		//P2SH的解锁脚本最后一个push的数据是赎回脚本
+		ops, err := ParseScript(input.ScriptSig)
+		if err != nil || len(ops) == 0 {
+			continue
+		}
+		count += CountSigOps(ops[len(ops)-1].Data, true)
+	}
+	return count * witnessScaleFactor
+}
+
+//校验区块中交易的权重和签名操作成本，不依赖区块头，可以在挖矿之前调用
+func CheckBlockCost(txs []*Transaction, prevTXs map[string]Transaction) error {
+	weight := 0
+	sigOpCost := 0
+	for _, tx := range txs {
+		weight += tx.Weight()
+		sigOpCost += tx.SigOpCost(prevTXs)
+	}
+	if weight > maxBlockWeight {
+		return fmt.Errorf("区块权重%d超过了最大值%d", weight, maxBlockWeight)
+	}
+	if sigOpCost > maxBlockSigOpsCost {
+		return fmt.Errorf("区块签名操作成本%d超过了最大值%d", sigOpCost, maxBlockSigOpsCost)
+	}
+	return nil
+}
+
+//区块的字节数: 区块头 + 交易个数(4字节) + 每个交易的规范编码
+func (block *Block) Size() int {
+	size := len(block.HeaderData()) + 4
+	for _, tx := range block.Transactions {
+		size += tx.TotalSize()
+	}
+	return size
+}
+
+//校验区块的所有限制
+func (block *Block) CheckLimits(prevTXs map[string]Transaction) error {
+	if size := block.Size(); size > maxBlockSize {
+		return fmt.Errorf("区块大小%d超过了最大值%d", size, maxBlockSize)
+	}
+	return CheckBlockCost(block.Transactions, prevTXs)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+)
+
+//区块模板：从交易池中选择下一个区块要打包的交易，挖矿时只需要加上挖矿交易并计算随机数
+//1.时间锁还没有到期的交易以及它们的后代交易不打包
+//2.按祖先交易包的手续费率从高到低选择，父交易排在子交易前面
+//3.区块大小、权重和签名操作成本不能超过共识限制，并且给区块头和挖矿交易预留一部分
+
+//给区块头和挖矿交易预留的大小、权重和签名操作成本
+const coinbaseReservedSize = 4000
+const coinbaseReservedWeight = coinbaseReservedSize * witnessScaleFactor
+const coinbaseReservedSigOpsCost = 400
+
+//选择交易时的大小、权重和签名操作成本限制
+type BlockLimits struct {
+	Size      int
+	Weight    int
+	SigOpCost int
+}
+
+//从交易池打包交易时使用的限制
+func DefaultBlockLimits() BlockLimits {
+	return BlockLimits{
+		maxBlockSize - coinbaseReservedSize,
+		maxBlockWeight - coinbaseReservedWeight,
+		maxBlockSigOpsCost - coinbaseReservedSigOpsCost,
+	}
+}
+
+type BlockTemplate struct {
+	//新区块的高度
+	Height uint64
+	//前一个区块的hash
+	PrevHash []byte
+	//选择的交易，父交易在前
+	Entries []*MempoolEntry
+	//所有交易的手续费之和
+	Fees float64
+	//不包括挖矿交易和区块头
+	Size      int
+	Weight    int
+	SigOpCost int
+	//交易池中没有被选择的交易数
+	Remaining int
+}
+
+//模板中的交易
+func (template *BlockTemplate) Transactions() []*Transaction {
+	return mempoolTransactions(template.Entries)
+}
+
+//挖矿交易可以领取的金额：出块奖励加上模板中所有交易的手续费
+func (template *BlockTemplate) CoinbaseValue() float64 {
+	return reward + template.Fees
+}
+
+//创建付给miner的挖矿交易，领取模板中所有交易的手续费
+func (template *BlockTemplate) NewCoinbase(miner, data string) *Transaction {
+	return NewCoinbaseTXWithFees(miner, data, template.Fees)
+}
+
+//使用交易池中的交易创建下一个区块的模板，手续费率低于minFeeRate的交易包不打包
+func (bc *BlockChain) NewBlockTemplate(minFeeRate float64) (*BlockTemplate, error) {
+	entries, err := bc.GetMempoolEntries()
+	if err != nil {
+		return nil, err
+	}
+	height, medianTime := bc.NextBlockInfo()
+	//时间锁还没有到期的交易以及它们的后代交易留在交易池中
+	locked := make(map[string]bool)
+	for _, entry := range entries {
+		if bc.CheckTransactionLocks(entry.Tx, height, medianTime) != nil {
+			locked[string(entry.Tx.TXID)] = true
+		}
+	}
+	addDescendantIDs(mempoolTransactions(entries), locked)
+	var ready []*MempoolEntry
+	for _, entry := range entries {
+		if !locked[string(entry.Tx.TXID)] {
+			ready = append(ready, entry)
+		}
+	}
+
+	selected := SelectMempoolTransactions(ready, minFeeRate, DefaultBlockLimits())
+	fees := 0.0
+	var used BlockLimits
+	for _, entry := range selected {
+		fees += entry.Fee
+		used.Size += entry.Tx.TotalSize()
+		used.Weight += entry.Weight
+		used.SigOpCost += entry.SigOpCost
+	}
+	template := BlockTemplate{height, bc.tail, selected, fees, used.Size, used.Weight, used.SigOpCost, len(entries) - len(selected)}
+	return &template, nil
+}
+
+//按祖先交易包的手续费率从交易池中选择交易（child-pays-for-parent）
+//每次选择手续费率最高的交易包（一笔交易和它还没有被选择的祖先交易），低于minFeeRate时停止
+//所以手续费率很高的子交易会把手续费率低的父交易一起带进区块
+//超过剩余限制的交易包跳过，继续选择其他交易包
+//entries必须包含其中每笔交易在交易池中的祖先交易，返回的交易父交易在前
+func SelectMempoolTransactions(entries []*MempoolEntry, minFeeRate float64, limits BlockLimits) []*MempoolEntry {
+	selected := make(map[string]bool)
+	skipped := make(map[string]bool)
+	var used BlockLimits
+	var result []*MempoolEntry
+	for {
+		var best []*MempoolEntry
+		bestRate := 0.0
+		for _, entry := range entries {
+			if selected[string(entry.Tx.TXID)] || skipped[string(entry.Tx.TXID)] {
+				continue
+			}
+			var pkg []*MempoolEntry
+			for _, ancestor := range MempoolAncestors(entries, entry.Tx) {
+				if !selected[string(ancestor.Tx.TXID)] {
+					pkg = append(pkg, ancestor)
+				}
+			}
+			pkg = append(pkg, entry)
+			if rate := PackageFeeRate(pkg); best == nil || rate > bestRate {
+				best, bestRate = pkg, rate
+			}
+		}
+		if best == nil || bestRate < minFeeRate {
+			break
+		}
+		next := used
+		for _, entry := range best {
+			next.Size += entry.Tx.TotalSize()
+			next.Weight += entry.Weight
+			next.SigOpCost += entry.SigOpCost
+		}
+		if next.Size > limits.Size || next.Weight > limits.Weight || next.SigOpCost > limits.SigOpCost {
+			//包含这笔交易的交易包都放不下
+			skipped[string(best[len(best)-1].Tx.TXID)] = true
+			continue
+		}
+		used = next
+		for _, entry := range best {
+			selected[string(entry.Tx.TXID)] = true
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+//打印区块模板
+func (template *BlockTemplate) Print() {
+	fmt.Printf("区块高度: %d\n", template.Height)
+	fmt.Printf("前区块的hash值: %x\n", template.PrevHash)
+	fmt.Printf("交易数: %d 手续费: %.8f 交易池中剩余: %d\n", len(template.Entries), template.Fees, template.Remaining)
+	fmt.Printf("挖矿交易金额: %.8f\n", template.CoinbaseValue())
+	fmt.Printf("大小: %d/%d 权重: %d/%d 签名操作成本: %d/%d\n",
+		template.Size, maxBlockSize, template.Weight, maxBlockWeight, template.SigOpCost, maxBlockSigOpsCost)
+	for i, entry := range template.Entries {
+		fmt.Printf("[%d] %x 手续费: %.8f 手续费率: %.8f 权重: %d 签名操作成本: %d\n",
+			i, entry.Tx.TXID, entry.Fee, entry.FeeRate(), entry.Weight, entry.SigOpCost)
+		//依赖的模板中的交易
+		for _, ancestor := range MempoolAncestors(template.Entries, entry.Tx) {
+			fmt.Printf("\t依赖: %x\n", ancestor.Tx.TXID)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 //接收命令行参数并且控制区块链操作的文件
@@ -14,32 +17,127 @@ type CLI struct {
 
 const Usage = `
 	printChain            "print all blockchain data"
+	setCoinbaseMaturity N "设置挖矿交易的output需要等待多少个区块才能花费，默认100，本地测试时可以设置为1"
+	verifyChain [--workers N] "使用N个线程重新校验区块链上的所有签名和脚本，默认为CPU核数，可比较不同线程数的速度"
 	getBalance --address ADDRESS "获取指定地址的余额"
-	send FROM TO AMOUNT MINER DATA "由from转amount给to 由miner挖矿同时写入data"
-	newWallet "创建一个新的钱包（私钥公钥对）"
-	listAddresses "列举所有的地址"
+	send FROM TO AMOUNT MINER DATA [OPTIONS] "由from转amount给to 由miner挖矿同时写入data，to可以是联系人标签，挖矿得到的余额需要等待setCoinbaseMaturity个区块才能花费"
+	sendMany FROM ADDRESS:AMOUNT,... MINER DATA [OPTIONS] "一笔交易向多个收款方转账"
+		OPTIONS: --inputs TXID:INDEX,... 手动选择utxo  --change ADDRESS 找零地址
+		         --selector largest|smallest|bnb|random 选币策略  --feeRate RATE 每字节手续费
+		         --confTarget N 按手续费估计在N个区块内确认，不指定--feeRate时默认为6
+		         --lockTime HEIGHT_OR_TIME 锁定时间  --sequence N 所有input的序列号（相对时间锁）
+		         --replaceable true 允许在交易池中被手续费更高的交易替换
+		         --data HEX | --text TEXT 附加数据（OP_RETURN output，最多80字节）
+	setCoinSelector largest|smallest|bnb|random "设置钱包默认的选币策略"
+	listUnspent [--address ADDRESS] "列举未花费的output"
+	newWallet [--keyType p256|secp256k1|schnorr] "创建一个新的钱包（私钥公钥对），secp256k1使用33字节压缩公钥，schnorr使用32字节公钥"
+	listAddresses "列举所有的地址（标签、余额、创建时间）"
+	getWalletBalance "获取钱包中所有地址的余额（包括watch-only地址）"
+	getHistory --address ADDRESS "获取指定地址的交易记录"
+	importAddress ADDRESS "导入一个watch-only地址"
+	importPubKey PUBKEY "导入一个watch-only公钥(16进制)"
+	dumpPubKey ADDRESS "打印钱包中地址对应的公钥"
+	validateAddress ADDRESS "校验地址并打印地址类型（P2PKH/P2SH）"
+	createTimeLock OWNER HEIGHT_OR_TIME | OWNER --relative BLOCKS "创建时间锁地址，到期后由owner花费"
+	createMultisig M PUBKEY_OR_ADDRESS,... "创建M-of-N多重签名地址并加入钱包"
+	createMuSig PUBKEY_OR_ADDRESS,... "聚合多个Schnorr公钥，创建N-of-N的MuSig地址，花费时与单签名相同"
+	setLabel ADDRESS LABEL "给自己的地址设置标签，LABEL为空字符串表示删除"
+	getLabel ADDRESS "获取地址的标签"
+	addContact LABEL ADDRESS "向通讯录添加联系人"
+	removeContact LABEL "从通讯录删除联系人"
+	listContacts "列举通讯录中的所有联系人"
+	createPSBT FROM TO AMOUNT FILE [OPTIONS] "创建部分签名交易（from可以是watch-only地址），保存到file"
+	decodePSBT FILE "打印部分签名交易的内容"
+	signPSBT FILE [--sighash TYPE] "使用本地钱包签名，不需要区块链（可在离线机器上执行），MuSig地址先交换nonce再生成部分签名"
+	combinePSBT OUT FILE1 FILE2 ... "合并多个签名方的部分签名交易"
+	finalizePSBT FILE MINER "最终确定部分签名交易，由miner挖矿打包"
+	createRawTransaction TXID:INDEX[:SEQUENCE],... ADDRESS:AMOUNT,... [--lockTime N] [--replaceable true] "创建原始交易，输出16进制编码"
+	decodeRawTransaction HEX "打印原始交易的内容"
+	decodeScript HEX "打印脚本的可读形式"
+	signRawTransaction HEX [--sighash TYPE] "使用本地钱包签名原始交易"
+		TYPE: ALL|NONE|SINGLE，可以加上|ANYONECANPAY，默认ALL
+	combineRawTransaction HEX1 HEX2 ... "合并多个ANYONECANPAY签名的原始交易（众筹、报价）"
+	sendRawTransaction HEX [MINER] "校验原始交易，指定miner时直接打包，否则放入交易池"
+	getBlockTemplate [--minFeeRate RATE] "打印下一个区块的模板：按交易包的手续费率从交易池中选择的交易，不超过区块大小、权重和签名操作限制"
+	estimateFee TARGET_BLOCKS "根据交易池中的交易被打包需要的区块数，估计在TARGET_BLOCKS个区块内确认需要的手续费率"
+	mine MINER [--minFeeRate RATE] "由miner把交易池中的交易按交易包的手续费率打包进新区块，低于RATE的交易留在交易池中"
+	bumpFee TXID [--feeRate RATE] "替换交易池中允许替换的交易，减少找零来提高手续费，默认手续费率为原交易加0.00001"
+	bumpFeeCPFP TXID [--feeRate RATE] "创建花费交易池中交易的子交易，使交易包达到指定的手续费率"
+	startStratum HOST:PORT MINER [--shareDifficulty D] "启动stratum挖矿服务器，按区块模板下发任务，挖矿奖励给miner，份额难度默认1/16（1为区块难度）"
+	startPool HOST:PORT POOL_ADDRESS [--shareDifficulty D] [--pplnsShares N] [--poolFee PERCENT] "以矿池模式启动stratum服务器，矿工名称为收款地址，按最近N个份额（默认64）分配区块奖励，挖矿交易成熟后自动支付，手续费为百分比，默认为1"
+	poolStats HOST:PORT "查询矿池中每个收款地址的算力、待支付余额和已支付金额"
+	stratumMiner HOST:PORT WORKER [--blocks N] "连接stratum服务器挖矿的测试矿机，找到N个区块后退出，不指定时一直挖矿"
+	initiateSwap FROM PARTICIPANT AMOUNT MINER [--lockTime N] "发起原子交换，生成秘密值并创建合约"
+	participateSwap FROM INITIATOR AMOUNT SECRETHASH MINER [--lockTime N] "参与原子交换，使用相同的秘密值hash创建合约"
+	auditSwap CONTRACT "审核原子交换合约"
+	redeemSwap CONTRACT SECRET TO MINER "使用秘密值兑换合约中的资金"
+	refundSwap CONTRACT TO MINER "合约超时后取回资金"
+	extractSecret TXID SECRETHASH "从兑换交易中提取秘密值"
+	notarize FROM MINER FILE... [OPTIONS] "对文件做存证，多个文件合并成默克尔树，每个文件生成FILE.receipt凭证"
+	verifyReceipt FILE RECEIPT "使用本地区块链校验文件的存证凭证"
 `
 
-//接收参数的动作，放到一个函数中
+// 不需要打开区块链的命令，可以在没有区块链数据的离线机器上执行
+var offlineCommands = map[string]bool{
+	"newWallet":             true,
+	"dumpPubKey":            true,
+	"validateAddress":       true,
+	"createMultisig":        true,
+	"createMuSig":           true,
+	"decodePSBT":            true,
+	"decodeScript":          true,
+	"signPSBT":              true,
+	"combinePSBT":           true,
+	"combineRawTransaction": true,
+	"stratumMiner":          true,
+	"poolStats":             true,
+}
+
+// 接收参数的动作，放到一个函数中
 func (cli *CLI) Run() {
 	//得到所有的命令
 	args := os.Args
 	if len(args) < 2 {
-		fmt.Println(Usage)
+		fmt.Print(Usage)
 		return
 	}
 	//解析命令
 	cmd := args[1]
+	//把 --key value 形式的选项从参数中分离出来
+	options, args := parseOptions(args)
 	switch cmd {
 	case "printChain":
 		//打印区块
 		cli.bc.PrintBlockChain()
+	case "setCoinbaseMaturity":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		maturity, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			fmt.Printf("区块数格式错误: %s\n", args[2])
+			return
+		}
+		cli.SetCoinbaseMaturity(maturity)
+	case "verifyChain":
+		workers := verifyWorkers
+		if options["workers"] != "" {
+			n, err := strconv.Atoi(options["workers"])
+			if err != nil || n < 1 {
+				fmt.Printf("线程数格式错误: %s\n", options["workers"])
+				return
+			}
+			workers = n
+		}
+		cli.VerifyChain(workers)
 	case "getBalance":
 		fmt.Printf("获取余额\n")
 		//确保命令有效
-		if len(args) == 4 && args[2] == "--address" {
+		if len(args) == 2 && options["address"] != "" {
 			//获取数据
-			address := args[3]
+			address := options["address"]
 			cli.GetBalance(address)
 		} else {
 			fmt.Printf("添加区块参数使用不当，请检查")
@@ -51,22 +149,585 @@ func (cli *CLI) Run() {
 		if len(args) != 7 {
 			fmt.Printf("参数个数错误")
 			fmt.Printf(Usage)
+			return
 		}
 		from := args[2]
 		to := args[3]
 		amount, _ := strconv.ParseFloat(args[4], 64)
 		miner := args[5]
 		data := args[6]
-		cli.Send(from, to, amount, miner, data)
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.Send(from, to, amount, miner, data, opts)
+	case "sendMany":
+		if len(args) != 6 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.SendMany(args[2], args[3], args[4], args[5], opts)
+	case "setCoinSelector":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.SetCoinSelector(args[2])
+	case "listUnspent":
+		cli.ListUnspent(options["address"])
 	case "newWallet":
 		fmt.Printf("创建新的钱包....\n")
-		cli.NewWallet()
+		keyType := KeyTypeP256
+		if options["keyType"] != "" {
+			var err error
+			keyType, err = ParseKeyType(options["keyType"])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		cli.NewWallet(keyType)
 	case "listAddresses":
 		fmt.Printf("列举所有地址...\n")
 		cli.ListAddresses()
+	case "getWalletBalance":
+		cli.GetWalletBalance()
+	case "getHistory":
+		if len(args) == 2 && options["address"] != "" {
+			cli.GetHistory(options["address"])
+		} else {
+			fmt.Printf("参数使用不当，请检查\n")
+			fmt.Printf(Usage)
+		}
+	case "importAddress":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.ImportAddress(args[2])
+	case "importPubKey":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.ImportPubKey(args[2])
+	case "dumpPubKey":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.DumpPubKey(args[2])
+	case "validateAddress":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.ValidateAddress(args[2])
+	case "createTimeLock":
+		if len(args) == 3 && options["relative"] != "" {
+			blocks, err := strconv.ParseUint(options["relative"], 10, 32)
+			if err != nil {
+				fmt.Printf("区块个数格式错误: %s\n", options["relative"])
+				return
+			}
+			cli.CreateTimeLock(args[2], blocks, true)
+			return
+		}
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		lockTime, err := strconv.ParseUint(args[3], 10, 32)
+		if err != nil || lockTime == 0 {
+			fmt.Printf("锁定时间格式错误: %s\n", args[3])
+			return
+		}
+		cli.CreateTimeLock(args[2], lockTime, false)
+	case "initiateSwap":
+		if len(args) != 6 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		amount, _ := strconv.ParseFloat(args[4], 64)
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.InitiateSwap(args[2], args[3], amount, args[5], opts)
+	case "participateSwap":
+		if len(args) != 7 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		amount, _ := strconv.ParseFloat(args[4], 64)
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.ParticipateSwap(args[2], args[3], amount, args[5], args[6], opts)
+	case "auditSwap":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.AuditSwap(args[2])
+	case "redeemSwap":
+		if len(args) != 6 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.RedeemSwap(args[2], args[3], args[4], args[5])
+	case "refundSwap":
+		if len(args) != 5 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.RefundSwap(args[2], args[3], args[4])
+	case "extractSecret":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.ExtractSecret(args[2], args[3])
+	case "notarize":
+		if len(args) < 5 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if opts.Data != nil {
+			fmt.Printf("存证交易不能附加其他数据\n")
+			return
+		}
+		cli.Notarize(args[2], args[3], args[4:], opts)
+	case "verifyReceipt":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.VerifyReceipt(args[2], args[3])
+	case "createMultisig":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		m, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Printf("签名个数格式错误: %s\n", args[2])
+			return
+		}
+		cli.CreateMultisig(m, strings.Split(args[3], ","))
+	case "createMuSig":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.CreateMuSig(strings.Split(args[2], ","))
+	case "setLabel":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.SetLabel(args[2], args[3])
+	case "getLabel":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.GetLabel(args[2])
+	case "addContact":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.AddContact(args[2], args[3])
+	case "removeContact":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.RemoveContact(args[2])
+	case "listContacts":
+		cli.ListContacts()
+	case "createPSBT":
+		if len(args) != 6 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		amount, _ := strconv.ParseFloat(args[4], 64)
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.CreatePSBT(args[2], args[3], amount, args[5], opts)
+	case "decodePSBT":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.DecodePSBT(args[2])
+	case "signPSBT":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		hashType, err := parseSigHashOption(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.SignPSBT(args[2], hashType)
+	case "combinePSBT":
+		if len(args) < 5 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.CombinePSBT(args[2], args[3:])
+	case "finalizePSBT":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.FinalizePSBT(args[2], args[3])
+	case "createRawTransaction":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		opts, err := parseSendOptions(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.CreateRawTransaction(args[2], args[3], opts.LockTime, opts.Replaceable)
+	case "decodeRawTransaction":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.DecodeRawTransaction(args[2])
+	case "decodeScript":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.DecodeScript(args[2])
+	case "signRawTransaction":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		hashType, err := parseSigHashOption(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.SignRawTransaction(args[2], hashType)
+	case "combineRawTransaction":
+		if len(args) < 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.CombineRawTransaction(args[2:])
+	case "sendRawTransaction":
+		if len(args) != 3 && len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		miner := ""
+		if len(args) == 4 {
+			miner = args[3]
+		}
+		cli.SendRawTransaction(args[2], miner)
+	case "mine":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		minFeeRate, err := parseFeeRateOption(options, "minFeeRate")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.Mine(args[2], minFeeRate)
+	case "getBlockTemplate":
+		if len(args) != 2 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		minFeeRate, err := parseFeeRateOption(options, "minFeeRate")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.GetBlockTemplate(minFeeRate)
+	case "estimateFee":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		target, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Printf("区块数格式错误: %s\n", args[2])
+			return
+		}
+		cli.EstimateFee(target)
+	case "bumpFee", "bumpFeeCPFP":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		feeRate, err := parseFeeRateOption(options, "feeRate")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.BumpFee(args[2], feeRate, cmd == "bumpFeeCPFP")
+	case "startStratum":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		shareDifficulty, err := parseShareDifficultyOption(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cli.StartStratum(args[2], args[3], shareDifficulty)
+	case "startPool":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		shareDifficulty, err := parseShareDifficultyOption(options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		pplnsShares := defaultPPLNSShares
+		if options["pplnsShares"] != "" {
+			n, err := strconv.Atoi(options["pplnsShares"])
+			if err != nil || n < 1 {
+				fmt.Printf("份额数格式错误: %s\n", options["pplnsShares"])
+				return
+			}
+			pplnsShares = n
+		}
+		poolFee := defaultPoolFee
+		if options["poolFee"] != "" {
+			fee, err := strconv.ParseFloat(options["poolFee"], 64)
+			if err != nil {
+				fmt.Printf("手续费格式错误: %s\n", options["poolFee"])
+				return
+			}
+			poolFee = fee
+		}
+		cli.StartPool(args[2], args[3], shareDifficulty, pplnsShares, poolFee)
+	case "poolStats":
+		if len(args) != 3 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		cli.PoolStats(args[2])
+	case "stratumMiner":
+		if len(args) != 4 {
+			fmt.Printf("参数个数错误\n")
+			fmt.Printf(Usage)
+			return
+		}
+		blocks := 0
+		if options["blocks"] != "" {
+			n, err := strconv.Atoi(options["blocks"])
+			if err != nil || n < 1 {
+				fmt.Printf("区块数格式错误: %s\n", options["blocks"])
+				return
+			}
+			blocks = n
+		}
+		cli.StratumMiner(args[2], args[3], blocks)
 	default:
 		fmt.Printf(Usage)
 	}
 
 	//执行相应的action
 }
+
+// 把参数中 --key value 形式的选项提取出来，返回选项和剩下的参数
+func parseOptions(args []string) (map[string]string, []string) {
+	options := make(map[string]string)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "--") && i+1 < len(args) {
+			options[strings.TrimPrefix(args[i], "--")] = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	return options, rest
+}
+
+// 解析手续费率选项，没有指定时为0
+func parseFeeRateOption(options map[string]string, name string) (float64, error) {
+	if options[name] == "" {
+		return 0, nil
+	}
+	feeRate, err := strconv.ParseFloat(options[name], 64)
+	if err != nil || feeRate < 0 {
+		return 0, fmt.Errorf("手续费格式错误: %s", options[name])
+	}
+	return feeRate, nil
+}
+
+// 解析--shareDifficulty选项，份额难度在0到1之间
+func parseShareDifficultyOption(options map[string]string) (float64, error) {
+	if options["shareDifficulty"] == "" {
+		return defaultShareDifficulty, nil
+	}
+	difficulty, err := strconv.ParseFloat(options["shareDifficulty"], 64)
+	if err != nil || difficulty <= 0 || difficulty > 1 {
+		return 0, fmt.Errorf("份额难度格式错误: %s", options["shareDifficulty"])
+	}
+	return difficulty, nil
+}
+
+// 解析--sighash选项，默认为ALL
+func parseSigHashOption(options map[string]string) (SigHashType, error) {
+	if options["sighash"] == "" {
+		return SigHashAll, nil
+	}
+	return ParseSigHashType(options["sighash"])
+}
+
+// 解析转账选项：--inputs 手动选择utxo，--change 找零地址，--selector 选币策略，--feeRate 每字节手续费
+func parseSendOptions(options map[string]string) (SendOptions, error) {
+	var opts SendOptions
+	if options["inputs"] != "" {
+		inputs, err := ParseRawInputs(options["inputs"])
+		if err != nil {
+			return opts, err
+		}
+		opts.Inputs = inputs
+	}
+	if options["change"] != "" {
+		if !IsValidAddress(options["change"]) {
+			return opts, fmt.Errorf("找零地址无效: %s", options["change"])
+		}
+		opts.ChangeAddress = options["change"]
+	}
+	if options["selector"] != "" {
+		if _, err := GetCoinSelector(options["selector"]); err != nil {
+			return opts, err
+		}
+		opts.Selector = options["selector"]
+	}
+	feeRate, err := parseFeeRateOption(options, "feeRate")
+	if err != nil {
+		return opts, err
+	}
+	opts.FeeRate = feeRate
+	//没有指定手续费率时使用手续费估计
+	if options["confTarget"] != "" {
+		if options["feeRate"] != "" {
+			return opts, errors.New("--feeRate和--confTarget不能同时使用")
+		}
+		target, err := strconv.Atoi(options["confTarget"])
+		if err != nil || target < 1 || target > maxConfirmTarget {
+			return opts, fmt.Errorf("确认目标必须在1到%d之间: %s", maxConfirmTarget, options["confTarget"])
+		}
+		opts.ConfTarget = target
+	} else if options["feeRate"] == "" {
+		opts.ConfTarget = defaultConfirmTarget
+	}
+	if options["lockTime"] != "" {
+		//区块高度或者unix时间
+		lockTime, err := strconv.ParseUint(options["lockTime"], 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("锁定时间格式错误: %s", options["lockTime"])
+		}
+		opts.LockTime = lockTime
+	}
+	if options["sequence"] != "" {
+		sequence, err := strconv.ParseUint(options["sequence"], 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("序列号格式错误: %s", options["sequence"])
+		}
+		opts.Sequence = uint32(sequence)
+	}
+	if options["replaceable"] != "" {
+		replaceable, err := strconv.ParseBool(options["replaceable"])
+		if err != nil {
+			return opts, fmt.Errorf("replaceable格式错误: %s", options["replaceable"])
+		}
+		opts.Replaceable = replaceable
+	}
+	if options["data"] != "" && options["text"] != "" {
+		return opts, errors.New("--data和--text不能同时使用")
+	}
+	if options["data"] != "" {
+		data, err := hex.DecodeString(options["data"])
+		if err != nil {
+			return opts, fmt.Errorf("数据格式错误: %s", options["data"])
+		}
+		opts.Data = data
+	}
+	if options["text"] != "" {
+		opts.Data = []byte(options["text"])
+	}
+	if len(opts.Data) > maxDataCarrierSize {
+		return opts, fmt.Errorf("数据不能超过%d字节", maxDataCarrierSize)
+	}
+	return opts, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+//估算交易大小使用的参数（字节），参考比特币P2PKH交易
+const txBaseSize = 10
+const txInputSize = 148
+const txOutputSize = 34
+
+//找零低于这个金额时不创建找零output，直接作为手续费
+const dustThreshold = 0.00001
+
+//分支定界搜索的最大尝试次数
+const bnbMaxTries = 100000
+
+//钱包没有设置时使用的选币策略
+const defaultCoinSelector = "bnb"
+
+var ErrInsufficientFunds = errors.New("余额不足，交易失败")
+var ErrNoExactMatch = errors.New("没有找到不需要找零的utxo组合")
+
+//选币需要的参数
+type SelectionParams struct {
+	//需要支付给收款方的总额，不含手续费
+	Target float64
+	//收款output的个数，不含找零
+	NumOutputs int
+	//每字节的手续费
+	FeeRate float64
+}
+
+//选币的结果
+type CoinSelection struct {
+	UTXOs []UTXO
+	//选中utxo的总额
+	Total float64
+	Fee   float64
+	//找零金额，为0表示不需要找零
+	Change float64
+}
+
+//选币策略接口，不同的策略在手续费、找零和隐私上有不同的取舍
+type CoinSelector interface {
+	Select(utxos []UTXO, params SelectionParams) (*CoinSelection, error)
+}
+
+//根据名称返回选币策略
+func GetCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "", defaultCoinSelector:
+		return &BranchAndBoundSelector{Fallback: &LargestFirstSelector{}}, nil
+	case "largest":
+		return &LargestFirstSelector{}, nil
+	case "smallest":
+		return &SmallestFirstSelector{}, nil
+	case "random":
+		return &RandomImproveSelector{}, nil
+	default:
+		return nil, fmt.Errorf("未知的选币策略: %s (可选: largest, smallest, bnb, random)", name)
+	}
+}
+
+//估算交易大小
+func EstimateTxSize(numInputs, numOutputs int) int {
+	return txBaseSize + numInputs*txInputSize + numOutputs*txOutputSize
+}
+
+//花费一个utxo之后真正能用的金额（扣除这个input本身的手续费）
+func effectiveValue(utxo UTXO, feeRate float64) float64 {
+	return utxo.Output.Value - feeRate*txInputSize
+}
+
+//创建找零并在将来花费它的成本，找零小于这个值时不如直接作为手续费
+func costOfChange(feeRate float64) float64 {
+	return feeRate*(txOutputSize+txInputSize) + dustThreshold
+}
+
+//根据选中的utxo计算手续费和找零
+func finishSelection(selected []UTXO, params SelectionParams) (*CoinSelection, error) {
+	total := 0.0
+	for _, utxo := range selected {
+		total += utxo.Output.Value
+	}
+	feeNoChange := params.FeeRate * float64(EstimateTxSize(len(selected), params.NumOutputs))
+	feeWithChange := params.FeeRate * float64(EstimateTxSize(len(selected), params.NumOutputs+1))
+
+	change := total - params.Target - feeWithChange
+	if change >= costOfChange(params.FeeRate) {
+		return &CoinSelection{selected, total, feeWithChange, change}, nil
+	}
+	if total >= params.Target+feeNoChange {
+		//零钱太少，全部作为手续费
+		return &CoinSelection{selected, total, total - params.Target, 0}, nil
+	}
+	return nil, ErrInsufficientFunds
+}
+
+//按顺序选择utxo直到金额足够（包括手续费）
+func selectInOrder(utxos []UTXO, params SelectionParams) (*CoinSelection, error) {
+	var selected []UTXO
+	for _, utxo := range utxos {
+		if effectiveValue(utxo, params.FeeRate) <= 0 {
+			//花费它的手续费比它本身还多
+			continue
+		}
+		selected = append(selected, utxo)
+		selection, err := finishSelection(selected, params)
+		if err == nil {
+			return selection, nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
+
+//大额优先：input个数最少，手续费最低，但会产生较大的找零
+type LargestFirstSelector struct{}
+
+func (s *LargestFirstSelector) Select(utxos []UTXO, params SelectionParams) (*CoinSelection, error) {
+	sorted := append([]UTXO{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Value > sorted[j].Output.Value
+	})
+	return selectInOrder(sorted, params)
+}
+
+//小额优先：可以整理零散的utxo，但input多，手续费高
+type SmallestFirstSelector struct{}
+
+func (s *SmallestFirstSelector) Select(utxos []UTXO, params SelectionParams) (*CoinSelection, error) {
+	sorted := append([]UTXO{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Value < sorted[j].Output.Value
+	})
+	return selectInOrder(sorted, params)
+}
+
+//分支定界：寻找金额刚好满足（误差不超过找零成本）的组合，这样就不需要找零
+//不产生找零既省手续费，也不会暴露哪个output是找零
+//找不到时使用Fallback策略，Fallback为空时返回ErrNoExactMatch
+type BranchAndBoundSelector struct {
+	Fallback CoinSelector
+}
+
+func (s *BranchAndBoundSelector) Select(utxos []UTXO, params SelectionParams) (*CoinSelection, error) {
+	selected := s.search(utxos, params)
+	if selected != nil {
+		return finishSelection(selected, params)
+	}
+	if s.Fallback != nil {
+		return s.Fallback.Select(utxos, params)
+	}
+	return nil, ErrNoExactMatch
+}
+
+func (s *BranchAndBoundSelector) search(utxos []UTXO, params SelectionParams) []UTXO {
+	//只考虑有效金额为正的utxo，按有效金额从大到小排序
+	var pool []UTXO
+	var values []float64
+	for _, utxo := range utxos {
+		if effectiveValue(utxo, params.FeeRate) > 0 {
+			pool = append(pool, utxo)
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return effectiveValue(pool[i], params.FeeRate) > effectiveValue(pool[j], params.FeeRate)
+	})
+	for _, utxo := range pool {
+		values = append(values, effectiveValue(utxo, params.FeeRate))
+	}
+	//剩余utxo的有效金额之和，用于剪枝
+	remaining := make([]float64, len(values)+1)
+	for i := len(values) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + values[i]
+	}
+
+	//不含input的交易手续费也要由选中的utxo支付
+	low := params.Target + params.FeeRate*float64(EstimateTxSize(0, params.NumOutputs))
+	//浮点数误差
+	high := low + costOfChange(params.FeeRate) + 1e-9
+
+	tries := 0
+	var best []int
+	bestWaste := math.MaxFloat64
+	var current []int
+	var dfs func(index int, sum float64)
+	dfs = func(index int, sum float64) {
+		tries++
+		if tries > bnbMaxTries || sum > high {
+			return
+		}
+		if sum >= low {
+			//多出来的部分会成为手续费，越少越好
+			if waste := sum - low; waste < bestWaste {
+				bestWaste = waste
+				best = append([]int{}, current...)
+			}
+			return
+		}
+		if index >= len(values) || sum+remaining[index] < low {
+			return
+		}
+		//先尝试包含当前utxo，再尝试不包含
+		current = append(current, index)
+		dfs(index+1, sum+values[index])
+		current = current[:len(current)-1]
+		dfs(index+1, sum)
+	}
+	dfs(0, 0)
+
+	if best == nil {
+		return nil
+	}
+	var selected []UTXO
+	for _, i := range best {
+		selected = append(selected, pool[i])
+	}
+	return selected
+}
+
+//随机改进：先随机选择直到金额足够，再随机加入utxo让总额接近目标的两倍
+//随机选择减少了地址之间的关联，找零金额与支付金额相近，也不容易区分
+type RandomImproveSelector struct {
+	//为空时使用当前时间作为种子
+	Rand *rand.Rand
+}
+
+func (s *RandomImproveSelector) Select(utxos []UTXO, params SelectionParams) (*CoinSelection, error) {
+	r := s.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	var pool []UTXO
+	for _, utxo := range utxos {
+		if effectiveValue(utxo, params.FeeRate) > 0 {
+			pool = append(pool, utxo)
+		}
+	}
+	r.Shuffle(len(pool), func(i, j int) {
+		pool[i], pool[j] = pool[j], pool[i]
+	})
+
+	//1.随机选择，直到金额足够
+	var selected []UTXO
+	var selection *CoinSelection
+	next := 0
+	for ; next < len(pool); next++ {
+		selected = append(selected, pool[next])
+		if result, err := finishSelection(selected, params); err == nil {
+			selection = result
+			next++
+			break
+		}
+	}
+	if selection == nil {
+		return nil, ErrInsufficientFunds
+	}
+
+	//2.改进：总额越接近目标的两倍越好，但不超过三倍
+	ideal := 2 * params.Target
+	limit := 3 * params.Target
+	total := selection.Total
+	for ; next < len(pool); next++ {
+		value := pool[next].Output.Value
+		if total+value > limit {
+			continue
+		}
+		if math.Abs(ideal-(total+value)) < math.Abs(ideal-total) {
+			selected = append(selected, pool[next])
+			total += value
+		}
+	}
+	return finishSelection(selected, params)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+//每字节的手续费，花费一个input的成本是0.00148
+const testFeeRate = 0.00001
+
+func testUTXOs(values ...float64) []UTXO {
+	var utxos []UTXO
+	for i, value := range values {
+		utxos = append(utxos, UTXO{[]byte{byte(i)}, 0, TXOutput{value, nil, nil}, 0, false})
+	}
+	return utxos
+}
+
+func TestCoinSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		values   []float64
+		target   float64
+		//选中utxo的金额，从小到大
+		selected []float64
+		//是否有找零
+		change bool
+		err    error
+	}{
+		{"大额优先", &LargestFirstSelector{}, []float64{1, 5, 3}, 4, []float64{5}, true, nil},
+		{"小额优先", &SmallestFirstSelector{}, []float64{1, 5, 3}, 4, []float64{1, 3, 5}, true, nil},
+		//1加3刚好等于目标，但是不够支付手续费
+		{"小额优先需要手续费", &SmallestFirstSelector{}, []float64{1, 3, 5}, 3.999, []float64{1, 3, 5}, true, nil},
+		//花费0.001的手续费比它本身还多，不选择
+		{"跳过粉尘utxo", &SmallestFirstSelector{}, []float64{0.001, 2}, 1, []float64{2}, true, nil},
+		{"只有粉尘utxo", &LargestFirstSelector{}, []float64{0.001}, 0.0001, nil, false, ErrInsufficientFunds},
+		{"余额不足", &LargestFirstSelector{}, []float64{1, 2}, 3, nil, false, ErrInsufficientFunds},
+		//找零比创建找零的成本还少时作为手续费
+		{"大额优先零钱作为手续费", &LargestFirstSelector{}, []float64{1}, 0.99758, []float64{1}, false, nil},
+		//3扣除手续费之后刚好满足目标，不需要找零
+		{"分支定界精确匹配", &BranchAndBoundSelector{}, []float64{1, 2, 3, 0.5}, 2.998, []float64{3}, false, nil},
+		{"分支定界组合匹配", &BranchAndBoundSelector{}, []float64{4, 1.5, 0.7, 2.5}, 3.995, []float64{1.5, 2.5}, false, nil},
+		{"分支定界没有精确匹配", &BranchAndBoundSelector{}, []float64{5, 10}, 3, nil, false, ErrNoExactMatch},
+		{"分支定界回退到大额优先", &BranchAndBoundSelector{Fallback: &LargestFirstSelector{}}, []float64{5, 10}, 3, []float64{10}, true, nil},
+		//金额相同时与随机顺序无关: 先选4个满足目标，再加到目标的两倍
+		{"随机改进", &RandomImproveSelector{rand.New(rand.NewSource(1))}, []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 3, []float64{1, 1, 1, 1, 1, 1}, true, nil},
+		//种子固定，4排在20前面，加入20会超过目标的三倍
+		{"随机改进不超过三倍", &RandomImproveSelector{rand.New(rand.NewSource(1))}, []float64{4, 20}, 3, []float64{4}, true, nil},
+	}
+	for _, test := range tests {
+		params := SelectionParams{test.target, 1, testFeeRate}
+		selection, err := test.selector.Select(testUTXOs(test.values...), params)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: 错误应该是%v，实际是%v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var selected []float64
+		total := 0.0
+		for _, utxo := range selection.UTXOs {
+			selected = append(selected, utxo.Output.Value)
+			total += utxo.Output.Value
+		}
+		sort.Float64s(selected)
+		if !equalFloats(selected, test.selected) {
+			t.Errorf("%s: 选中的utxo是%v，应该是%v", test.name, selected, test.selected)
+		}
+		if (selection.Change > 0) != test.change {
+			t.Errorf("%s: 找零%f", test.name, selection.Change)
+		}
+		//总额 = 支付金额 + 手续费 + 找零，手续费不低于按交易大小估算的值
+		numOutputs := params.NumOutputs
+		if selection.Change > 0 {
+			numOutputs++
+		}
+		minFee := testFeeRate * float64(EstimateTxSize(len(selection.UTXOs), numOutputs))
+		if math.Abs(selection.Total-total) > 1e-9 || math.Abs(total-test.target-selection.Fee-selection.Change) > 1e-9 || selection.Fee < minFee-1e-9 {
+			t.Errorf("%s: 总额%f 手续费%f 找零%f", test.name, selection.Total, selection.Fee, selection.Change)
+		}
+	}
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
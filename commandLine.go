@@ -1,6 +1,34 @@
 package main
 
-import "fmt"
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+//设置挖矿交易成熟需要的区块数
+func (cli *CLI) SetCoinbaseMaturity(maturity uint64) {
+	if err := cli.bc.SetCoinbaseMaturity(maturity); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("挖矿交易需要%d个区块才能花费\n", maturity)
+}
+
+//重新校验整条区块链，打印用时
+func (cli *CLI) VerifyChain(workers int) {
+	start := time.Now()
+	blocks, inputs, err := cli.bc.VerifyChain(workers)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Printf("区块链校验失败: %s\n", err)
+		return
+	}
+	fmt.Printf("区块链校验通过! 区块数: %d input数: %d 线程数: %d 用时: %s\n", blocks, inputs, workers, elapsed)
+	if inputs > 0 {
+		fmt.Printf("平均每个input: %s\n", elapsed/time.Duration(inputs))
+	}
+}
 
 func (cli *CLI) GetBalance(address string) {
 	//1.校验地址
@@ -10,16 +38,83 @@ func (cli *CLI) GetBalance(address string) {
 	}
 	//2.生成公钥哈希
 	pubKeyHash := GetPubKeyHashFromAddress(address)
-	utxos := cli.bc.FindUTXOs(pubKeyHash)
-	total := 0.0
-	for _, utxo := range utxos {
-		total += utxo.Value
+	total := cli.bc.GetBalanceByPubKeyHash(pubKeyHash)
+	ws := NewWallets()
+	if ws.IsWatchOnly(address) {
+		fmt.Printf("\"%s\"(watch-only)的余额为: %f\n", address, total)
+		return
 	}
 	fmt.Printf("\"%s\"的余额为: %f\n", address, total)
 }
 
-func (cli *CLI) Send(from, to string, amount float64, miner, data string) {
+//统计钱包中所有地址的余额，watch-only地址单独标识，不计入可花费余额
+func (cli *CLI) GetWalletBalance() {
+	ws := NewWallets()
+	spendable := 0.0
+	watchOnly := 0.0
+	for _, address := range ws.ListAllAddresses() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(address))
+		spendable += balance
+		fmt.Printf("地址: %s 余额: %f\n", address, balance)
+	}
+	for _, address := range ws.ListWatchOnlyAddresses() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(address))
+		watchOnly += balance
+		fmt.Printf("地址: %s 余额: %f (watch-only)\n", address, balance)
+	}
+	//多重签名地址需要其他签名方配合才能花费，也单独统计
+	multiSig := 0.0
+	for _, address := range ws.ListMultiSigAddresses() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(address))
+		multiSig += balance
+		info := ws.MultiSigMap[address]
+		fmt.Printf("地址: %s 余额: %f (multisig %d-of-%d)\n", address, balance, info.M, len(info.PubKeys))
+	}
+	for _, address := range ws.ListMuSigAddresses() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(address))
+		multiSig += balance
+		fmt.Printf("地址: %s 余额: %f (musig %d-of-%d)\n", address, balance, len(ws.MuSigMap[address].PubKeys), len(ws.MuSigMap[address].PubKeys))
+	}
+	//时间锁地址到期之前不能花费
+	timeLocked := 0.0
+	for _, address := range ws.ListTimeLockAddresses() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(address))
+		timeLocked += balance
+		fmt.Printf("地址: %s 余额: %f (%s)\n", address, balance, ws.TimeLockMap[address])
+	}
+	fmt.Printf("可花费余额: %f\n", spendable)
+	fmt.Printf("watch-only余额: %f\n", watchOnly)
+	fmt.Printf("多重签名余额: %f\n", multiSig)
+	fmt.Printf("时间锁余额: %f\n", timeLocked)
+}
+
+//打印指定地址的交易记录
+func (cli *CLI) GetHistory(address string) {
+	if !IsValidAddress(address) {
+		fmt.Printf("地址无效: %s\n", address)
+		return
+	}
+	ws := NewWallets()
+	if ws.IsWatchOnly(address) {
+		fmt.Printf("\"%s\"(watch-only)的交易记录:\n", address)
+	} else {
+		fmt.Printf("\"%s\"的交易记录:\n", address)
+	}
+	history := cli.bc.FindTransactionHistory(GetPubKeyHashFromAddress(address))
+	for _, record := range history {
+		timeFormat := time.Unix(int64(record.TimeStamp), 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("时间: %s 交易id: %x 收入: %f 支出: %f 区块: %x\n",
+			timeFormat, record.TXID, record.Received, record.Sent, record.BlockHash)
+	}
+}
+
+func (cli *CLI) Send(from, to string, amount float64, miner, data string, opts SendOptions) {
 	//fmt.Printf("from: %s,to: %s,amount: %f,miner: %s,data: %s\n", from, to, amount, miner, data)
+	//收款方可以是通讯录中的联系人标签
+	ws := NewWallets()
+	if address, ok := ws.ResolveAddress(to); ok {
+		to = address
+	}
 	//1.校验地址
 	if !IsValidAddress(from) {
 		fmt.Printf("地址无效 from: %s\n", from)
@@ -35,24 +130,106 @@ func (cli *CLI) Send(from, to string, amount float64, miner, data string) {
 		fmt.Printf("地址无效 miner: %s\n", miner)
 		return
 	}
-	//1.创建挖矿交易
-	coinbase := NewCoinbaseTX(miner, data)
-	//2.创建普通交易
-	tx := NewTransaction(from, to, amount, cli.bc)
+	//矿工数据保存在挖矿交易的数据output中，有大小限制
+	if len(data) > maxDataCarrierSize {
+		fmt.Printf("区块数据不能超过%d字节\n", maxDataCarrierSize)
+		return
+	}
+	//1.创建普通交易
+	tx := NewTransactionToMany(from, []TXOutput{*NewTXOutput(amount, to)}, opts, cli.bc)
 	if tx == nil {
 		fmt.Printf("无效的交易")
 		return
 	}
-	//3.将交易添加到区块
-	cli.bc.AddBlock([]*Transaction{coinbase, tx})
+	//2.将交易添加到区块，挖矿交易领取手续费
+	if !cli.bc.MineBlock(miner, data, tx) {
+		return
+	}
 	fmt.Printf("转账成功!\n")
 }
 
-func (cli *CLI) NewWallet() {
+//一笔交易向多个收款方转账，recipients格式 address:amount,...
+func (cli *CLI) SendMany(from, recipients, miner, data string, opts SendOptions) {
+	if !IsValidAddress(from) {
+		fmt.Printf("地址无效 from: %s\n", from)
+		return
+	}
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	if len(data) > maxDataCarrierSize {
+		fmt.Printf("区块数据不能超过%d字节\n", maxDataCarrierSize)
+		return
+	}
+	outputs, err := ParseRawOutputs(recipients, NewWallets())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tx := NewTransactionToMany(from, outputs, opts, cli.bc)
+	if tx == nil {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	if !cli.bc.MineBlock(miner, data, tx) {
+		return
+	}
+	fmt.Printf("转账成功! 收款方个数: %d\n", len(outputs))
+}
+
+//设置钱包默认的选币策略
+func (cli *CLI) SetCoinSelector(name string) {
+	ws := NewWallets()
+	err := ws.SetCoinSelector(name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("默认选币策略设置为: %s\n", name)
+}
+
+//列举未花费的output，不指定地址时列举钱包中所有地址（包括watch-only）的utxo
+func (cli *CLI) ListUnspent(address string) {
+	ws := NewWallets()
+	var addresses []string
+	if address != "" {
+		if !IsValidAddress(address) {
+			fmt.Printf("地址无效: %s\n", address)
+			return
+		}
+		addresses = []string{address}
+	} else {
+		addresses = append(ws.ListAllAddresses(), ws.ListWatchOnlyAddresses()...)
+		addresses = append(addresses, ws.ListMultiSigAddresses()...)
+		addresses = append(addresses, ws.ListTimeLockAddresses()...)
+	}
+	height, _ := cli.bc.NextBlockInfo()
+	maturity := cli.bc.CoinbaseMaturity()
+	for _, addr := range addresses {
+		watchOnly := ""
+		if ws.IsWatchOnly(addr) {
+			watchOnly = " (watch-only)"
+		} else if info := ws.MultiSigMap[addr]; info != nil {
+			watchOnly = fmt.Sprintf(" (multisig %d-of-%d)", info.M, len(info.PubKeys))
+		} else if timeLock := ws.TimeLockMap[addr]; timeLock != nil {
+			watchOnly = fmt.Sprintf(" (%s)", timeLock)
+		}
+		for _, utxo := range cli.bc.FindUTXOList(GetPubKeyHashFromAddress(addr)) {
+			immature := ""
+			if !utxo.IsMature(height, maturity) {
+				immature = fmt.Sprintf(" (未成熟，还需%d个区块)", utxo.Height+maturity-height)
+			}
+			fmt.Printf("%x:%d 金额: %f 地址: %s%s%s\n", utxo.TXID, utxo.Index, utxo.Output.Value, addr, watchOnly, immature)
+		}
+	}
+}
+
+func (cli *CLI) NewWallet(keyType KeyType) {
 	//wallet := NewWallet()
 	//address := wallet.NewAddress()
 	ws := NewWallets()
-	address := ws.CreateWallet()
+	address := ws.CreateWallet(keyType)
 	fmt.Printf("地址: %s\n", address)
 	//for address := range ws.WalletsMap {
 	//	fmt.Printf("地址: %s\n", address)
@@ -61,10 +238,886 @@ func (cli *CLI) NewWallet() {
 	//fmt.Printf("公钥: %v\n", wallet.PubKey)
 }
 
+//列举钱包中的地址，包括标签、余额和创建时间，按创建时间排序
 func (cli *CLI) ListAddresses() {
 	ws := NewWallets()
-	addresses := ws.ListAllAddresses()
-	for _, address := range addresses {
-		fmt.Printf("地址: %s\n", address)
+	for _, info := range ws.ListAddressInfos() {
+		balance := cli.bc.GetBalanceByPubKeyHash(GetPubKeyHashFromAddress(info.Address))
+		createTime := "未知"
+		if info.CreateTime != 0 {
+			createTime = time.Unix(info.CreateTime, 0).Format("2006-01-02 15:04:05")
+		}
+		watchOnly := ""
+		if info.WatchOnly {
+			watchOnly = " (watch-only)"
+		} else if muSig := ws.MuSigMap[info.Address]; muSig != nil {
+			watchOnly = fmt.Sprintf(" (musig %d-of-%d)", len(muSig.PubKeys), len(muSig.PubKeys))
+		} else if info.MultiSig {
+			multiSig := ws.MultiSigMap[info.Address]
+			watchOnly = fmt.Sprintf(" (multisig %d-of-%d)", multiSig.M, len(multiSig.PubKeys))
+		} else if timeLock := ws.TimeLockMap[info.Address]; timeLock != nil {
+			watchOnly = fmt.Sprintf(" (%s)", timeLock)
+		}
+		fmt.Printf("地址: %s 标签: \"%s\" 余额: %f 创建时间: %s%s\n",
+			info.Address, info.Label, balance, createTime, watchOnly)
+	}
+}
+
+func (cli *CLI) SetLabel(address, label string) {
+	ws := NewWallets()
+	err := ws.SetLabel(address, label)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("地址 %s 的标签设置为: \"%s\"\n", address, label)
+}
+
+func (cli *CLI) GetLabel(address string) {
+	ws := NewWallets()
+	label, ok := ws.Labels[address]
+	if !ok {
+		fmt.Printf("地址 %s 没有设置标签\n", address)
+		return
+	}
+	fmt.Printf("地址 %s 的标签为: \"%s\"\n", address, label)
+}
+
+func (cli *CLI) AddContact(label, address string) {
+	ws := NewWallets()
+	err := ws.AddContact(label, address)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("添加联系人成功: %s -> %s\n", label, address)
+}
+
+func (cli *CLI) RemoveContact(label string) {
+	ws := NewWallets()
+	err := ws.RemoveContact(label)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("删除联系人成功: %s\n", label)
+}
+
+func (cli *CLI) ListContacts() {
+	ws := NewWallets()
+	for _, label := range ws.ListContacts() {
+		fmt.Printf("联系人: %s 地址: %s\n", label, ws.AddressBook[label])
+	}
+}
+
+func (cli *CLI) ImportAddress(address string) {
+	ws := NewWallets()
+	err := ws.AddWatchOnlyAddress(address)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("导入watch-only地址成功: %s\n", address)
+}
+
+//公钥使用16进制字符串传入
+func (cli *CLI) ImportPubKey(pubKeyHex string) {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		fmt.Printf("公钥格式错误: %s\n", pubKeyHex)
+		return
+	}
+	ws := NewWallets()
+	address, err := ws.AddWatchOnlyPubKey(pubKey)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("导入watch-only公钥成功，地址: %s\n", address)
+}
+
+//打印钱包中地址对应的公钥，用于在其他节点导入watch-only
+func (cli *CLI) DumpPubKey(address string) {
+	ws := NewWallets()
+	wallet := ws.WalletsMap[address]
+	if wallet == nil {
+		fmt.Printf("钱包中没有该地址: %s\n", address)
+		return
+	}
+	fmt.Printf("公钥: %x\n", wallet.PubKey)
+	fmt.Printf("密钥类型: %s\n", wallet.KeyType)
+}
+
+//校验地址并打印地址类型以及与钱包的关系
+func (cli *CLI) ValidateAddress(address string) {
+	hash, addressType, err := DecodeAddress(address)
+	if err != nil {
+		fmt.Printf("地址无效: %s (%s)\n", address, err)
+		return
+	}
+	ws := NewWallets()
+	owner := "否"
+	if ws.WalletsMap[address] != nil {
+		owner = "是"
+	} else if ws.IsWatchOnly(address) {
+		owner = "watch-only"
+	} else if info := ws.MultiSigMap[address]; info != nil {
+		owner = fmt.Sprintf("multisig %d-of-%d", info.M, len(info.PubKeys))
+	}
+	fmt.Printf("地址: %s\n", address)
+	fmt.Printf("类型: %s\n", addressType)
+	fmt.Printf("hash: %x\n", hash)
+	fmt.Printf("锁定脚本: %s\n", DisasmScript(NewTXOutput(0, address).LockingScript()))
+	fmt.Printf("属于本钱包: %s\n", owner)
+}
+
+//创建时间锁地址，到期之前转入的金额不能花费
+//绝对时间锁的lockTime是区块高度或unix时间，相对时间锁的lockTime是区块个数
+func (cli *CLI) CreateTimeLock(owner string, lockTime uint64, relative bool) {
+	ws := NewWallets()
+	if relative {
+		if lockTime > SequenceLockTimeMask {
+			fmt.Printf("相对时间锁的区块个数不能超过%d\n", SequenceLockTimeMask)
+			return
+		}
+		lockTime = uint64(SequenceFromBlocks(uint32(lockTime)))
+	}
+	timeLock, err := ws.AddTimeLock(owner, lockTime, relative)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("地址: %s\n", timeLock.Address)
+	fmt.Printf("赎回脚本: %x\n", timeLock.RedeemScript)
+	fmt.Printf("脚本: %s\n", DisasmScript(timeLock.RedeemScript))
+}
+
+//发起原子交换：生成秘密值，在本链上创建收款方为participant的合约
+func (cli *CLI) InitiateSwap(from, participant string, amount float64, miner string, opts SendOptions) {
+	secret, secretHash := NewSwapSecret()
+	lockTime := int64(opts.LockTime)
+	if lockTime == 0 {
+		lockTime = defaultSwapLockTime(initiatorLockDuration)
+	}
+	if cli.fundSwapContract(from, participant, secretHash, lockTime, amount, miner) {
+		fmt.Printf("秘密值: %x （兑换之前不要泄露）\n", secret)
+	}
+}
+
+//参与原子交换：使用发起方的秘密值hash，在本链上创建收款方为initiator的合约
+//超时时间必须比发起方的合约短
+func (cli *CLI) ParticipateSwap(from, initiator string, amount float64, secretHashHex, miner string, opts SendOptions) {
+	secretHash, err := hex.DecodeString(secretHashHex)
+	if err != nil {
+		fmt.Printf("秘密值hash格式错误: %s\n", secretHashHex)
+		return
+	}
+	lockTime := int64(opts.LockTime)
+	if lockTime == 0 {
+		lockTime = defaultSwapLockTime(participantLockDuration)
+	}
+	cli.fundSwapContract(from, initiator, secretHash, lockTime, amount, miner)
+}
+
+//创建合约并由from付款到合约的P2SH地址，成功时返回true
+func (cli *CLI) fundSwapContract(from, recipient string, secretHash []byte, lockTime int64, amount float64, miner string) bool {
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return false
+	}
+	contract, err := NewSwapContract(secretHash, recipient, from, lockTime)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	address := ScriptHashToAddress(hash160(contract))
+	tx := NewTransactionToMany(from, []TXOutput{*NewTXOutput(amount, address)}, SendOptions{}, cli.bc)
+	if tx == nil {
+		fmt.Printf("无效的交易\n")
+		return false
+	}
+	if !cli.bc.MineBlock(miner, "", tx) {
+		return false
+	}
+	fmt.Printf("秘密值hash: %x\n", secretHash)
+	fmt.Printf("合约: %x\n", contract)
+	fmt.Printf("合约地址: %s\n", address)
+	fmt.Printf("合约交易id: %x\n", tx.TXID)
+	return true
+}
+
+//审核对方创建的合约，确认收款方、金额和超时时间
+func (cli *CLI) AuditSwap(contractHex string) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		fmt.Printf("合约格式错误\n")
+		return
+	}
+	htlc, ok := ExtractHTLC(contract)
+	if !ok {
+		fmt.Printf("不是有效的原子交换合约\n")
+		return
+	}
+	fmt.Printf("合约地址: %s\n", ScriptHashToAddress(hash160(contract)))
+	fmt.Printf("合约余额: %f\n", cli.bc.GetBalanceByPubKeyHash(hash160(contract)))
+	fmt.Printf("收款方: %s\n", PubKeyHashToAddress(htlc.RecipientHash))
+	fmt.Printf("退款地址: %s\n", PubKeyHashToAddress(htlc.RefundHash))
+	fmt.Printf("秘密值hash: %x\n", htlc.SecretHash)
+	if htlc.LockTime < LockTimeThreshold {
+		fmt.Printf("超时: 区块高度%d\n", htlc.LockTime)
+	} else {
+		fmt.Printf("超时: %s\n", time.Unix(htlc.LockTime, 0).Format("2006-01-02 15:04:05"))
+	}
+}
+
+//使用秘密值兑换合约中的资金，转到to
+func (cli *CLI) RedeemSwap(contractHex, secretHex, to, miner string) {
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil || len(secret) == 0 {
+		fmt.Printf("秘密值格式错误\n")
+		return
+	}
+	cli.spendSwapContract(contractHex, secret, to, miner)
+}
+
+//超时后取回合约中的资金，转到to
+func (cli *CLI) RefundSwap(contractHex, to, miner string) {
+	cli.spendSwapContract(contractHex, nil, to, miner)
+}
+
+func (cli *CLI) spendSwapContract(contractHex string, secret []byte, to, miner string) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		fmt.Printf("合约格式错误\n")
+		return
+	}
+	if !IsValidAddress(to) {
+		fmt.Printf("地址无效 to: %s\n", to)
+		return
+	}
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	tx, err := NewSwapSpendTransaction(contract, secret, to, NewWallets(), cli.bc)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !cli.bc.VerifyTransaction(tx) {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	if err := cli.bc.CheckLocksForNextBlock(tx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !cli.bc.MineBlock(miner, "", tx) {
+		return
+	}
+	fmt.Printf("交易已打包: %x\n", tx.TXID)
+}
+
+//从对方的兑换交易中提取秘密值
+func (cli *CLI) ExtractSecret(txidHex, secretHashHex string) {
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		fmt.Printf("交易id格式错误\n")
+		return
+	}
+	secretHash, err := hex.DecodeString(secretHashHex)
+	if err != nil {
+		fmt.Printf("秘密值hash格式错误\n")
+		return
+	}
+	tx, err := cli.bc.FindTransactionByTXid(txid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	secret, err := ExtractSwapSecret(&tx, secretHash)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("秘密值: %x\n", secret)
+}
+
+//对文件做存证，多个文件合并成一棵默克尔树，由from支付交易，miner挖矿打包
+//每个文件生成一个凭证文件 FILE.receipt
+func (cli *CLI) Notarize(from, miner string, files []string, opts SendOptions) {
+	if !IsValidAddress(from) {
+		fmt.Printf("地址无效 from: %s\n", from)
+		return
+	}
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	var fileHashes [][]byte
+	for _, file := range files {
+		hash, err := HashFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fileHashes = append(fileHashes, hash)
+	}
+	receipts := NewNotaryReceipts(fileHashes)
+
+	//交易中只有存证数据和找零
+	opts.Data = NotaryData(receipts[0].MerkleRoot)
+	tx := NewTransactionToMany(from, nil, opts, cli.bc)
+	if tx == nil {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	if !cli.bc.MineBlock(miner, "", tx) {
+		return
+	}
+	block, err := cli.bc.FindTransactionBlock(tx.TXID)
+	if err != nil {
+		fmt.Printf("存证交易没有被打包\n")
+		return
+	}
+
+	fmt.Printf("默克尔根: %x\n", receipts[0].MerkleRoot)
+	fmt.Printf("交易id: %x\n", tx.TXID)
+	fmt.Printf("区块hash: %x\n", block.Hash)
+	for i, receipt := range receipts {
+		receipt.TXID = tx.TXID
+		receipt.BlockHash = block.Hash
+		receiptFile := files[i] + receiptSuffix
+		if err := receipt.SaveToFile(receiptFile); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("文件: %s hash: %x 凭证: %s\n", files[i], receipt.FileHash, receiptFile)
+	}
+}
+
+//使用本地区块链校验文件的存证凭证
+func (cli *CLI) VerifyReceipt(file, receiptFile string) {
+	receipt, err := LoadReceiptFile(receiptFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fileHash, err := HashFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	block, err := receipt.Verify(fileHash, cli.bc)
+	if err != nil {
+		fmt.Printf("凭证无效: %s\n", err)
+		return
+	}
+	tip := cli.bc.NewIterator().Next()
+	timeFormat := time.Unix(int64(block.TimeStamp), 0).Format("2006-01-02 15:04:05")
+	fmt.Printf("凭证有效!\n")
+	fmt.Printf("文件hash: %x\n", fileHash)
+	fmt.Printf("交易id: %x\n", receipt.TXID)
+	fmt.Printf("区块高度: %d 区块hash: %x\n", block.Height, block.Hash)
+	fmt.Printf("文件存在时间不晚于: %s (确认数: %d)\n", timeFormat, tip.Height-block.Height+1)
+}
+
+//创建MuSig聚合公钥地址，参数可以是16进制的Schnorr公钥，也可以是本地钱包的地址
+func (cli *CLI) CreateMuSig(keys []string) {
+	ws := NewWallets()
+	var pubKeys [][]byte
+	for _, key := range keys {
+		pubKey := []byte(nil)
+		if wallet := ws.WalletsMap[key]; wallet != nil {
+			pubKey = wallet.PubKey
+		} else {
+			var err error
+			pubKey, err = hex.DecodeString(key)
+			if err != nil {
+				fmt.Printf("公钥格式错误: %s\n", key)
+				return
+			}
+		}
+		if GetPubKeyType(pubKey) != KeyTypeSchnorr {
+			fmt.Printf("MuSig只支持Schnorr公钥: %s\n", key)
+			return
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	muSig, err := ws.AddMuSig(pubKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("地址: %s\n", muSig.Address)
+	fmt.Printf("聚合公钥: %x\n", muSig.AggKey)
+}
+
+//创建M-of-N多重签名地址，参数可以是16进制公钥，也可以是本地钱包的地址
+func (cli *CLI) CreateMultisig(m int, keys []string) {
+	ws := NewWallets()
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if wallet := ws.WalletsMap[key]; wallet != nil {
+			pubKeys = append(pubKeys, wallet.PubKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil || len(pubKey) == 0 {
+			fmt.Printf("公钥格式错误: %s\n", key)
+			return
+		}
+		if _, _, err := ParsePubKey(pubKey); err != nil {
+			fmt.Printf("公钥无效: %s (%s)\n", key, err)
+			return
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	multiSig, err := ws.AddMultiSig(m, pubKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("地址: %s\n", multiSig.Address)
+	fmt.Printf("赎回脚本: %x\n", multiSig.RedeemScript)
+	fmt.Printf("脚本: %s\n", DisasmScript(multiSig.RedeemScript))
+}
+
+//创建PSBT，付款地址可以是watch-only地址，签名在其他机器上完成
+func (cli *CLI) CreatePSBT(from, to string, amount float64, file string, opts SendOptions) {
+	ws := NewWallets()
+	if address, ok := ws.ResolveAddress(to); ok {
+		to = address
+	}
+	if !IsValidAddress(from) {
+		fmt.Printf("地址无效 from: %s\n", from)
+		return
+	}
+	if !IsValidAddress(to) {
+		fmt.Printf("地址无效 to: %s\n", to)
+		return
+	}
+	//公钥可以为空，由签名方补充
+	var pubKey []byte
+	if wallet := ws.WalletsMap[from]; wallet != nil {
+		pubKey = wallet.PubKey
+	} else if watchOnly := ws.WatchOnlyMap[from]; watchOnly != nil {
+		pubKey = watchOnly.PubKey
+	} else if muSig := ws.MuSigMap[from]; muSig != nil {
+		pubKey = muSig.AggKey
+	} else if timeLock := ws.TimeLockMap[from]; timeLock != nil {
+		//花费时间锁地址时自动设置锁定时间，找零给所有者
+		if timeLock.Relative {
+			opts.Sequence = uint32(timeLock.LockTime)
+		} else {
+			opts.LockTime = timeLock.LockTime
+		}
+		if opts.ChangeAddress == "" {
+			opts.ChangeAddress = timeLock.Owner
+		}
+	}
+	tx := NewUnsignedTransactionToMany(from, []TXOutput{*NewTXOutput(amount, to)}, pubKey, opts, cli.bc)
+	if tx == nil {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	psbt, err := NewPSBT(tx, ws, cli.bc)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = psbt.SaveToFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("PSBT已保存到: %s 交易id: %x\n", file, tx.TXID)
+}
+
+//打印PSBT的内容
+func (cli *CLI) DecodePSBT(file string) {
+	psbt, err := LoadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("交易id: %x\n", psbt.Tx.TXID)
+	for i, input := range psbt.Tx.TXInputs {
+		in := psbt.Inputs[i]
+		count, required := psbt.SignatureCount(i)
+		fmt.Printf("input[%d]: 引用交易: %x 索引: %d 金额: %f 签名数: %d/%d\n",
+			i, input.TXid, input.Index, in.prevOutput.Value, count, required)
+		if in.MuSigPubKeys != nil {
+			fmt.Printf("\tMuSig nonce数: %d/%d\n", len(in.MuSigNonces), len(in.MuSigPubKeys))
+		}
+	}
+	for i, output := range psbt.Tx.TXOutputs {
+		fmt.Printf("output[%d]: 金额: %f 公钥hash: %x\n", i, output.Value, output.PubKeyHash)
+	}
+	fmt.Printf("手续费: %f\n", psbt.Fee())
+}
+
+//使用本地钱包签名PSBT，不需要区块链，签名结果写回文件
+func (cli *CLI) SignPSBT(file string, hashType SigHashType) {
+	psbt, err := LoadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ws := NewWallets()
+	count := psbt.Sign(ws, hashType)
+	if count == 0 {
+		fmt.Printf("钱包中没有可以签名的私钥\n")
+		return
+	}
+	//MuSig的秘密nonce保存在钱包中
+	ws.SaveToFile()
+	err = psbt.SaveToFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("新增%d个签名，已保存到: %s\n", count, file)
+}
+
+//合并多个签名方的PSBT
+func (cli *CLI) CombinePSBT(out string, files []string) {
+	var psbt *PartiallySignedTransaction
+	for _, file := range files {
+		other, err := LoadPSBTFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if psbt == nil {
+			psbt = other
+			continue
+		}
+		err = psbt.Combine(other)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	err := psbt.SaveToFile(out)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("合并完成，已保存到: %s\n", out)
+}
+
+//最终确定PSBT，校验通过后由miner打包进区块
+func (cli *CLI) FinalizePSBT(file, miner string) {
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	psbt, err := LoadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tx, err := psbt.Finalize()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !cli.bc.VerifyTransaction(tx) {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	if err := cli.bc.CheckLocksForNextBlock(tx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !cli.bc.MineBlock(miner, "", tx) {
+		return
+	}
+	fmt.Printf("交易已广播: %x\n", tx.TXID)
+}
+
+//创建原始交易，inputs格式 txid:index,... outputs格式 address:amount,...
+func (cli *CLI) CreateRawTransaction(inputsStr, outputsStr string, lockTime uint64, replaceable bool) {
+	inputs, err := ParseRawInputs(inputsStr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	outputs, err := ParseRawOutputs(outputsStr, NewWallets())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	//没有指定序列号的input使用允许替换的序列号
+	if replaceable {
+		for i := range inputs {
+			if inputs[i].Sequence == SequenceDefault {
+				inputs[i].Sequence = MaxRBFSequence
+			}
+		}
+	}
+	tx := NewRawTransaction(inputs, outputs, lockTime)
+	fmt.Printf("%s\n", EncodeRawTransaction(tx))
+}
+
+//打印原始交易的内容，能在区块链中找到引用的交易时同时打印手续费
+func (cli *CLI) DecodeRawTransaction(rawTx string) {
+	tx, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("交易id: %x\n", tx.TXID)
+	if !tx.HasValidTXID() {
+		fmt.Printf("交易id与交易内容不一致，应为: %x\n", tx.Hash())
+	}
+	fmt.Printf("wtxid: %x\n", tx.WitnessHash())
+	fmt.Printf("大小: %d 去掉见证数据: %d 权重: %d\n", tx.TotalSize(), tx.BaseSize(), tx.Weight())
+	if tx.LockTime != 0 {
+		fmt.Printf("锁定时间: %d\n", tx.LockTime)
+	}
+	for i, input := range tx.TXInputs {
+		signed := len(input.Signature) != 0 || len(input.ScriptSig) != 0
+		fmt.Printf("input[%d]: 引用交易: %x 索引: %d 序列号: %d 已签名: %t\n",
+			i, input.TXid, input.Index, input.Sequence, signed)
+		if signed {
+			fmt.Printf("\t解锁脚本: %s\n", DisasmScript(input.UnlockingScript()))
+		}
+		if _, hashType, err := SplitSignature(input.Signature); err == nil {
+			fmt.Printf("\t签名类型: %s\n", hashType)
+		}
+	}
+	for i, output := range tx.TXOutputs {
+		if data, ok := ExtractData(output.ScriptPubKey); ok {
+			fmt.Printf("output[%d]: 数据: %x (%q)\n", i, data, data)
+			continue
+		}
+		address := output.Address()
+		if address == "" {
+			address = "非标准脚本"
+		}
+		fmt.Printf("output[%d]: 地址: %s 金额: %f\n", i, address, output.Value)
+		fmt.Printf("\t锁定脚本: %s\n", DisasmScript(output.LockingScript()))
+	}
+	if tx.IsCoinbase() {
+		fmt.Printf("挖矿交易\n")
+		return
+	}
+	prevTXs, err := cli.bc.FindPrevTransactionsWithMempool(tx)
+	if err != nil {
+		fmt.Printf("手续费: 未知(%s)\n", err)
+		return
+	}
+	fee := tx.Fee(prevTXs)
+	fmt.Printf("手续费: %f 手续费率: %.8f\n", fee, fee/float64(tx.VirtualSize()))
+	fmt.Printf("允许替换: %t\n", tx.SignalsReplacement())
+}
+
+//打印16进制脚本的可读形式
+func (cli *CLI) DecodeScript(scriptHex string) {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		fmt.Printf("脚本不是有效的16进制字符串\n")
+		return
+	}
+	fmt.Printf("脚本: %s\n", DisasmScript(script))
+	if pubKeyHash, ok := ExtractP2PKHPubKeyHash(script); ok {
+		fmt.Printf("类型: P2PKH 地址: %s\n", PubKeyHashToAddress(pubKeyHash))
+	} else if data, ok := ExtractData(script); ok {
+		fmt.Printf("类型: 数据(不可花费) 数据: %x (%q)\n", data, data)
+	} else {
+		fmt.Printf("类型: 非标准脚本\n")
+	}
+}
+
+//使用本地钱包签名原始交易，打印签名后的原始交易
+func (cli *CLI) SignRawTransaction(rawTx string, hashType SigHashType) {
+	tx, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	prevTXs, err := cli.bc.FindPrevTransactionsWithMempool(tx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	count, complete := SignRawTransaction(tx, NewWallets(), prevTXs, hashType)
+	fmt.Printf("新增签名: %d 签名完成: %t\n", count, complete)
+	fmt.Printf("%s\n", EncodeRawTransaction(tx))
+}
+
+//合并多个原始交易，输出合并之后的16进制编码
+func (cli *CLI) CombineRawTransaction(rawTxs []string) {
+	var txs []*Transaction
+	for _, rawTx := range rawTxs {
+		tx, err := DecodeRawTransaction(rawTx)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		txs = append(txs, tx)
+	}
+	tx, err := CombineRawTransactions(txs)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("合并后input: %d output: %d\n", len(tx.TXInputs), len(tx.TXOutputs))
+	fmt.Printf("%s\n", EncodeRawTransaction(tx))
+}
+
+//校验原始交易，指定miner时直接挖矿打包，否则放入交易池
+func (cli *CLI) SendRawTransaction(rawTx, miner string) {
+	tx, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if miner == "" {
+		err = cli.bc.AddToMempool(tx)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("交易已加入交易池: %x\n", tx.TXID)
+		return
+	}
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	if !cli.bc.VerifyTransaction(tx) {
+		fmt.Printf("无效的交易\n")
+		return
+	}
+	if err := cli.bc.CheckLocksForNextBlock(tx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !cli.bc.MineBlock(miner, "", tx) {
+		return
+	}
+	fmt.Printf("交易已打包: %x\n", tx.TXID)
+}
+
+//把交易池中的交易按交易包的手续费率打包进新的区块，手续费率低于minFeeRate的交易留在交易池中
+func (cli *CLI) Mine(miner string, minFeeRate float64) {
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	template, err := cli.bc.NewBlockTemplate(minFeeRate)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	coinbase := template.NewCoinbase(miner, "")
+	if !cli.bc.AddBlock(append([]*Transaction{coinbase}, template.Transactions()...)) {
+		return
+	}
+	fmt.Printf("打包了%d笔交易，交易池中还有%d笔\n", len(template.Entries), template.Remaining)
+}
+
+//打印下一个区块的模板
+func (cli *CLI) GetBlockTemplate(minFeeRate float64) {
+	template, err := cli.bc.NewBlockTemplate(minFeeRate)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	template.Print()
+}
+
+//启动stratum挖矿服务器，一直运行
+func (cli *CLI) StartStratum(address, miner string, shareDifficulty float64) {
+	if !IsValidAddress(miner) {
+		fmt.Printf("地址无效 miner: %s\n", miner)
+		return
+	}
+	server := NewStratumServer(cli.bc, miner, shareDifficulty, nil)
+	if err := server.ListenAndServe(address); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//以矿池模式启动stratum服务器，一直运行
+func (cli *CLI) StartPool(address, poolAddress string, shareDifficulty float64, pplnsShares int, poolFee float64) {
+	if !IsValidAddress(poolAddress) {
+		fmt.Printf("地址无效 pool: %s\n", poolAddress)
+		return
+	}
+	pool, err := NewPool(cli.bc, poolAddress, pplnsShares, poolFee)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	server := NewStratumServer(cli.bc, poolAddress, shareDifficulty, pool)
+	if err := server.ListenAndServe(address); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//查询正在运行的矿池的统计，不需要区块链数据库
+func (cli *CLI) PoolStats(address string) {
+	stats, err := QueryPoolStats(address)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	stats.Print()
+}
+
+//连接stratum服务器挖矿，不需要区块链数据库
+func (cli *CLI) StratumMiner(address, worker string, blocks int) {
+	if err := RunStratumMiner(address, worker, blocks); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//估计在target个区块内确认需要的手续费率
+func (cli *CLI) EstimateFee(target int) {
+	feeRate, err := cli.bc.EstimateFee(target)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%d个区块内确认需要的手续费率: %.8f（每虚拟字节）\n", target, feeRate)
+}
+
+//提高交易池中交易的手续费，cpfp为false时替换原交易，否则创建一笔子交易，新交易放入交易池
+func (cli *CLI) BumpFee(txidHex string, feeRate float64, cpfp bool) {
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		fmt.Printf("交易id格式错误\n")
+		return
+	}
+	ws := NewWallets()
+	var tx *Transaction
+	if cpfp {
+		tx, err = cli.bc.BumpFeeCPFP(txid, feeRate, ws)
+	} else {
+		tx, err = cli.bc.BumpFee(txid, feeRate, ws)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := cli.bc.AddToMempool(tx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("交易已加入交易池: %x\n", tx.TXID)
+	entries, err := cli.bc.GetMempoolEntries()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	entry := findMempoolEntry(entries, tx.TXID)
+	fmt.Printf("大小: %d 手续费: %.8f 手续费率: %.8f\n", entry.Size, entry.Fee, entry.FeeRate())
+	if cpfp {
+		pkg := append(MempoolAncestors(entries, tx), entry)
+		fmt.Printf("交易包: %d笔交易 手续费率: %.8f\n", len(pkg), PackageFeeRate(pkg))
 	}
 }
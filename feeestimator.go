@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/ShersBlockChain/bolt"
+	"log"
+	"math"
+)
+
+//手续费估计，参考比特币的estimatesmartfee
+//1.交易加入交易池时记录当时的区块高度和手续费率，按手续费率分到不同的区间
+//2.交易被打包时记录它等待了多少个区块，每个区间统计在1..maxConfirmTarget个区块内确认的交易数
+//3.每个新区块把所有统计值乘以feeEstimatorDecay，越新的区块影响越大
+//4.估计时从手续费率最高的区间开始向下合并区间，直到target个区块内确认的比例低于successThreshold，
+//  返回最后一组满足要求的区间的平均手续费率
+//状态保存在区块链数据库中，重启之后继续使用
+
+const feeEstimatorBucket = "feeEstimatorBucket"
+
+const feeEstimatorKey = "state"
+
+//最多估计多少个区块内确认
+const maxConfirmTarget = 25
+
+//没有指定手续费率时，send等命令使用的默认确认目标
+const defaultConfirmTarget = 6
+
+//每个区块的衰减系数，半衰期约70个区块
+const feeEstimatorDecay = 0.99
+
+//在target个区块内确认的比例至少为这个值
+const successThreshold = 0.85
+
+//一组区间至少需要的交易数（衰减之后）
+const sufficientFeeTxs = 2.0
+
+//手续费率区间: 第0个区间是[0, minBucketFeeRate)，之后每个区间的下限是前一个的feeBucketSpacing倍
+const minBucketFeeRate = 0.000001
+const maxBucketFeeRate = 1.0
+const feeBucketSpacing = 1.2
+
+//交易池中正在跟踪的交易
+type trackedFeeTX struct {
+	//加入交易池时的区块高度
+	Height  uint64
+	FeeRate float64
+}
+
+type FeeEstimator struct {
+	//每个区间已经确认的交易数
+	TxCount []float64
+	//每个区间已经确认的交易的手续费率之和，用于计算平均值
+	FeeRateSum []float64
+	//ConfCount[i][t-1]是第i个区间中在t个区块内确认的交易数
+	ConfCount [][]float64
+	//交易池中还没有确认的交易，key是交易id
+	Pending map[string]trackedFeeTX
+}
+
+//每个区间的手续费率下限
+var feeBuckets = func() []float64 {
+	buckets := []float64{0}
+	for rate := minBucketFeeRate; rate <= maxBucketFeeRate; rate *= feeBucketSpacing {
+		buckets = append(buckets, rate)
+	}
+	return buckets
+}()
+
+//手续费率所在的区间
+func feeBucketIndex(feeRate float64) int {
+	index := 0
+	for i, lower := range feeBuckets {
+		if feeRate >= lower {
+			index = i
+		}
+	}
+	return index
+}
+
+func NewFeeEstimator() *FeeEstimator {
+	estimator := FeeEstimator{
+		TxCount:    make([]float64, len(feeBuckets)),
+		FeeRateSum: make([]float64, len(feeBuckets)),
+		ConfCount:  make([][]float64, len(feeBuckets)),
+		Pending:    make(map[string]trackedFeeTX),
+	}
+	for i := range estimator.ConfCount {
+		estimator.ConfCount[i] = make([]float64, maxConfirmTarget)
+	}
+	return &estimator
+}
+
+func (estimator *FeeEstimator) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(estimator)
+	if err != nil {
+		log.Panic("编码出错")
+	}
+	return buffer.Bytes()
+}
+
+//从数据库加载手续费估计的状态，没有保存过或者区间设置改变时返回新的状态
+func loadFeeEstimator(boltTx *bolt.Tx) *FeeEstimator {
+	bucket := boltTx.Bucket([]byte(feeEstimatorBucket))
+	if bucket == nil {
+		return NewFeeEstimator()
+	}
+	data := bucket.Get([]byte(feeEstimatorKey))
+	if data == nil {
+		return NewFeeEstimator()
+	}
+	var estimator FeeEstimator
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&estimator); err != nil || len(estimator.TxCount) != len(feeBuckets) {
+		return NewFeeEstimator()
+	}
+	if estimator.Pending == nil {
+		estimator.Pending = make(map[string]trackedFeeTX)
+	}
+	return &estimator
+}
+
+func (estimator *FeeEstimator) save(boltTx *bolt.Tx) error {
+	bucket, err := boltTx.CreateBucketIfNotExists([]byte(feeEstimatorBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(feeEstimatorKey), estimator.Serialize())
+}
+
+//开始跟踪加入交易池的交易，height是当前最新区块的高度，被替换的交易不再跟踪
+func trackMempoolFee(boltTx *bolt.Tx, entry *MempoolEntry, height uint64, evicted []*MempoolEntry) error {
+	estimator := loadFeeEstimator(boltTx)
+	for _, removed := range evicted {
+		delete(estimator.Pending, string(removed.Tx.TXID))
+	}
+	estimator.Pending[string(entry.Tx.TXID)] = trackedFeeTX{height, entry.FeeRate()}
+	return estimator.save(boltTx)
+}
+
+//新区块写入后更新统计：记录跟踪的交易等待的区块数，不在交易池中的交易不再跟踪
+//需要在removeFromMempool之后调用
+func updateFeeEstimator(boltTx *bolt.Tx, block *Block) error {
+	estimator := loadFeeEstimator(boltTx)
+	for i := range feeBuckets {
+		estimator.TxCount[i] *= feeEstimatorDecay
+		estimator.FeeRateSum[i] *= feeEstimatorDecay
+		for t := range estimator.ConfCount[i] {
+			estimator.ConfCount[i][t] *= feeEstimatorDecay
+		}
+	}
+	for _, tx := range block.Transactions {
+		tracked, ok := estimator.Pending[string(tx.TXID)]
+		if !ok {
+			continue
+		}
+		delete(estimator.Pending, string(tx.TXID))
+		if block.Height <= tracked.Height {
+			continue
+		}
+		blocks := int(block.Height - tracked.Height)
+		i := feeBucketIndex(tracked.FeeRate)
+		estimator.TxCount[i]++
+		estimator.FeeRateSum[i] += tracked.FeeRate
+		for t := blocks; t <= maxConfirmTarget; t++ {
+			estimator.ConfCount[i][t-1]++
+		}
+	}
+	//被冲突的交易驱逐的交易
+	mempool := boltTx.Bucket([]byte(mempoolBucket))
+	for id := range estimator.Pending {
+		if mempool == nil || mempool.Get([]byte(id)) == nil {
+			delete(estimator.Pending, id)
+		}
+	}
+	return estimator.save(boltTx)
+}
+
+//估计在target个区块内确认需要的手续费率（每虚拟字节），height是当前最新区块的高度
+func (estimator *FeeEstimator) EstimateFee(target int, height uint64) (float64, error) {
+	if target < 1 || target > maxConfirmTarget {
+		return 0, fmt.Errorf("目标区块数必须在1到%d之间", maxConfirmTarget)
+	}
+	//还在交易池中并且已经等待了至少target个区块的交易，算作没有在target个区块内确认
+	failed := make([]float64, len(feeBuckets))
+	for _, tracked := range estimator.Pending {
+		if height >= tracked.Height+uint64(target) {
+			failed[feeBucketIndex(tracked.FeeRate)]++
+		}
+	}
+	best := math.NaN()
+	var conf, total, fail, feeRateSum float64
+	for i := len(feeBuckets) - 1; i >= 0; i-- {
+		conf += estimator.ConfCount[i][target-1]
+		total += estimator.TxCount[i]
+		fail += failed[i]
+		feeRateSum += estimator.FeeRateSum[i]
+		if total+fail < sufficientFeeTxs {
+			continue
+		}
+		if conf/(total+fail) < successThreshold {
+			break
+		}
+		if total > 0 {
+			best = feeRateSum / total
+		}
+		conf, total, fail, feeRateSum = 0, 0, 0, 0
+	}
+	if math.IsNaN(best) {
+		return 0, errors.New("没有足够的数据估计手续费")
+	}
+	return best, nil
+}
+
+//估计在target个区块内确认需要的手续费率
+func (bc *BlockChain) EstimateFee(target int) (float64, error) {
+	var estimator *FeeEstimator
+	bc.db.View(func(boltTx *bolt.Tx) error {
+		estimator = loadFeeEstimator(boltTx)
+		return nil
+	})
+	return estimator.EstimateFee(target, bc.NewIterator().Next().Height)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"log"
+	"math/big"
+)
+
+//密钥类型，决定使用的椭圆曲线和公钥的编码方式
+//P256: 公钥为X||Y拼接（旧版本的钱包）
+//secp256k1: 公钥为33字节的SEC压缩格式，第一个字节0x02/0x03就是类型标记
+//schnorr: secp256k1曲线上的BIP340密钥，公钥为32字节的x坐标，使用Schnorr签名
+type KeyType byte
+
+const (
+	//零值，旧版本的钱包文件中没有类型字段，解码后就是P256
+	KeyTypeP256 KeyType = iota
+	KeyTypeSecp256k1
+	KeyTypeSchnorr
+)
+
+//压缩公钥的长度
+const compressedPubKeySize = 33
+
+//P256公钥中X、Y各自的字节数
+const p256CoordinateSize = 32
+
+func (t KeyType) String() string {
+	switch t {
+	case KeyTypeP256:
+		return "p256"
+	case KeyTypeSecp256k1:
+		return "secp256k1"
+	case KeyTypeSchnorr:
+		return "schnorr"
+	default:
+		return "unknown"
+	}
+}
+
+//由名字得到密钥类型，用于命令行参数
+func ParseKeyType(name string) (KeyType, error) {
+	switch name {
+	case "p256":
+		return KeyTypeP256, nil
+	case "secp256k1":
+		return KeyTypeSecp256k1, nil
+	case "schnorr":
+		return KeyTypeSchnorr, nil
+	default:
+		return KeyTypeP256, fmt.Errorf("未知的密钥类型: %s (可选 p256|secp256k1|schnorr)", name)
+	}
+}
+
+func (t KeyType) Curve() elliptic.Curve {
+	if t == KeyTypeSecp256k1 || t == KeyTypeSchnorr {
+		return btcec.S256()
+	}
+	return elliptic.P256()
+}
+
+//生成指定类型的私钥
+func NewPrivateKey(t KeyType) *ecdsa.PrivateKey {
+	privateKey, err := ecdsa.GenerateKey(t.Curve(), rand.Reader)
+	if err != nil {
+		log.Panic(err)
+	}
+	return privateKey
+}
+
+//由私钥的D还原私钥，用于从钱包文件中加载
+func PrivateKeyFromBytes(t KeyType, d []byte) *ecdsa.PrivateKey {
+	curve := t.Curve()
+	privateKey := new(ecdsa.PrivateKey)
+	privateKey.Curve = curve
+	privateKey.D = new(big.Int).SetBytes(d)
+	privateKey.X, privateKey.Y = curve.ScalarBaseMult(d)
+	return privateKey
+}
+
+//公钥编码，P256的X、Y补齐到32字节，避免前导0导致拆分错误
+func SerializePubKey(t KeyType, pubKey *ecdsa.PublicKey) []byte {
+	if t == KeyTypeSecp256k1 {
+		return (*btcec.PublicKey)(pubKey).SerializeCompressed()
+	}
+	if t == KeyTypeSchnorr {
+		return bytes32(pubKey.X)
+	}
+	buf := make([]byte, 2*p256CoordinateSize)
+	pubKey.X.FillBytes(buf[:p256CoordinateSize])
+	pubKey.Y.FillBytes(buf[p256CoordinateSize:])
+	return buf
+}
+
+//根据编码判断公钥类型
+//P256公钥X||Y要凑成33字节需要31个前导0，凑成32字节需要32个前导0，不会与另外两种公钥混淆
+func GetPubKeyType(pubKey []byte) KeyType {
+	if len(pubKey) == schnorrPubKeySize {
+		return KeyTypeSchnorr
+	}
+	if len(pubKey) == compressedPubKeySize && (pubKey[0] == 0x02 || pubKey[0] == 0x03) {
+		return KeyTypeSecp256k1
+	}
+	return KeyTypeP256
+}
+
+//解析公钥，返回公钥和类型，两种曲线都支持，旧的P256输出仍然可以花费
+func ParsePubKey(pubKey []byte) (*ecdsa.PublicKey, KeyType, error) {
+	if len(pubKey) == 0 {
+		return nil, KeyTypeP256, errors.New("公钥为空")
+	}
+	keyType := GetPubKeyType(pubKey)
+	if keyType == KeyTypeSecp256k1 {
+		curve := btcec.S256()
+		key, err := btcec.ParsePubKey(pubKey, curve)
+		if err != nil || key.X.Cmp(curve.P) >= 0 || !curve.IsOnCurve(key.X, key.Y) {
+			return nil, keyType, errors.New("secp256k1公钥无效")
+		}
+		return key.ToECDSA(), keyType, nil
+	}
+	if keyType == KeyTypeSchnorr {
+		x, y, err := liftX(new(big.Int).SetBytes(pubKey))
+		if err != nil {
+			return nil, keyType, errors.New("Schnorr公钥无效")
+		}
+		return &ecdsa.PublicKey{Curve: btcec.S256(), X: x, Y: y}, keyType, nil
+	}
+	//旧版本的公钥长度可能不足64字节，按长度一半拆分（与旧版本的校验方式相同）
+	X := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	Y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if len(pubKey) > 2*p256CoordinateSize || !elliptic.P256().IsOnCurve(X, Y) {
+		return nil, keyType, errors.New("P256公钥无效")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: X, Y: Y}, keyType, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//交易的时间锁，参考比特币的nLockTime（绝对时间锁）和nSequence（相对时间锁）
+
+//LockTime小于这个值表示区块高度，否则表示unix时间
+const LockTimeThreshold = 500000000
+
+//input的序列号为这个值时不使用任何时间锁
+const SequenceFinal = 0xffffffff
+
+//input的默认序列号：不是SequenceFinal（LockTime生效），不启用相对时间锁，也不允许替换
+const SequenceDefault = SequenceFinal - 1
+
+//序列号不超过这个值时，交易在交易池中可以被手续费更高的交易替换（BIP125）
+const MaxRBFSequence = SequenceFinal - 2
+
+//序列号设置了这个标志时不启用相对时间锁
+const SequenceLockTimeDisableFlag = 1 << 31
+
+//序列号设置了这个标志时相对时间锁的单位是512秒，否则是区块个数
+const SequenceLockTimeTypeFlag = 1 << 22
+
+//序列号中表示相对锁定时间的部分
+const SequenceLockTimeMask = 0x0000ffff
+
+//时间类型的相对锁定时间，单位是2^9=512秒
+const SequenceLockTimeGranularity = 9
+
+//计算中位时间使用的区块个数
+const medianTimeBlocks = 11
+
+//由区块个数生成相对时间锁的序列号
+func SequenceFromBlocks(blocks uint32) uint32 {
+	return blocks & SequenceLockTimeMask
+}
+
+//判断交易在指定高度和时间的区块中是否已经可以打包（绝对时间锁）
+//所有input的序列号都是SequenceFinal时LockTime不生效
+func (tx *Transaction) IsFinal(height, blockTime uint64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	limit := blockTime
+	if tx.LockTime < LockTimeThreshold {
+		limit = height
+	}
+	if tx.LockTime < limit {
+		return true
+	}
+	for _, input := range tx.TXInputs {
+		if input.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+//返回指定区块及其之前共medianTimeBlocks个区块时间戳的中位数
+//使用中位时间而不是区块时间戳，矿工无法通过修改时间戳提前解锁
+func (bc *BlockChain) MedianTimePast(hash []byte) uint64 {
+	if len(hash) == 0 {
+		return 0
+	}
+	var timestamps []uint64
+	it := BlockChainIterator{bc.db, hash}
+	for len(timestamps) < medianTimeBlocks {
+		block := it.Next()
+		timestamps = append(timestamps, block.TimeStamp)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return timestamps[len(timestamps)/2]
+}
+
+//返回下一个区块的高度和用于时间锁校验的时间（当前链的中位时间）
+func (bc *BlockChain) NextBlockInfo() (uint64, uint64) {
+	tip := bc.NewIterator().Next()
+	return tip.Height + 1, bc.MedianTimePast(tip.Hash)
+}
+
+//根据交易id查找包含这个交易的区块
+func (bc *BlockChain) FindTransactionBlock(id []byte) (*Block, error) {
+	it := bc.NewIterator()
+	for {
+		block := it.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.TXID, id) {
+				return block, nil
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return nil, errors.New("无效的交易id，请检查!")
+}
+
+//校验交易的相对时间锁：每个input引用的output被打包之后，需要再经过序列号指定的区块数或时间
+func (bc *BlockChain) CheckSequenceLocks(tx *Transaction, height, medianTime uint64) error {
+	for i, input := range tx.TXInputs {
+		sequence := input.Sequence
+		if sequence&SequenceLockTimeDisableFlag != 0 || sequence&SequenceLockTimeMask == 0 {
+			continue
+		}
+		prevBlock, err := bc.FindTransactionBlock(input.TXid)
+		if err != nil {
+			return err
+		}
+		value := uint64(sequence & SequenceLockTimeMask)
+		if sequence&SequenceLockTimeTypeFlag == 0 {
+			if height < prevBlock.Height+value {
+				return fmt.Errorf("第%d个input的相对时间锁未到期，需要在高度%d之后打包", i, prevBlock.Height+value-1)
+			}
+			continue
+		}
+		//从引用的output所在区块的前一个区块的中位时间开始计算
+		prevTime := prevBlock.TimeStamp
+		if len(prevBlock.PrevHash) != 0 {
+			prevTime = bc.MedianTimePast(prevBlock.PrevHash)
+		}
+		if medianTime < prevTime+value<<SequenceLockTimeGranularity {
+			return fmt.Errorf("第%d个input的相对时间锁未到期，还需要%d秒",
+				i, prevTime+value<<SequenceLockTimeGranularity-medianTime)
+		}
+	}
+	return nil
+}
+
+//校验交易能否打包进指定高度的区块，medianTime是前一个区块的中位时间
+func (bc *BlockChain) CheckTransactionLocks(tx *Transaction, height, medianTime uint64) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	if !tx.IsFinal(height, medianTime) {
+		if tx.LockTime < LockTimeThreshold {
+			return fmt.Errorf("交易锁定到高度%d，当前区块高度%d", tx.LockTime, height)
+		}
+		return fmt.Errorf("交易锁定到时间%d，当前中位时间%d", tx.LockTime, medianTime)
+	}
+	return bc.CheckSequenceLocks(tx, height, medianTime)
+}
+
+//校验交易能否打包进下一个区块
+func (bc *BlockChain) CheckLocksForNextBlock(tx *Transaction) error {
+	height, medianTime := bc.NextBlockInfo()
+	return bc.CheckTransactionLocks(tx, height, medianTime)
+}
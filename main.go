@@ -1,5 +1,7 @@
 package main
 
+import "os"
+
 /**
 1.定义结构
 2.前区块hash
@@ -13,6 +15,12 @@ package main
 */
 
 func main() {
+	//离线命令不需要区块链，不打开数据库
+	if len(os.Args) > 1 && offlineCommands[os.Args[1]] {
+		cli := CLI{nil}
+		cli.Run()
+		return
+	}
 	bc := NewBlockChain("18fh8wzXAzP9kE433CwNCQ34e4rjeDZgZN")
 	cli := CLI{bc}
 	cli.Run()
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ShersBlockChain/bolt"
+	"log"
+)
+
+//交易池，保存还没有被打包的交易
+//命令行每次执行都是一个新的进程，所以交易池也保存在区块链数据库中
+//交易池中的交易可以花费交易池中其他交易的output（未确认的父交易），打包时父交易在前
+const mempoolBucket = "mempoolBucket"
+
+//交易池接受的单笔交易的最大权重和签名操作成本，区块中可以放下多笔这样的交易
+const maxStandardTxWeight = maxBlockWeight / 10
+const maxStandardTxSigOpsCost = maxBlockSigOpsCost / 5
+
+//交易池中的交易，以及它的手续费、虚拟大小、权重和签名操作成本
+type MempoolEntry struct {
+	Tx        *Transaction
+	Fee       float64
+	Size      int
+	Weight    int
+	SigOpCost int
+}
+
+func newMempoolEntry(tx *Transaction, prevTXs map[string]Transaction) *MempoolEntry {
+	return &MempoolEntry{tx, tx.Fee(prevTXs), tx.VirtualSize(), tx.Weight(), tx.SigOpCost(prevTXs)}
+}
+
+//每虚拟字节的手续费
+func (entry *MempoolEntry) FeeRate() float64 {
+	return entry.Fee / float64(entry.Size)
+}
+
+//校验交易并加入交易池
+//与交易池中的交易花费同一个output时，按照替换规则（见rbf.go）替换原交易以及它的后代交易
+func (bc *BlockChain) AddToMempool(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return errors.New("挖矿交易不能加入交易池")
+	}
+	entries, err := bc.GetMempoolEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if bytes.Equal(entry.Tx.TXID, tx.TXID) {
+			return errors.New("交易已经在交易池中")
+		}
+	}
+	//引用的交易可以在区块链上，也可以在交易池中
+	prevTXs, err := bc.FindPrevTransactionsForBlock(append(mempoolTransactions(entries), tx))
+	if err != nil {
+		return err
+	}
+	checks, err := buildInputChecks([]*Transaction{tx}, prevTXs)
+	if err != nil {
+		return err
+	}
+	//校验通过的签名写入签名缓存，打包时不再重复校验
+	sigCache := NewSigCache()
+	if err := runInputChecks(checks, verifyWorkers, sigCache); err != nil {
+		return err
+	}
+	//时间锁要求交易可以打包进下一个区块
+	if err := bc.CheckLocksForNextBlock(tx); err != nil {
+		return err
+	}
+
+	//与交易池中的交易冲突时，需要驱逐冲突的交易以及它们的后代交易
+	var conflicts []*MempoolEntry
+	for _, entry := range entries {
+		if IsConflicting(entry.Tx, tx) {
+			conflicts = append(conflicts, entry)
+		}
+	}
+	evicted := MempoolDescendants(entries, conflicts)
+	ancestors := MempoolAncestors(entries, tx)
+	for _, ancestor := range ancestors {
+		for _, entry := range evicted {
+			if ancestor == entry {
+				return fmt.Errorf("交易花费了将被替换的交易%x", entry.Tx.TXID)
+			}
+		}
+	}
+	//引用的output没有被花费过，并且可以在下一个区块中花费，交易池中的祖先交易与它一起检查
+	height, _ := bc.NextBlockInfo()
+	if err := bc.CheckInputsSpendable(append(mempoolTransactions(ancestors), tx), height); err != nil {
+		return err
+	}
+	entry := newMempoolEntry(tx, prevTXs)
+	//超过限制的交易永远不能被打包
+	if entry.Weight > maxStandardTxWeight {
+		return fmt.Errorf("交易权重%d超过了最大值%d", entry.Weight, maxStandardTxWeight)
+	}
+	if entry.SigOpCost > maxStandardTxSigOpsCost {
+		return fmt.Errorf("交易签名操作成本%d超过了最大值%d", entry.SigOpCost, maxStandardTxSigOpsCost)
+	}
+	if len(conflicts) != 0 {
+		if err := CheckReplacement(entry, conflicts, evicted); err != nil {
+			return err
+		}
+	}
+
+	err = bc.db.Update(func(boltTx *bolt.Tx) error {
+		bucket, err := boltTx.CreateBucketIfNotExists([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+		for _, replaced := range evicted {
+			if err := bucket.Delete(replaced.Tx.TXID); err != nil {
+				return err
+			}
+		}
+		if err := sigCache.Save(boltTx); err != nil {
+			return err
+		}
+		//记录交易加入交易池时的高度，用于手续费估计
+		if err := trackMempoolFee(boltTx, entry, height-1, evicted); err != nil {
+			return err
+		}
+		return bucket.Put(tx.TXID, tx.Serialize())
+	})
+	if err != nil {
+		return err
+	}
+	for _, replaced := range evicted {
+		fmt.Printf("交易%x被替换，已从交易池中删除\n", replaced.Tx.TXID)
+	}
+	return nil
+}
+
+//返回交易池中的所有交易，按交易id排序
+func (bc *BlockChain) GetMempoolTransactions() []*Transaction {
+	var txs []*Transaction
+	bc.db.View(func(boltTx *bolt.Tx) error {
+		bucket := boltTx.Bucket([]byte(mempoolBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			tx, err := DeserializeTransaction(v)
+			if err != nil {
+				log.Panic(err)
+			}
+			txs = append(txs, tx)
+			return nil
+		})
+	})
+	return txs
+}
+
+//返回交易池中的所有交易以及它们的手续费和虚拟大小，按交易id排序
+func (bc *BlockChain) GetMempoolEntries() ([]*MempoolEntry, error) {
+	txs := bc.GetMempoolTransactions()
+	prevTXs, err := bc.FindPrevTransactionsForBlock(txs)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*MempoolEntry
+	for _, tx := range txs {
+		entries = append(entries, newMempoolEntry(tx, prevTXs))
+	}
+	return entries, nil
+}
+
+func mempoolTransactions(entries []*MempoolEntry) []*Transaction {
+	var txs []*Transaction
+	for _, entry := range entries {
+		txs = append(txs, entry.Tx)
+	}
+	return txs
+}
+
+//交易在交易池中的所有祖先交易（直接或者间接引用的未确认交易），父交易在前
+func MempoolAncestors(entries []*MempoolEntry, tx *Transaction) []*MempoolEntry {
+	byID := make(map[string]*MempoolEntry)
+	for _, entry := range entries {
+		byID[string(entry.Tx.TXID)] = entry
+	}
+	visited := make(map[string]bool)
+	var ancestors []*MempoolEntry
+	var visit func(tx *Transaction)
+	visit = func(tx *Transaction) {
+		for _, input := range tx.TXInputs {
+			parent, ok := byID[string(input.TXid)]
+			if !ok || visited[string(input.TXid)] {
+				continue
+			}
+			visited[string(input.TXid)] = true
+			//先加入父交易的祖先
+			visit(parent.Tx)
+			ancestors = append(ancestors, parent)
+		}
+	}
+	visit(tx)
+	return ancestors
+}
+
+//roots以及它们在交易池中的所有后代交易
+func MempoolDescendants(entries []*MempoolEntry, roots []*MempoolEntry) []*MempoolEntry {
+	ids := make(map[string]bool)
+	for _, root := range roots {
+		ids[string(root.Tx.TXID)] = true
+	}
+	addDescendantIDs(mempoolTransactions(entries), ids)
+	var descendants []*MempoolEntry
+	for _, entry := range entries {
+		if ids[string(entry.Tx.TXID)] {
+			descendants = append(descendants, entry)
+		}
+	}
+	return descendants
+}
+
+//把txs中直接或者间接花费了ids中交易的交易id加入ids
+func addDescendantIDs(txs []*Transaction, ids map[string]bool) {
+	for changed := true; changed; {
+		changed = false
+		for _, tx := range txs {
+			if ids[string(tx.TXID)] {
+				continue
+			}
+			for _, input := range tx.TXInputs {
+				if ids[string(input.TXid)] {
+					ids[string(tx.TXID)] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+//交易包的手续费率：总手续费除以总虚拟大小
+func PackageFeeRate(pkg []*MempoolEntry) float64 {
+	fee := 0.0
+	size := 0
+	for _, entry := range pkg {
+		fee += entry.Fee
+		size += entry.Size
+	}
+	return fee / float64(size)
+}
+
+//区块写入后，把已经打包的交易从交易池中删除
+//与区块中的交易花费了同一个output的交易以及它们的后代交易也不再有效，一起删除
+func removeFromMempool(boltTx *bolt.Tx, txs []*Transaction) {
+	bucket := boltTx.Bucket([]byte(mempoolBucket))
+	if bucket == nil {
+		return
+	}
+	var pool []*Transaction
+	bucket.ForEach(func(k, v []byte) error {
+		tx, err := DeserializeTransaction(v)
+		if err != nil {
+			log.Panic(err)
+		}
+		pool = append(pool, tx)
+		return nil
+	})
+	invalid := make(map[string]bool)
+	for _, tx := range txs {
+		bucket.Delete(tx.TXID)
+		for _, pending := range pool {
+			if !tx.IsCoinbase() && !bytes.Equal(pending.TXID, tx.TXID) && IsConflicting(pending, tx) {
+				invalid[string(pending.TXID)] = true
+			}
+		}
+	}
+	addDescendantIDs(pool, invalid)
+	for id := range invalid {
+		bucket.Delete([]byte(id))
+	}
+}
+
+//判断两笔交易是否花费了同一个output
+func IsConflicting(tx1, tx2 *Transaction) bool {
+	for _, input1 := range tx1.TXInputs {
+		for _, input2 := range tx2.TXInputs {
+			if bytes.Equal(input1.TXid, input2.TXid) && input1.Index == input2.Index {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+//默克尔树，把多个hash合并成一个根hash，并且可以为其中任意一个叶子生成证明路径
+//每一层个数为奇数时复制最后一个节点（与比特币相同）
+
+//证明路径中的一步，Left表示兄弟节点在左边
+type MerkleProofStep struct {
+	Hash []byte
+	Left bool
+}
+
+//两个子节点合并成父节点
+func merkleParent(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return hash[:]
+}
+
+//计算上一层的节点
+func merkleNextLevel(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, merkleParent(level[i], right))
+	}
+	return next
+}
+
+//计算默克尔根，只有一个叶子时根就是叶子本身
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = merkleNextLevel(level)
+	}
+	return level[0]
+}
+
+//生成第index个叶子到根的证明路径
+func MerkleProof(leaves [][]byte, index int) []MerkleProofStep {
+	var proof []MerkleProofStep
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof = append(proof, MerkleProofStep{level[sibling], sibling < index})
+		level = merkleNextLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+//沿着证明路径从叶子计算到根，判断是否与给定的根相同
+func VerifyMerkleProof(leaf []byte, proof []MerkleProofStep, root []byte) bool {
+	hash := leaf
+	for _, step := range proof {
+		if step.Left {
+			hash = merkleParent(step.Hash, hash)
+		} else {
+			hash = merkleParent(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"log"
+	"math/big"
+	"sort"
+)
+
+//MuSig聚合签名，参考MuSig2
+//1.公钥聚合: Q = a_1*P_1 + a_2*P_2，a_i = H(L || P_i)，L是所有公钥的hash，防止恶意公钥抵消其他人的公钥
+//2.第一轮: 每个签名方生成两个秘密随机数，交换公开的nonce
+//3.第二轮: 每个签名方生成部分签名，相加之后就是Q的普通Schnorr签名
+//链上只能看到一个公钥和一个签名，与单签名的花费无法区分
+
+//公开nonce的字节数，两个压缩格式的点
+const musigPubNonceSize = 2 * compressedPubKeySize
+
+//秘密nonce的字节数，两个标量
+const musigSecNonceSize = 64
+
+//公钥聚合的结果
+type musigKeyAgg struct {
+	qx, qy *big.Int
+	//Q的y为奇数时，签名方需要使用-d
+	negate bool
+	//每个公钥的系数，key是公钥的16进制字符串
+	coefficients map[string]*big.Int
+}
+
+//按字节序排序，公钥的顺序不影响聚合结果
+func sortPubKeys(pubKeys [][]byte) [][]byte {
+	sorted := append([][]byte{}, pubKeys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return sorted
+}
+
+func musigKeyAggregate(pubKeys [][]byte) (*musigKeyAgg, error) {
+	if len(pubKeys) < 2 {
+		return nil, errors.New("至少需要2个公钥")
+	}
+	curve := btcec.S256()
+	sorted := sortPubKeys(pubKeys)
+	agg := musigKeyAgg{new(big.Int), new(big.Int), false, make(map[string]*big.Int)}
+	L := taggedHash("KeyAgg list", sorted...)
+	for i, pubKey := range sorted {
+		if len(pubKey) != schnorrPubKeySize {
+			return nil, fmt.Errorf("公钥必须是32字节的Schnorr公钥: %x", pubKey)
+		}
+		if i > 0 && bytes.Equal(pubKey, sorted[i-1]) {
+			return nil, errors.New("公钥重复")
+		}
+		px, py, err := liftX(new(big.Int).SetBytes(pubKey))
+		if err != nil {
+			return nil, err
+		}
+		a := new(big.Int).SetBytes(taggedHash("KeyAgg coefficient", L, pubKey))
+		a.Mod(a, curve.N)
+		agg.coefficients[hex.EncodeToString(pubKey)] = a
+		apx, apy := curve.ScalarMult(px, py, bytes32(a))
+		agg.qx, agg.qy = curve.Add(agg.qx, agg.qy, apx, apy)
+	}
+	if isInfinity(agg.qx, agg.qy) {
+		return nil, errors.New("聚合公钥无效")
+	}
+	agg.negate = !hasEvenY(agg.qy)
+	return &agg, nil
+}
+
+//聚合多个x-only公钥，返回聚合后的x-only公钥
+func MuSigAggregateKey(pubKeys [][]byte) ([]byte, error) {
+	agg, err := musigKeyAggregate(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return bytes32(agg.qx), nil
+}
+
+func serializePoint(x, y *big.Int) []byte {
+	return (*btcec.PublicKey)(&ecdsa.PublicKey{Curve: btcec.S256(), X: x, Y: y}).SerializeCompressed()
+}
+
+func parsePoint(data []byte) (*big.Int, *big.Int, error) {
+	key, err := btcec.ParsePubKey(data, btcec.S256())
+	if err != nil {
+		return nil, nil, errors.New("nonce格式错误")
+	}
+	return key.X, key.Y, nil
+}
+
+//第一轮: 生成秘密nonce和公开nonce
+//秘密nonce由随机数、私钥和签名数据共同生成，必须只使用一次，用过之后由调用方删除
+func NewMuSigNonce(privateKey *ecdsa.PrivateKey, aggKey, msg []byte) ([]byte, []byte) {
+	curve := btcec.S256()
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		log.Panic(err)
+	}
+	var secNonce, pubNonce []byte
+	for i := byte(0); i < 2; i++ {
+		k := new(big.Int).SetBytes(taggedHash("MuSig/nonce", random, bytes32(privateKey.D), aggKey, msg, []byte{i}))
+		k.Mod(k, curve.N)
+		if k.Sign() == 0 {
+			log.Panic("MuSig的随机数为0")
+		}
+		rx, ry := curve.ScalarBaseMult(bytes32(k))
+		secNonce = append(secNonce, bytes32(k)...)
+		pubNonce = append(pubNonce, serializePoint(rx, ry)...)
+	}
+	return secNonce, pubNonce
+}
+
+//一次签名的公共数据，所有签名方计算的结果相同
+type musigSession struct {
+	agg *musigKeyAgg
+	msg []byte
+	//nonce系数b，R = R1 + b*R2
+	b *big.Int
+	//挑战值
+	e *big.Int
+	//R的x坐标
+	rx *big.Int
+	//R的y为奇数时，签名方需要使用-k
+	negateNonce bool
+}
+
+//pubNonces的key是公钥的16进制字符串，需要所有签名方的公开nonce
+func newMuSigSession(pubKeys [][]byte, pubNonces map[string][]byte, msg []byte) (*musigSession, error) {
+	agg, err := musigKeyAggregate(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	curve := btcec.S256()
+	r1x, r1y, r2x, r2y := new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	for _, pubKey := range sortPubKeys(pubKeys) {
+		pubNonce := pubNonces[hex.EncodeToString(pubKey)]
+		if len(pubNonce) != musigPubNonceSize {
+			return nil, fmt.Errorf("缺少签名方的nonce: %x", pubKey)
+		}
+		x1, y1, err := parsePoint(pubNonce[:compressedPubKeySize])
+		if err != nil {
+			return nil, err
+		}
+		x2, y2, err := parsePoint(pubNonce[compressedPubKeySize:])
+		if err != nil {
+			return nil, err
+		}
+		r1x, r1y = curve.Add(r1x, r1y, x1, y1)
+		r2x, r2y = curve.Add(r2x, r2y, x2, y2)
+	}
+	if isInfinity(r1x, r1y) || isInfinity(r2x, r2y) {
+		return nil, errors.New("聚合nonce无效")
+	}
+	qx := bytes32(agg.qx)
+	b := new(big.Int).SetBytes(taggedHash("MuSig/noncecoef", serializePoint(r1x, r1y), serializePoint(r2x, r2y), qx, msg))
+	b.Mod(b, curve.N)
+	brx, bry := curve.ScalarMult(r2x, r2y, bytes32(b))
+	rx, ry := curve.Add(r1x, r1y, brx, bry)
+	if isInfinity(rx, ry) {
+		return nil, errors.New("聚合nonce无效")
+	}
+	e := schnorrChallenge(bytes32(rx), qx, msg)
+	return &musigSession{agg, msg, b, e, rx, !hasEvenY(ry)}, nil
+}
+
+//第二轮: 生成部分签名 s_i = k1 + b*k2 + e*a_i*d_i
+func MuSigPartialSign(privateKey *ecdsa.PrivateKey, secNonce []byte, pubKeys [][]byte, pubNonces map[string][]byte, msg []byte) ([]byte, error) {
+	if len(secNonce) != musigSecNonceSize {
+		return nil, errors.New("秘密nonce无效")
+	}
+	session, err := newMuSigSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return nil, err
+	}
+	n := btcec.S256().N
+	a := session.agg.coefficients[hex.EncodeToString(SchnorrPubKey(privateKey))]
+	if a == nil {
+		return nil, errors.New("私钥不属于这个聚合公钥")
+	}
+	k1 := new(big.Int).SetBytes(secNonce[:32])
+	k2 := new(big.Int).SetBytes(secNonce[32:])
+	if session.negateNonce {
+		k1.Sub(n, k1)
+		k2.Sub(n, k2)
+	}
+	//聚合时使用的是y为偶数的公钥，Q的y为奇数时整体取反
+	d := new(big.Int).Set(privateKey.D)
+	if !hasEvenY(privateKey.PublicKey.Y) {
+		d.Sub(n, d)
+	}
+	if session.agg.negate {
+		d.Sub(n, d)
+	}
+	s := new(big.Int).Mul(session.e, a)
+	s.Mul(s, d)
+	s.Add(s, k1)
+	s.Add(s, new(big.Int).Mul(session.b, k2))
+	s.Mod(s, n)
+	return bytes32(s), nil
+}
+
+//校验某个签名方的部分签名: s_i*G = R1_i + b*R2_i + e*a_i*P_i（按需取反）
+//合并失败时可以用它找出给出错误部分签名的签名方
+func MuSigPartialVerify(partialSig, pubKey []byte, pubKeys [][]byte, pubNonces map[string][]byte, msg []byte) bool {
+	if len(partialSig) != 32 {
+		return false
+	}
+	session, err := newMuSigSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return false
+	}
+	curve := btcec.S256()
+	s := new(big.Int).SetBytes(partialSig)
+	a := session.agg.coefficients[hex.EncodeToString(pubKey)]
+	if a == nil || s.Cmp(curve.N) >= 0 {
+		return false
+	}
+	pubNonce := pubNonces[hex.EncodeToString(pubKey)]
+	x1, y1, err1 := parsePoint(pubNonce[:compressedPubKeySize])
+	x2, y2, err2 := parsePoint(pubNonce[compressedPubKeySize:])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	bx, by := curve.ScalarMult(x2, y2, bytes32(session.b))
+	rx, ry := curve.Add(x1, y1, bx, by)
+	if session.negateNonce {
+		rx, ry = negatePoint(rx, ry)
+	}
+	px, py, err := liftX(new(big.Int).SetBytes(pubKey))
+	if err != nil {
+		return false
+	}
+	if session.agg.negate {
+		px, py = negatePoint(px, py)
+	}
+	ea := new(big.Int).Mul(session.e, a)
+	epx, epy := curve.ScalarMult(px, py, bytes32(ea.Mod(ea, curve.N)))
+	rhsX, rhsY := curve.Add(rx, ry, epx, epy)
+	lhsX, lhsY := curve.ScalarBaseMult(bytes32(s))
+	return lhsX.Cmp(rhsX) == 0 && lhsY.Cmp(rhsY) == 0
+}
+
+//合并所有部分签名，得到聚合公钥的Schnorr签名 R.x || (s_1 + s_2 + ...)
+func MuSigAggregateSigs(pubKeys [][]byte, pubNonces, partialSigs map[string][]byte, msg []byte) ([]byte, error) {
+	session, err := newMuSigSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return nil, err
+	}
+	n := btcec.S256().N
+	s := new(big.Int)
+	for _, pubKey := range pubKeys {
+		partialSig := partialSigs[hex.EncodeToString(pubKey)]
+		if !MuSigPartialVerify(partialSig, pubKey, pubKeys, pubNonces, msg) {
+			return nil, fmt.Errorf("签名方的部分签名无效: %x", pubKey)
+		}
+		s.Add(s, new(big.Int).SetBytes(partialSig))
+	}
+	s.Mod(s, n)
+	return append(bytes32(session.rx), bytes32(s)...), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+//两方MuSig完整流程: 公钥聚合，交换nonce，部分签名，合并后是聚合公钥的普通Schnorr签名
+func TestMuSigTwoParty(t *testing.T) {
+	alice := NewPrivateKey(KeyTypeSchnorr)
+	bob := NewPrivateKey(KeyTypeSchnorr)
+	pubKeys := [][]byte{SchnorrPubKey(alice), SchnorrPubKey(bob)}
+	aggKey, err := MuSigAggregateKey(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//公钥顺序不影响聚合结果
+	reversed, err := MuSigAggregateKey([][]byte{pubKeys[1], pubKeys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aggKey, reversed) {
+		t.Fatal("公钥顺序不同，聚合公钥不同")
+	}
+	if bytes.Equal(aggKey, pubKeys[0]) || bytes.Equal(aggKey, pubKeys[1]) {
+		t.Fatal("聚合公钥等于其中一个公钥")
+	}
+	if _, err := MuSigAggregateKey(pubKeys[:1]); err == nil {
+		t.Error("一个公钥不能聚合")
+	}
+	if _, err := MuSigAggregateKey([][]byte{pubKeys[0], pubKeys[0]}); err == nil {
+		t.Error("重复的公钥不能聚合")
+	}
+
+	msg := sha256.Sum256([]byte("musig"))
+	//第一轮
+	secNonces := make(map[string][]byte)
+	pubNonces := make(map[string][]byte)
+	for _, privateKey := range []*ecdsa.PrivateKey{alice, bob} {
+		secNonce, pubNonce := NewMuSigNonce(privateKey, aggKey, msg[:])
+		key := hex.EncodeToString(SchnorrPubKey(privateKey))
+		secNonces[key] = secNonce
+		pubNonces[key] = pubNonce
+	}
+	//第二轮
+	partialSigs := make(map[string][]byte)
+	for _, privateKey := range []*ecdsa.PrivateKey{alice, bob} {
+		pubKey := SchnorrPubKey(privateKey)
+		key := hex.EncodeToString(pubKey)
+		partialSig, err := MuSigPartialSign(privateKey, secNonces[key], pubKeys, pubNonces, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !MuSigPartialVerify(partialSig, pubKey, pubKeys, pubNonces, msg[:]) {
+			t.Fatalf("部分签名无效: %s", key)
+		}
+		partialSigs[key] = partialSig
+	}
+	signature, err := MuSigAggregateSigs(pubKeys, pubNonces, partialSigs, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SchnorrVerify(aggKey, msg[:], signature) {
+		t.Fatal("聚合签名不能用聚合公钥校验")
+	}
+	other := sha256.Sum256([]byte("other"))
+	if SchnorrVerify(aggKey, other[:], signature) {
+		t.Error("聚合签名对其他消息也有效")
+	}
+	if SchnorrVerify(pubKeys[0], msg[:], signature) {
+		t.Error("聚合签名对单个公钥也有效")
+	}
+
+	//只有一方的部分签名不能合并
+	aliceKey := hex.EncodeToString(pubKeys[0])
+	bobKey := hex.EncodeToString(pubKeys[1])
+	if _, err := MuSigAggregateSigs(pubKeys, pubNonces, map[string][]byte{aliceKey: partialSigs[aliceKey]}, msg[:]); err == nil {
+		t.Error("缺少部分签名也能合并")
+	}
+	//错误的部分签名在合并时被发现
+	forged := append([]byte{}, partialSigs[bobKey]...)
+	forged[31]++
+	if MuSigPartialVerify(forged, pubKeys[1], pubKeys, pubNonces, msg[:]) {
+		t.Error("篡改的部分签名校验通过")
+	}
+	if _, err := MuSigAggregateSigs(pubKeys, pubNonces, map[string][]byte{aliceKey: partialSigs[aliceKey], bobKey: forged}, msg[:]); err == nil {
+		t.Error("篡改的部分签名也能合并")
+	}
+	//不在聚合公钥中的私钥不能签名
+	carol := NewPrivateKey(KeyTypeSchnorr)
+	if _, err := MuSigPartialSign(carol, secNonces[aliceKey], pubKeys, pubNonces, msg[:]); err == nil {
+		t.Error("不属于聚合公钥的私钥也能生成部分签名")
+	}
+	//缺少一方的nonce无法签名
+	if _, err := MuSigPartialSign(alice, secNonces[aliceKey], pubKeys, map[string][]byte{aliceKey: pubNonces[aliceKey]}, msg[:]); err == nil {
+		t.Error("缺少nonce也能生成部分签名")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+//文件存在性证明（时间戳服务）
+//对文件做hash，多个文件的hash组成默克尔树，只把根hash写入交易的数据output
+//每个文件生成一个凭证，保存交易id、区块hash以及文件hash到根hash的证明路径
+//之后任何人都可以用凭证和本地区块链证明文件在该区块之前就已经存在
+
+//数据output的前缀，用于识别存证数据
+const notaryDataPrefix = "POE"
+
+//凭证文件的默认后缀
+const receiptSuffix = ".receipt"
+
+type NotaryReceipt struct {
+	//文件的sha256
+	FileHash []byte
+	//证明路径
+	Proof []MerkleProofStep
+	//写入交易的默克尔根
+	MerkleRoot []byte
+	//存证交易的id
+	TXID []byte
+	//存证交易所在的区块
+	BlockHash []byte
+}
+
+//计算文件的sha256
+func HashFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+//存证交易中的数据: 前缀 + 默克尔根
+func NotaryData(root []byte) []byte {
+	return append([]byte(notaryDataPrefix), root...)
+}
+
+//为每个文件hash生成凭证（交易id和区块hash在交易打包之后填写）
+func NewNotaryReceipts(fileHashes [][]byte) []*NotaryReceipt {
+	root := MerkleRoot(fileHashes)
+	var receipts []*NotaryReceipt
+	for i, hash := range fileHashes {
+		receipts = append(receipts, &NotaryReceipt{hash, MerkleProof(fileHashes, i), root, nil, nil})
+	}
+	return receipts
+}
+
+//使用本地区块链校验凭证，返回存证交易所在的区块
+func (receipt *NotaryReceipt) Verify(fileHash []byte, bc *BlockChain) (*Block, error) {
+	if !bytes.Equal(fileHash, receipt.FileHash) {
+		return nil, errors.New("文件hash与凭证不一致，文件已被修改")
+	}
+	if !VerifyMerkleProof(receipt.FileHash, receipt.Proof, receipt.MerkleRoot) {
+		return nil, errors.New("默克尔证明无效")
+	}
+	block, err := bc.FindTransactionBlock(receipt.TXID)
+	if err != nil {
+		return nil, fmt.Errorf("区块链中没有找到存证交易: %x", receipt.TXID)
+	}
+	if !bytes.Equal(block.Hash, receipt.BlockHash) {
+		return nil, fmt.Errorf("存证交易所在的区块与凭证不一致: %x", block.Hash)
+	}
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.TXID, receipt.TXID) {
+			continue
+		}
+		for _, output := range tx.TXOutputs {
+			if data, ok := ExtractData(output.ScriptPubKey); ok && bytes.Equal(data, NotaryData(receipt.MerkleRoot)) {
+				return block, nil
+			}
+		}
+	}
+	return nil, errors.New("存证交易中没有凭证对应的默克尔根")
+}
+
+func (receipt *NotaryReceipt) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(receipt)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+//凭证文件与PSBT文件一样保存为16进制文本
+func (receipt *NotaryReceipt) SaveToFile(file string) error {
+	return ioutil.WriteFile(file, []byte(hex.EncodeToString(receipt.Serialize())), 0644)
+}
+
+func LoadReceiptFile(file string) (*NotaryReceipt, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.New("凭证文件格式错误")
+	}
+	var receipt NotaryReceipt
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&receipt); err != nil {
+		return nil, errors.New("凭证文件解码出错")
+	}
+	return &receipt, nil
+}
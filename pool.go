@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ShersBlockChain/bolt"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+//矿池模式：stratum服务器（见stratum.go）找到的区块奖励先付给矿池地址，再按份额分给矿工
+//1.矿工名称就是收款地址，可以加上.后缀区分同一个地址的多台矿机，例如 ADDRESS.rig1
+//2.每个接受的份额记录矿工、份额难度和时间，保存在区块链数据库中，重启之后继续使用
+//3.找到区块时按PPLNS（pay per last N shares）计算收益：扣除矿池手续费之后的区块奖励按最近N个份额的难度分配
+//4.挖矿交易成熟之后，创建一笔花费这些挖矿交易output的支付交易放入交易池，由矿池自己打包
+//  剩余部分（矿池手续费减去交易手续费）找零给矿池地址，所以矿池地址必须在本地钱包中
+//5.poolStats命令通过stratum连接查询，服务器运行时数据库被锁定，其他命令无法打开
+const poolSharesBucket = "poolSharesBucket"
+const poolBlocksBucket = "poolBlocksBucket"
+
+//默认的PPLNS份额数
+const defaultPPLNSShares = 64
+
+//默认的矿池手续费（百分比）
+const defaultPoolFee = 1.0
+
+//使用最近多长时间的份额计算算力
+const poolHashrateWindow = 10 * time.Minute
+
+//接受的份额
+type PoolShare struct {
+	//矿工名称和它的收款地址
+	Worker     string
+	Address    string
+	Difficulty float64
+	Time       int64
+}
+
+//一个地址从一个区块中获得的收益
+type PoolPayout struct {
+	Address string
+	Amount  float64
+}
+
+//矿池找到的区块
+type PoolBlock struct {
+	Height uint64
+	Hash   []byte
+	//奖励付给矿池地址的挖矿交易，第0个output
+	CoinbaseTXID []byte
+	//挖矿交易的金额，包括出块奖励和区块中交易的手续费
+	Reward float64
+	//按PPLNS计算的每个地址的收益
+	Payouts []PoolPayout
+	//支付交易的id，为空表示还没有支付
+	PayoutTXID []byte
+}
+
+type Pool struct {
+	bc *BlockChain
+	//矿池地址，挖矿奖励付给这个地址
+	address     string
+	pplnsShares int
+	//百分比
+	fee float64
+	//服务器启动的时间，刚启动时算力使用启动之后的份额计算
+	startTime time.Time
+}
+
+func NewPool(bc *BlockChain, address string, pplnsShares int, fee float64) (*Pool, error) {
+	ws := NewWallets()
+	if ws.WalletsMap[address] == nil || ws.IsWatchOnly(address) {
+		return nil, errors.New("矿池地址必须是本地钱包中有私钥的地址，支付交易需要签名")
+	}
+	if pplnsShares < 1 {
+		return nil, errors.New("PPLNS份额数至少为1")
+	}
+	if fee < 0 || fee >= 100 {
+		return nil, errors.New("矿池手续费必须在0到100之间")
+	}
+	return &Pool{bc, address, pplnsShares, fee, time.Now()}, nil
+}
+
+//矿工名称中的收款地址
+func poolWorkerAddress(worker string) (string, error) {
+	address := strings.SplitN(worker, ".", 2)[0]
+	if !IsValidAddress(address) {
+		return "", fmt.Errorf("矿工名称必须是收款地址（可以加上.后缀）: %s", worker)
+	}
+	return address, nil
+}
+
+func (share *PoolShare) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(share)
+	if err != nil {
+		log.Panic("编码出错")
+	}
+	return buffer.Bytes()
+}
+
+func DeserializePoolShare(data []byte) PoolShare {
+	var share PoolShare
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&share)
+	if err != nil {
+		log.Panic("解码出错")
+	}
+	return share
+}
+
+func (block *PoolBlock) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(block)
+	if err != nil {
+		log.Panic("编码出错")
+	}
+	return buffer.Bytes()
+}
+
+func DeserializePoolBlock(data []byte) PoolBlock {
+	var block PoolBlock
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&block)
+	if err != nil {
+		log.Panic("解码出错")
+	}
+	return block
+}
+
+//记录接受的份额
+func (pool *Pool) RecordShare(worker string, difficulty float64) error {
+	address, err := poolWorkerAddress(worker)
+	if err != nil {
+		return err
+	}
+	share := PoolShare{worker, address, difficulty, time.Now().Unix()}
+	return pool.bc.db.Update(func(boltTx *bolt.Tx) error {
+		bucket, err := boltTx.CreateBucketIfNotExists([]byte(poolSharesBucket))
+		if err != nil {
+			return err
+		}
+		//份额按顺序编号，大端序的key使遍历顺序与编号相同
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(Uint64ToByte(seq), share.Serialize())
+	})
+}
+
+//从最新的份额开始向前遍历，visit返回false时停止
+func forEachRecentShare(boltTx *bolt.Tx, visit func(share PoolShare) bool) {
+	bucket := boltTx.Bucket([]byte(poolSharesBucket))
+	if bucket == nil {
+		return
+	}
+	cursor := bucket.Cursor()
+	for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+		if !visit(DeserializePoolShare(v)) {
+			return
+		}
+	}
+}
+
+//按份额难度分配amount，结果向下取整到feePrecision，按地址排序
+func ComputePPLNS(shares []PoolShare, amount float64) []PoolPayout {
+	weights := make(map[string]float64)
+	total := 0.0
+	for _, share := range shares {
+		weights[share.Address] += share.Difficulty
+		total += share.Difficulty
+	}
+	var payouts []PoolPayout
+	for address, weight := range weights {
+		value := math.Floor(amount*weight/total/feePrecision) * feePrecision
+		if value > 0 {
+			payouts = append(payouts, PoolPayout{address, value})
+		}
+	}
+	sort.Slice(payouts, func(i, j int) bool {
+		return payouts[i].Address < payouts[j].Address
+	})
+	return payouts
+}
+
+//矿池找到区块后，按最近pplnsShares个份额计算每个地址的收益并保存
+func (pool *Pool) RecordBlock(block *Block) error {
+	coinbase := block.Transactions[0]
+	//挖矿交易领取的出块奖励和手续费都在第0个output中（见NewCoinbaseTXWithFees），其他output是金额为0的数据output
+	//支付交易只花费第0个output，所以它必须付给矿池地址并且包含挖矿交易的全部金额
+	reward := 0.0
+	for _, output := range coinbase.TXOutputs {
+		reward += output.Value
+	}
+	if coinbase.TXOutputs[0].Address() != pool.address || coinbase.TXOutputs[0].Value != reward {
+		return errors.New("挖矿交易没有把全部金额付给矿池地址")
+	}
+	return pool.bc.db.Update(func(boltTx *bolt.Tx) error {
+		var shares []PoolShare
+		forEachRecentShare(boltTx, func(share PoolShare) bool {
+			shares = append(shares, share)
+			return len(shares) < pool.pplnsShares
+		})
+		payouts := ComputePPLNS(shares, reward*(1-pool.fee/100))
+		poolBlock := PoolBlock{block.Height, block.Hash, coinbase.TXID, reward, payouts, nil}
+		bucket, err := boltTx.CreateBucketIfNotExists([]byte(poolBlocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, payout := range payouts {
+			fmt.Printf("区块%d收益: %s %.8f\n", block.Height, payout.Address, payout.Amount)
+		}
+		return bucket.Put(Uint64ToByte(block.Height), poolBlock.Serialize())
+	})
+}
+
+//矿池找到的所有区块，按高度排序
+func (pool *Pool) blocks() []PoolBlock {
+	var blocks []PoolBlock
+	pool.bc.db.View(func(boltTx *bolt.Tx) error {
+		bucket := boltTx.Bucket([]byte(poolBlocksBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			blocks = append(blocks, DeserializePoolBlock(v))
+			return nil
+		})
+	})
+	return blocks
+}
+
+//为挖矿交易已经成熟但还没有支付的区块创建一笔支付交易，放入交易池
+func (pool *Pool) ProcessPayouts() error {
+	height, _ := pool.bc.NextBlockInfo()
+	maturity := pool.bc.CoinbaseMaturity()
+	var mature []PoolBlock
+	var inputs []TXInput
+	amounts := make(map[string]float64)
+	for _, block := range pool.blocks() {
+		if block.PayoutTXID != nil || height < block.Height+maturity {
+			continue
+		}
+		mature = append(mature, block)
+		inputs = append(inputs, TXInput{block.CoinbaseTXID, 0, nil, nil, nil, 0})
+		for _, payout := range block.Payouts {
+			amounts[payout.Address] += payout.Amount
+		}
+	}
+	if len(mature) == 0 {
+		return nil
+	}
+	var addresses []string
+	for address := range amounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	var outputs []TXOutput
+	for _, address := range addresses {
+		outputs = append(outputs, *NewTXOutput(amounts[address], address))
+	}
+	//交易手续费从矿池的找零中扣除
+	tx := NewTransactionToMany(pool.address, outputs, SendOptions{Inputs: inputs, ConfTarget: defaultConfirmTarget}, pool.bc)
+	if tx == nil {
+		return errors.New("创建支付交易失败")
+	}
+	if err := pool.bc.AddToMempool(tx); err != nil {
+		return err
+	}
+	err := pool.bc.db.Update(func(boltTx *bolt.Tx) error {
+		bucket := boltTx.Bucket([]byte(poolBlocksBucket))
+		for _, block := range mature {
+			block.PayoutTXID = tx.TXID
+			if err := bucket.Put(Uint64ToByte(block.Height), block.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("支付交易已加入交易池: %x 区块数: %d 收款地址数: %d\n", tx.TXID, len(mature), len(outputs))
+	return nil
+}
+
+//一个收款地址的统计
+type PoolWorkerStats struct {
+	Address string
+	//最近的份额估计的每秒hash次数
+	Hashrate float64
+	//最近poolHashrateWindow内的份额数
+	Shares int
+	//还没有支付的收益，其中Immature是挖矿交易还没有成熟的部分
+	Pending  float64
+	Immature float64
+	Paid     float64
+}
+
+type PoolStats struct {
+	Address         string
+	ShareDifficulty float64
+	PPLNSShares     int
+	Fee             float64
+	Hashrate        float64
+	Blocks          int
+	Workers         []PoolWorkerStats
+}
+
+//份额难度对应的平均hash次数：2^256 / 份额目标值
+func difficultyHashes(difficulty float64) float64 {
+	total := new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 256))
+	hashes, _ := total.Quo(total, new(big.Float).SetInt(PowTarget())).Float64()
+	return hashes * difficulty
+}
+
+//统计每个地址的算力和收益，shareDifficulty是服务器当前的份额难度
+func (pool *Pool) Stats(shareDifficulty float64) *PoolStats {
+	workers := make(map[string]*PoolWorkerStats)
+	worker := func(address string) *PoolWorkerStats {
+		if workers[address] == nil {
+			workers[address] = &PoolWorkerStats{Address: address}
+		}
+		return workers[address]
+	}
+	stats := PoolStats{pool.address, shareDifficulty, pool.pplnsShares, pool.fee, 0, 0, nil}
+
+	since := time.Now().Add(-poolHashrateWindow)
+	if pool.startTime.After(since) {
+		since = pool.startTime
+	}
+	seconds := math.Max(time.Since(since).Seconds(), 1)
+	pool.bc.db.View(func(boltTx *bolt.Tx) error {
+		forEachRecentShare(boltTx, func(share PoolShare) bool {
+			if share.Time < since.Unix() {
+				return false
+			}
+			hashrate := difficultyHashes(share.Difficulty) / seconds
+			worker(share.Address).Hashrate += hashrate
+			worker(share.Address).Shares++
+			stats.Hashrate += hashrate
+			return true
+		})
+		return nil
+	})
+
+	height, _ := pool.bc.NextBlockInfo()
+	maturity := pool.bc.CoinbaseMaturity()
+	for _, block := range pool.blocks() {
+		stats.Blocks++
+		for _, payout := range block.Payouts {
+			switch {
+			case block.PayoutTXID != nil:
+				worker(payout.Address).Paid += payout.Amount
+			case height < block.Height+maturity:
+				worker(payout.Address).Immature += payout.Amount
+				worker(payout.Address).Pending += payout.Amount
+			default:
+				worker(payout.Address).Pending += payout.Amount
+			}
+		}
+	}
+	for _, stat := range workers {
+		stats.Workers = append(stats.Workers, *stat)
+	}
+	sort.Slice(stats.Workers, func(i, j int) bool {
+		return stats.Workers[i].Address < stats.Workers[j].Address
+	})
+	return &stats
+}
+
+//通过stratum连接查询矿池的统计
+func QueryPoolStats(address string) (*PoolStats, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	data, _ := json.Marshal(stratumRequest{1, "pool.stats", []interface{}{}})
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	//订阅之前服务器不会发送通知，第一行就是响应
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var msg stratumMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, err
+	}
+	if len(msg.Error) != 0 && string(msg.Error) != "null" {
+		return nil, fmt.Errorf("查询失败: %s", msg.Error)
+	}
+	var stats PoolStats
+	if err := json.Unmarshal(msg.Result, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+//把每秒hash次数格式化成带单位的字符串
+func formatHashrate(hashrate float64) string {
+	units := []string{"H/s", "kH/s", "MH/s", "GH/s", "TH/s"}
+	i := 0
+	for hashrate >= 1000 && i < len(units)-1 {
+		hashrate /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", hashrate, units[i])
+}
+
+func (stats *PoolStats) Print() {
+	fmt.Printf("矿池地址: %s\n", stats.Address)
+	fmt.Printf("份额难度: %g PPLNS份额数: %d 矿池手续费: %.2f%%\n", stats.ShareDifficulty, stats.PPLNSShares, stats.Fee)
+	fmt.Printf("矿池算力: %s 找到的区块: %d\n", formatHashrate(stats.Hashrate), stats.Blocks)
+	for _, worker := range stats.Workers {
+		fmt.Printf("%s 算力: %s 最近份额: %d 待支付: %.8f（未成熟: %.8f） 已支付: %.8f\n",
+			worker.Address, formatHashrate(worker.Hashrate), worker.Shares, worker.Pending, worker.Immature, worker.Paid)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+//区块链从数据库打开之后，矿池在找到区块之前写入了很多份额，仍然可以创建新的任务
+func TestPoolJobAfterShares(t *testing.T) {
+	address := NewWallet(KeyTypeP256).NewAddress()
+	bc := reopenTestBlockChain(t, newTestBlockChain(t, address))
+	genesis := append([]byte{}, bc.tail...)
+
+	pool := &Pool{bc, address, 8, defaultPoolFee, time.Now()}
+	server := NewStratumServer(bc, address, defaultShareDifficulty, pool)
+	for i := 0; i < 500; i++ {
+		if err := pool.RecordShare(address+".rig", defaultShareDifficulty); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(bc.tail, genesis) {
+		t.Fatalf("写入份额之后最新区块的hash被修改: %x", bc.tail)
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if err := server.newJob(false); err != nil {
+		t.Fatal(err)
+	}
+	job := server.jobs[len(server.jobs)-1]
+	if job.Height != 1 || !bytes.Equal(job.PrevHash, genesis) {
+		t.Fatalf("任务的高度%d或前区块%x错误", job.Height, job.PrevHash)
+	}
+	stats := pool.Stats(defaultShareDifficulty)
+	if len(stats.Workers) != 1 || stats.Workers[0].Shares != 500 {
+		t.Fatalf("份额统计错误: %+v", stats.Workers)
+	}
+}
+
+//矿池按挖矿交易的实际金额（出块奖励加手续费）分配收益
+func TestPoolRecordBlockWithFees(t *testing.T) {
+	address := NewWallet(KeyTypeP256).NewAddress()
+	miner := NewWallet(KeyTypeP256).NewAddress()
+	bc := newTestBlockChain(t, address)
+	pool := &Pool{bc, address, 8, defaultPoolFee, time.Now()}
+	for i := 0; i < 4; i++ {
+		if err := pool.RecordShare(miner, defaultShareDifficulty); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fees := 0.75
+	block := NewBlock([]*Transaction{NewCoinbaseTXWithFees(address, "", fees)}, bc.tail, 1)
+	if err := pool.RecordBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	blocks := pool.blocks()
+	if len(blocks) != 1 || blocks[0].Reward != reward+fees || len(blocks[0].Payouts) != 1 {
+		t.Fatalf("区块记录错误: %+v", blocks)
+	}
+	expected := (reward + fees) * (1 - defaultPoolFee/100)
+	if amount := blocks[0].Payouts[0].Amount; math.Abs(amount-expected) > feePrecision {
+		t.Fatalf("收益%.8f，应该是%.8f", amount, expected)
+	}
+
+	//挖矿交易没有付给矿池地址时不分配收益
+	other := NewBlock([]*Transaction{NewCoinbaseTXWithFees(miner, "", fees)}, bc.tail, 2)
+	if err := pool.RecordBlock(other); err == nil {
+		t.Fatal("挖矿交易没有付给矿池地址，应该失败")
+	}
+}
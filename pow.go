@@ -17,18 +17,23 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
-//2.创建POW
-func NewProofOfWork(block *Block) *ProofOfWork {
-	pow := ProofOfWork{
-		block: block,
-	}
+//区块hash的目标值，hash小于这个值时挖矿成功
+func PowTarget() *big.Int {
 	//指定难度值，String类型，需要进行转换
 	targetStr := "0000100000000000000000000000000000000000000000000000000000000000"
 	//引入辅助变量，将上面的难度值转成bigint
 	tmpInt := big.Int{}
 	//将难度值赋值给bigint，指定16进制格式
 	tmpInt.SetString(targetStr, 16)
-	pow.target = &tmpInt
+	return &tmpInt
+}
+
+//2.创建POW
+func NewProofOfWork(block *Block) *ProofOfWork {
+	pow := ProofOfWork{
+		block: block,
+	}
+	pow.target = PowTarget()
 	return &pow
 }
 
@@ -38,23 +43,10 @@ func (pow *ProofOfWork) Run() ([]byte, uint64) {
 	//2.做hash运算
 	//3.验证
 	var nonce uint64
-	block := pow.block
 	var hash [32]byte
 	fmt.Println("开始挖矿......")
 	for {
-		tmp := [][]byte{
-			Uint64ToByte(block.Version),
-			block.PrevHash,
-			block.MerkelRoot,
-			Uint64ToByte(block.TimeStamp),
-			Uint64ToByte(block.Difficulty),
-			Uint64ToByte(nonce),
-			//只对区块头做hash值，通过MerkelRoot产生影响
-			//block.Data,
-		}
-		//将二维的切片数组连接起来，返回一个一维的切片
-		blockInfo := bytes.Join(tmp, []byte{})
-		hash = sha256.Sum256(blockInfo)
+		hash = sha256.Sum256(pow.prepareData(nonce))
 		//将我们得到的hash数组转换成bigint
 		tmInt := big.Int{}
 		tmInt.SetBytes(hash[:])
@@ -72,4 +64,31 @@ func (pow *ProofOfWork) Run() ([]byte, uint64) {
 	return hash[:], nonce
 }
 
-//校验函数
+//拼装区块头数据(区块数据、随机数)
+func (pow *ProofOfWork) prepareData(nonce uint64) []byte {
+	block := pow.block
+	tmp := [][]byte{
+		Uint64ToByte(block.Version),
+		block.PrevHash,
+		block.MerkelRoot,
+		Uint64ToByte(block.TimeStamp),
+		Uint64ToByte(block.Difficulty),
+		Uint64ToByte(nonce),
+		Uint64ToByte(block.Height),
+		//只对区块头做hash值，通过MerkelRoot产生影响
+		//block.Data,
+	}
+	//将二维的切片数组连接起来，返回一个一维的切片
+	return bytes.Join(tmp, []byte{})
+}
+
+//校验函数：区块的hash与区块头一致，并且小于目标值
+func (pow *ProofOfWork) IsValid() bool {
+	hash := sha256.Sum256(pow.prepareData(pow.block.Nonce))
+	if !bytes.Equal(hash[:], pow.block.Hash) {
+		return false
+	}
+	tmpInt := big.Int{}
+	tmpInt.SetBytes(hash[:])
+	return tmpInt.Cmp(pow.target) == -1
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+//部分签名交易，参考比特币的PSBT
+//容器中保存未签名的交易以及每个input引用的完整交易，签名时不需要区块链数据，
+//可以在离线（隔离网络）的机器上完成签名，多方签名后再合并、最终确定并广播
+//签名方加载容器时校验引用交易的hash与input的交易id一致，在线的机器无法伪造引用的output的金额来骗取手续费
+type PartiallySignedTransaction struct {
+	//未签名的交易
+	Tx Transaction
+	//与Tx.TXInputs一一对应
+	Inputs []PSBTInput
+}
+
+//PSBT中每个input的附加数据
+type PSBTInput struct {
+	//引用的完整交易，只有交易id是签名方可以信任的，加载时校验它的hash
+	PrevTx Transaction
+	//PrevTx中引用的output，签名和校验都需要它，由checkPrevTxs从PrevTx中取出，不保存
+	prevOutput TXOutput
+	//已经收集到的签名，key是公钥的16进制字符串，value是签名
+	PartialSigs map[string][]byte
+	//引用的output是P2SH时的赎回脚本
+	RedeemScript []byte
+	//引用的output属于MuSig地址时所有签名方的公钥
+	MuSigPubKeys [][]byte
+	//MuSig第一轮的公开nonce，key是签名方公钥的16进制字符串
+	MuSigNonces map[string][]byte
+	//MuSig第二轮的部分签名，key是签名方公钥的16进制字符串
+	MuSigPartialSigs map[string][]byte
+}
+
+//根据未签名的交易创建PSBT，只在创建时需要区块链查找引用的output
+//钱包中保存的赎回脚本会一起放入容器，签名方不需要再单独获取
+func NewPSBT(tx *Transaction, ws *Wallets, bc *BlockChain) (*PartiallySignedTransaction, error) {
+	psbt := PartiallySignedTransaction{Tx: *tx}
+	for _, input := range tx.TXInputs {
+		prevTX, err := bc.FindTransactionByTXid(input.TXid)
+		if err != nil {
+			return nil, err
+		}
+		if input.Index < 0 || int(input.Index) >= len(prevTX.TXOutputs) {
+			return nil, errors.New("引用的output索引无效")
+		}
+		prevOutput := prevTX.TXOutputs[input.Index]
+		var redeemScript []byte
+		if scriptHash, ok := ExtractP2SHScriptHash(prevOutput.LockingScript()); ok {
+			redeemScript = ws.FindRedeemScript(scriptHash)
+		}
+		var muSigPubKeys [][]byte
+		if pubKeyHash, ok := ExtractP2PKHPubKeyHash(prevOutput.LockingScript()); ok {
+			if muSig := ws.FindMuSig(pubKeyHash); muSig != nil {
+				muSigPubKeys = muSig.PubKeys
+			}
+		}
+		psbt.Inputs = append(psbt.Inputs, PSBTInput{
+			PrevTx:           prevTX,
+			prevOutput:       prevOutput,
+			PartialSigs:      make(map[string][]byte),
+			RedeemScript:     redeemScript,
+			MuSigPubKeys:     muSigPubKeys,
+			MuSigNonces:      make(map[string][]byte),
+			MuSigPartialSigs: make(map[string][]byte),
+		})
+	}
+	return &psbt, nil
+}
+
+//校验每个input的PrevTx就是input引用的交易，并取出引用的output
+//伪造的PrevTx的hash与交易id不一致，所以通过校验之后显示的手续费和签名的数据都是真实的
+func (psbt *PartiallySignedTransaction) checkPrevTxs() error {
+	if len(psbt.Inputs) != len(psbt.Tx.TXInputs) {
+		return errors.New("PSBT的input个数与交易不一致")
+	}
+	for i, input := range psbt.Tx.TXInputs {
+		in := &psbt.Inputs[i]
+		if !bytes.Equal(in.PrevTx.Hash(), input.TXid) {
+			return fmt.Errorf("第%d个input附带的交易与引用的交易id不一致", i)
+		}
+		if input.Index < 0 || int(input.Index) >= len(in.PrevTx.TXOutputs) {
+			return fmt.Errorf("第%d个input引用的output索引无效", i)
+		}
+		in.prevOutput = in.PrevTx.TXOutputs[input.Index]
+	}
+	return nil
+}
+
+//返回签名时使用的子脚本、可以签名的公钥以及需要的签名个数
+//P2PKH的公钥未知（只有公钥hash），返回的公钥为空
+func (in *PSBTInput) signingInfo() ([]byte, [][]byte, int) {
+	subScript := in.prevOutput.LockingScript()
+	if _, ok := ExtractP2SHScriptHash(subScript); ok {
+		subScript = in.RedeemScript
+	}
+	if m, pubKeys, ok := ExtractMultiSig(subScript); ok {
+		return subScript, pubKeys, m
+	}
+	return subScript, nil, 1
+}
+
+//用钱包中的私钥给能签的input签名，返回新增签名的个数
+//只使用容器中的数据，不访问区块链，MuSig地址总是使用ALL类型签名
+func (psbt *PartiallySignedTransaction) Sign(ws *Wallets, hashType SigHashType) int {
+	count := 0
+	for i := range psbt.Inputs {
+		in := &psbt.Inputs[i]
+		//P2SH的赎回脚本也可以由签名方的钱包提供
+		if scriptHash, ok := ExtractP2SHScriptHash(in.prevOutput.LockingScript()); ok && in.RedeemScript == nil {
+			in.RedeemScript = ws.FindRedeemScript(scriptHash)
+		}
+		//MuSig的签名方公钥也是一样
+		if pubKeyHash, ok := ExtractP2PKHPubKeyHash(in.prevOutput.LockingScript()); ok && in.MuSigPubKeys == nil {
+			if muSig := ws.FindMuSig(pubKeyHash); muSig != nil {
+				in.MuSigPubKeys = muSig.PubKeys
+			}
+		}
+		if in.MuSigPubKeys != nil {
+			count += psbt.signMuSig(i, ws)
+			continue
+		}
+		subScript, pubKeys, _ := in.signingInfo()
+		if pubKeys == nil {
+			//P2PKH：找到公钥hash匹配的钱包，时间锁脚本使用脚本中的公钥hash
+			pubKeyHash := in.prevOutput.PubKeyHash
+			if _, _, lockedHash, ok := ExtractTimeLock(subScript); ok {
+				pubKeyHash = lockedHash
+			}
+			for _, wallet := range ws.WalletsMap {
+				if bytes.Equal(HashPubKey(wallet.PubKey), pubKeyHash) {
+					pubKeys = [][]byte{wallet.PubKey}
+					break
+				}
+			}
+		}
+		for _, pubKey := range pubKeys {
+			wallet := ws.FindWalletByPubKey(pubKey)
+			key := hex.EncodeToString(pubKey)
+			if wallet == nil || in.PartialSigs[key] != nil {
+				continue
+			}
+			sig, err := psbt.Tx.SignInputWithType(i, wallet, subScript, hashType)
+			if err != nil {
+				fmt.Printf("第%d个input签名失败: %s\n", i, err)
+				continue
+			}
+			in.PartialSigs[key] = sig
+			count++
+		}
+	}
+	return count
+}
+
+//MuSig签名分两轮: 先为钱包中的签名方生成nonce，所有签名方的nonce都收集到之后再生成部分签名
+//秘密nonce保存在钱包中（调用方负责保存钱包），使用一次之后删除，返回新增nonce和部分签名的个数
+func (psbt *PartiallySignedTransaction) signMuSig(i int, ws *Wallets) int {
+	in := &psbt.Inputs[i]
+	aggKey, err := MuSigAggregateKey(in.MuSigPubKeys)
+	if err != nil {
+		fmt.Println(err)
+		return 0
+	}
+	msg := psbt.Tx.SignatureHashForScript(i, in.prevOutput.LockingScript())
+	count := 0
+	//第一轮
+	for _, pubKey := range in.MuSigPubKeys {
+		wallet := ws.FindWalletByPubKey(pubKey)
+		key := hex.EncodeToString(pubKey)
+		if wallet == nil || in.MuSigNonces[key] != nil {
+			continue
+		}
+		secNonce, pubNonce := NewMuSigNonce(wallet.Private, aggKey, msg)
+		ws.MuSigNonces[musigNonceKey(msg, pubKey)] = secNonce
+		in.MuSigNonces[key] = pubNonce
+		count++
+	}
+	if len(in.MuSigNonces) < len(in.MuSigPubKeys) {
+		return count
+	}
+	//第二轮
+	for _, pubKey := range in.MuSigPubKeys {
+		wallet := ws.FindWalletByPubKey(pubKey)
+		key := hex.EncodeToString(pubKey)
+		if wallet == nil || in.MuSigPartialSigs[key] != nil {
+			continue
+		}
+		nonceKey := musigNonceKey(msg, pubKey)
+		secNonce := ws.MuSigNonces[nonceKey]
+		if secNonce == nil {
+			fmt.Printf("第%d个input没有找到本钱包生成的nonce，无法生成部分签名\n", i)
+			continue
+		}
+		partialSig, err := MuSigPartialSign(wallet.Private, secNonce, in.MuSigPubKeys, in.MuSigNonces, msg)
+		//同一个nonce签名两次会泄露私钥，无论成功与否都删除
+		delete(ws.MuSigNonces, nonceKey)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		in.MuSigPartialSigs[key] = partialSig
+		count++
+	}
+	return count
+}
+
+//返回第i个input已有的签名个数和需要的签名个数
+func (psbt *PartiallySignedTransaction) SignatureCount(i int) (int, int) {
+	if in := psbt.Inputs[i]; in.MuSigPubKeys != nil {
+		return len(in.MuSigPartialSigs), len(in.MuSigPubKeys)
+	}
+	_, _, m := psbt.Inputs[i].signingInfo()
+	return len(psbt.Inputs[i].PartialSigs), m
+}
+
+//合并其他签名方的PSBT，必须是同一笔未签名交易
+func (psbt *PartiallySignedTransaction) Combine(other *PartiallySignedTransaction) error {
+	if !bytes.Equal(psbt.Tx.TXID, other.Tx.TXID) || len(psbt.Inputs) != len(other.Inputs) {
+		return errors.New("不是同一笔交易的PSBT，无法合并")
+	}
+	for i := range psbt.Inputs {
+		for key, sig := range other.Inputs[i].PartialSigs {
+			psbt.Inputs[i].PartialSigs[key] = sig
+		}
+		if psbt.Inputs[i].MuSigPubKeys == nil {
+			psbt.Inputs[i].MuSigPubKeys = other.Inputs[i].MuSigPubKeys
+		}
+		for key, nonce := range other.Inputs[i].MuSigNonces {
+			psbt.Inputs[i].MuSigNonces[key] = nonce
+		}
+		for key, sig := range other.Inputs[i].MuSigPartialSigs {
+			psbt.Inputs[i].MuSigPartialSigs[key] = sig
+		}
+	}
+	return nil
+}
+
+//把收集到的签名填入交易，并校验每一个input，成功后返回可以广播的交易
+func (psbt *PartiallySignedTransaction) Finalize() (*Transaction, error) {
+	tx := psbt.Tx.TrimmedCopy()
+	for i, in := range psbt.Inputs {
+		if in.MuSigPubKeys != nil {
+			//MuSig：合并部分签名，链上与单签名相同
+			if len(in.MuSigPartialSigs) < len(in.MuSigPubKeys) {
+				return nil, fmt.Errorf("第%d个input的MuSig部分签名不足: %d/%d", i, len(in.MuSigPartialSigs), len(in.MuSigPubKeys))
+			}
+			msg := psbt.Tx.SignatureHashForScript(i, in.prevOutput.LockingScript())
+			sig, err := MuSigAggregateSigs(in.MuSigPubKeys, in.MuSigNonces, in.MuSigPartialSigs, msg)
+			if err != nil {
+				return nil, fmt.Errorf("第%d个input: %s", i, err)
+			}
+			aggKey, err := MuSigAggregateKey(in.MuSigPubKeys)
+			if err != nil {
+				return nil, err
+			}
+			tx.TXInputs[i].PubKey = aggKey
+			tx.TXInputs[i].Signature = append(sig, byte(SigHashAll))
+			if !tx.VerifyInput(i, in.prevOutput) {
+				return nil, fmt.Errorf("第%d个input签名无效", i)
+			}
+			continue
+		}
+		subScript, pubKeys, m := in.signingInfo()
+		if pubKeys != nil {
+			//多重签名：按照公钥的顺序取前m个签名
+			builder := NewScriptBuilder()
+			count := 0
+			for _, pubKey := range pubKeys {
+				sig := in.PartialSigs[hex.EncodeToString(pubKey)]
+				if sig != nil && count < m {
+					builder.AddData(sig)
+					count++
+				}
+			}
+			if count < m {
+				return nil, fmt.Errorf("第%d个input签名不足: %d/%d", i, count, m)
+			}
+			//P2SH需要在最后提供赎回脚本
+			if _, ok := ExtractP2SHScriptHash(in.prevOutput.LockingScript()); ok {
+				builder.AddData(subScript)
+			}
+			tx.TXInputs[i].ScriptSig = builder.Script()
+			if !tx.VerifyInput(i, in.prevOutput) {
+				return nil, fmt.Errorf("第%d个input签名无效", i)
+			}
+			continue
+		}
+		if subScript == nil {
+			return nil, fmt.Errorf("第%d个input缺少赎回脚本", i)
+		}
+
+		_, isScriptHash := ExtractP2SHScriptHash(in.prevOutput.LockingScript())
+		signed := false
+		for key, sig := range in.PartialSigs {
+			pubKey, err := hex.DecodeString(key)
+			if err != nil {
+				continue
+			}
+			if isScriptHash {
+				//P2SH（例如时间锁）：<签名> <公钥> <赎回脚本>
+				tx.TXInputs[i].ScriptSig = NewScriptBuilder().AddData(sig).AddData(pubKey).AddData(subScript).Script()
+			} else {
+				tx.TXInputs[i].PubKey = pubKey
+				tx.TXInputs[i].Signature = sig
+			}
+			if tx.VerifyInput(i, in.prevOutput) {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("第%d个input缺少有效签名", i)
+		}
+	}
+	return &tx, nil
+}
+
+//计算输入总额减去输出总额，即交易手续费
+func (psbt *PartiallySignedTransaction) Fee() float64 {
+	fee := 0.0
+	for _, in := range psbt.Inputs {
+		fee += in.prevOutput.Value
+	}
+	for _, output := range psbt.Tx.TXOutputs {
+		fee -= output.Value
+	}
+	return fee
+}
+
+func (psbt *PartiallySignedTransaction) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(psbt)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func DeserializePSBT(data []byte) (*PartiallySignedTransaction, error) {
+	var psbt PartiallySignedTransaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&psbt)
+	if err != nil {
+		return nil, errors.New("PSBT解码出错")
+	}
+	for i := range psbt.Inputs {
+		if psbt.Inputs[i].PartialSigs == nil {
+			psbt.Inputs[i].PartialSigs = make(map[string][]byte)
+		}
+		if psbt.Inputs[i].MuSigNonces == nil {
+			psbt.Inputs[i].MuSigNonces = make(map[string][]byte)
+		}
+		if psbt.Inputs[i].MuSigPartialSigs == nil {
+			psbt.Inputs[i].MuSigPartialSigs = make(map[string][]byte)
+		}
+	}
+	//签名、显示手续费之前都需要确认引用的交易是真实的
+	if err := psbt.checkPrevTxs(); err != nil {
+		return nil, err
+	}
+	return &psbt, nil
+}
+
+//PSBT文件保存为16进制文本，方便在机器之间拷贝
+func (psbt *PartiallySignedTransaction) SaveToFile(file string) error {
+	return ioutil.WriteFile(file, []byte(hex.EncodeToString(psbt.Serialize())), 0600)
+}
+
+func LoadPSBTFile(file string) (*PartiallySignedTransaction, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.New("PSBT文件格式错误")
+	}
+	return DeserializePSBT(data)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+//签名方只信任交易id，在线的机器修改引用交易的金额之后PSBT无法加载
+func TestPSBTPrevTx(t *testing.T) {
+	wallet := NewWallet(KeyTypeP256)
+	address := wallet.NewAddress()
+	bc := newTestBlockChain(t, address)
+	ws := NewWallets()
+	ws.WalletsMap[address] = wallet
+	genesisCoinbase := bc.NewIterator().Next().Transactions[0]
+	input := TXInput{genesisCoinbase.TXID, 0, nil, nil, nil, SequenceFinal}
+	tx := Transaction{nil, []TXInput{input}, []TXOutput{*NewTXOutput(reward-0.5, address)}, 0}
+	tx.SetHash()
+	psbt, err := NewPSBT(&tx, ws, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := DeserializePSBT(psbt.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee := loaded.Fee(); fee != 0.5 {
+		t.Fatalf("手续费%f，应该是0.5", fee)
+	}
+	if loaded.Sign(ws, SigHashAll) != 1 {
+		t.Fatal("应该新增一个签名")
+	}
+	if _, err := loaded.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	//伪造引用的output的金额，让签名方以为手续费很少
+	psbt.Inputs[0].PrevTx.TXOutputs[0].Value = 1000
+	if _, err := DeserializePSBT(psbt.Serialize()); err == nil {
+		t.Fatal("引用的交易被修改，PSBT应该无法加载")
+	}
+}
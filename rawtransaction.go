@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//原始交易：由用户手动指定inputs和outputs，使用交易序列化后的16进制编码传递
+
+//解析inputs参数，格式为 txid:index,txid:index
+//可以使用 txid:index:sequence 指定input的序列号（相对时间锁）
+func ParseRawInputs(str string) ([]TXInput, error) {
+	var inputs []TXInput
+	for _, item := range strings.Split(str, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("input格式错误: %s", item)
+		}
+		txid, err := hex.DecodeString(parts[0])
+		if err != nil || len(txid) == 0 {
+			return nil, fmt.Errorf("交易id格式错误: %s", parts[0])
+		}
+		index, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("output索引格式错误: %s", parts[1])
+		}
+		var sequence uint64 = SequenceDefault
+		if len(parts) == 3 {
+			sequence, err = strconv.ParseUint(parts[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("序列号格式错误: %s", parts[2])
+			}
+		}
+		inputs = append(inputs, TXInput{txid, index, nil, nil, nil, uint32(sequence)})
+	}
+	return inputs, nil
+}
+
+//解析outputs参数，格式为 address:amount,address:amount，地址可以是联系人标签
+//也可以使用 script:HEX:amount 直接指定锁定脚本（例如裸多重签名），data:HEX 添加数据output
+func ParseRawOutputs(str string, ws *Wallets) ([]TXOutput, error) {
+	var outputs []TXOutput
+	for _, item := range strings.Split(str, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) == 3 && parts[0] == "script" {
+			output, err := parseScriptOutput(parts[1], parts[2])
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, *output)
+			continue
+		}
+		//data:HEX 数据output
+		if len(parts) == 2 && parts[0] == "data" {
+			data, err := hex.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("数据格式错误: %s", parts[1])
+			}
+			output, err := NewDataOutput(data)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, *output)
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("output格式错误: %s", item)
+		}
+		address, ok := ws.ResolveAddress(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("地址无效: %s", parts[0])
+		}
+		amount, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("金额格式错误: %s", parts[1])
+		}
+		outputs = append(outputs, *NewTXOutput(amount, address))
+	}
+	return outputs, nil
+}
+
+func parseScriptOutput(scriptHex, amountStr string) (*TXOutput, error) {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil || len(script) == 0 {
+		return nil, fmt.Errorf("锁定脚本格式错误: %s", scriptHex)
+	}
+	if _, err := ParseScript(script); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("金额格式错误: %s", amountStr)
+	}
+	return &TXOutput{amount, ScriptIndexHash(script), script}, nil
+}
+
+//创建未签名的原始交易
+func NewRawTransaction(inputs []TXInput, outputs []TXOutput, lockTime uint64) *Transaction {
+	tx := Transaction{[]byte{}, inputs, outputs, lockTime}
+	tx.SetHash()
+	return &tx
+}
+
+//合并多个原始交易的input和output，用于ANYONECANPAY签名的交易
+//所有交易的output完全相同时（例如众筹，每个出资方签名同一组output）只保留一份，否则按顺序拼接
+//合并之后交易id会改变，ALL类型的签名不再有效，ANYONECANPAY的签名仍然有效
+func CombineRawTransactions(txs []*Transaction) (*Transaction, error) {
+	if len(txs) < 2 {
+		return nil, errors.New("至少需要两笔交易")
+	}
+	sameOutputs := true
+	for _, tx := range txs[1:] {
+		if tx.LockTime != txs[0].LockTime {
+			return nil, errors.New("交易的锁定时间不同，无法合并")
+		}
+		if !bytes.Equal(outputsHash(tx.TXOutputs), outputsHash(txs[0].TXOutputs)) {
+			sameOutputs = false
+		}
+	}
+	var inputs []TXInput
+	var outputs []TXOutput
+	for _, tx := range txs {
+		inputs = append(inputs, tx.TXInputs...)
+		if !sameOutputs {
+			outputs = append(outputs, tx.TXOutputs...)
+		}
+	}
+	if sameOutputs {
+		outputs = txs[0].TXOutputs
+	}
+	//同一个output不能被引用两次
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		key := fmt.Sprintf("%x:%d", input.TXid, input.Index)
+		if seen[key] {
+			return nil, fmt.Errorf("重复的input: %s", key)
+		}
+		seen[key] = true
+	}
+	tx := Transaction{[]byte{}, inputs, outputs, txs[0].LockTime}
+	tx.SetHash()
+	return &tx, nil
+}
+
+//output列表的hash，用于比较两组output是否完全相同
+func outputsHash(outputs []TXOutput) []byte {
+	tx := Transaction{nil, nil, outputs, 0}
+	tx.SetHash()
+	return tx.TXID
+}
+
+func EncodeRawTransaction(tx *Transaction) string {
+	return hex.EncodeToString(tx.Serialize())
+}
+
+func DecodeRawTransaction(str string) (*Transaction, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(str))
+	if err != nil {
+		return nil, errors.New("原始交易不是有效的16进制字符串")
+	}
+	return DeserializeTransaction(data)
+}
+
+//使用钱包中的私钥签名原始交易，返回新签名的input个数以及是否所有input都已签名
+//已经有有效签名的input保持不变，所以可以逐个签名方依次签名
+func SignRawTransaction(tx *Transaction, ws *Wallets, prevTXs map[string]Transaction, hashType SigHashType) (int, bool) {
+	count := 0
+	complete := true
+	for i, input := range tx.TXInputs {
+		prevOutput := prevTXs[string(input.TXid)].TXOutputs[input.Index]
+		if tx.VerifyInput(i, prevOutput) {
+			continue
+		}
+		signed := false
+		for _, wallet := range ws.WalletsMap {
+			if bytes.Equal(HashPubKey(wallet.PubKey), prevOutput.PubKeyHash) {
+				sig, err := tx.SignInputWithType(i, wallet, prevOutput.LockingScript(), hashType)
+				if err != nil {
+					fmt.Printf("第%d个input签名失败: %s\n", i, err)
+					break
+				}
+				tx.TXInputs[i].PubKey = wallet.PubKey
+				tx.TXInputs[i].Signature = sig
+				signed = true
+				count++
+				break
+			}
+		}
+		if !signed {
+			complete = false
+		}
+	}
+	return count, complete
+}
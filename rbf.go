@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+//交易替换（replace-by-fee，参考BIP125）和子交易支付父交易的手续费（child-pays-for-parent）
+//1.原交易的某个input的序列号不超过MaxRBFSequence时，表示允许被替换
+//2.替换交易的手续费率必须高于每一笔与它冲突的原交易
+//3.替换交易的手续费不能少于被驱逐的所有交易（原交易和它的后代交易）的手续费之和，
+//  多出的部分还要能按incrementalRelayFeeRate支付替换交易自己的转发费用
+//4.一次替换最多驱逐maxReplacementEvictions笔交易
+//5.不允许替换的交易可以由收款方或者找零方创建一笔手续费很高的子交易，打包时按交易包的手续费率选择
+
+//替换交易每虚拟字节至少需要多支付的手续费
+const incrementalRelayFeeRate = 0.00001
+
+//一次替换最多驱逐的交易数
+const maxReplacementEvictions = 100
+
+//手续费的最小单位，计算出的手续费向上取整到这个精度
+const feePrecision = 1e-8
+
+//交易是否允许被替换
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, input := range tx.TXInputs {
+		if input.Sequence <= MaxRBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
+//检查替换交易是否满足替换规则，conflicts是与它直接冲突的交易，evicted是将被驱逐的所有交易
+func CheckReplacement(replacement *MempoolEntry, conflicts, evicted []*MempoolEntry) error {
+	for _, conflict := range conflicts {
+		if !conflict.Tx.SignalsReplacement() {
+			return fmt.Errorf("交易与交易池中的交易%x花费了同一个output，并且原交易不允许替换", conflict.Tx.TXID)
+		}
+		if replacement.FeeRate() <= conflict.FeeRate() {
+			return fmt.Errorf("替换交易的手续费率%.8f必须高于原交易%x的%.8f", replacement.FeeRate(), conflict.Tx.TXID, conflict.FeeRate())
+		}
+	}
+	if len(evicted) > maxReplacementEvictions {
+		return fmt.Errorf("替换将驱逐%d笔交易，最多%d笔", len(evicted), maxReplacementEvictions)
+	}
+	if minFee := replacementMinFee(evicted, replacement.Size); replacement.Fee < minFee {
+		return fmt.Errorf("替换交易的手续费%.8f不足，至少需要%.8f", replacement.Fee, minFee)
+	}
+	return nil
+}
+
+//替换交易至少需要支付的手续费
+func replacementMinFee(evicted []*MempoolEntry, size int) float64 {
+	fee := 0.0
+	for _, entry := range evicted {
+		fee += entry.Fee
+	}
+	return fee + incrementalRelayFeeRate*float64(size)
+}
+
+//手续费向上取整到feePrecision
+func roundUpFee(fee float64) float64 {
+	return math.Ceil(fee/feePrecision) * feePrecision
+}
+
+func findMempoolEntry(entries []*MempoolEntry, txid []byte) *MempoolEntry {
+	for _, entry := range entries {
+		if bytes.Equal(entry.Tx.TXID, txid) {
+			return entry
+		}
+	}
+	return nil
+}
+
+//提高交易池中一笔允许替换的交易的手续费，返回签名后的替换交易
+//替换交易使用相同的input，减少找零output（付给input所属地址的output）的金额来支付更多的手续费
+//feeRate为0时使用原交易的手续费率加上incrementalRelayFeeRate
+func (bc *BlockChain) BumpFee(txid []byte, feeRate float64, ws *Wallets) (*Transaction, error) {
+	entries, err := bc.GetMempoolEntries()
+	if err != nil {
+		return nil, err
+	}
+	original := findMempoolEntry(entries, txid)
+	if original == nil {
+		return nil, errors.New("交易不在交易池中")
+	}
+	if !original.Tx.SignalsReplacement() {
+		return nil, errors.New("交易不允许替换，可以使用bumpFeeCPFP")
+	}
+	if feeRate == 0 {
+		feeRate = original.FeeRate() + incrementalRelayFeeRate
+	}
+	if feeRate <= original.FeeRate() {
+		return nil, fmt.Errorf("手续费率必须高于原交易的%.8f", original.FeeRate())
+	}
+	prevTXs, err := bc.FindPrevTransactionsForBlock(mempoolTransactions(entries))
+	if err != nil {
+		return nil, err
+	}
+	//找零output: 付给某个input所属地址的output
+	owners := make(map[string]bool)
+	for _, input := range original.Tx.TXInputs {
+		prevOutput := prevTXs[string(input.TXid)].TXOutputs[input.Index]
+		owners[prevOutput.Address()] = true
+	}
+	change := -1
+	for i, output := range original.Tx.TXOutputs {
+		if address := output.Address(); address != "" && owners[address] {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, errors.New("交易没有找零output，无法减少找零来支付手续费")
+	}
+	evicted := MempoolDescendants(entries, []*MempoolEntry{original})
+
+	//签名的长度会影响交易大小，所以签名之后再检查手续费，不足时提高手续费重新签名
+	fee := original.Fee
+	for {
+		var inputs []TXInput
+		for _, input := range original.Tx.TXInputs {
+			inputs = append(inputs, TXInput{input.TXid, input.Index, nil, nil, nil, input.Sequence})
+		}
+		outputs := append([]TXOutput{}, original.Tx.TXOutputs...)
+		outputs[change].Value -= fee - original.Fee
+		if outputs[change].Value < dustThreshold {
+			return nil, fmt.Errorf("找零不足以支付手续费%.8f", fee)
+		}
+		tx := NewRawTransaction(inputs, outputs, original.Tx.LockTime)
+		if _, complete := SignRawTransaction(tx, ws, prevTXs, SigHashAll); !complete {
+			return nil, errors.New("钱包中没有所有input的私钥")
+		}
+		size := tx.VirtualSize()
+		required := roundUpFee(math.Max(feeRate*float64(size), replacementMinFee(evicted, size)))
+		if tx.Fee(prevTXs) >= required {
+			return tx, nil
+		}
+		fee = math.Max(required, fee+feePrecision)
+	}
+}
+
+//为交易池中的交易创建一笔子交易，使交易包（子交易、这笔交易和它们在交易池中的祖先交易）的手续费率达到feeRate
+//子交易花费这笔交易中属于钱包的最大的output，转回同一个地址
+//feeRate为0时使用交易包当前的手续费率加上incrementalRelayFeeRate
+func (bc *BlockChain) BumpFeeCPFP(txid []byte, feeRate float64, ws *Wallets) (*Transaction, error) {
+	entries, err := bc.GetMempoolEntries()
+	if err != nil {
+		return nil, err
+	}
+	parent := findMempoolEntry(entries, txid)
+	if parent == nil {
+		return nil, errors.New("交易不在交易池中")
+	}
+	//交易池中的其他交易已经花费的output不能再花费
+	spent := make(map[string]bool)
+	markSpentOutputs(spent, mempoolTransactions(entries))
+	index := -1
+	for i, output := range parent.Tx.TXOutputs {
+		if spent[outpointKey(txid, int64(i))] || ws.WalletsMap[output.Address()] == nil {
+			continue
+		}
+		if index < 0 || output.Value > parent.Tx.TXOutputs[index].Value {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("交易中没有钱包可以花费的output")
+	}
+	pkg := append(MempoolAncestors(entries, parent.Tx), parent)
+	pkgFee := 0.0
+	pkgSize := 0
+	for _, entry := range pkg {
+		pkgFee += entry.Fee
+		pkgSize += entry.Size
+	}
+	if feeRate == 0 {
+		feeRate = PackageFeeRate(pkg) + incrementalRelayFeeRate
+	}
+	if feeRate <= PackageFeeRate(pkg) {
+		return nil, fmt.Errorf("手续费率必须高于交易包当前的%.8f", PackageFeeRate(pkg))
+	}
+
+	prevOutput := parent.Tx.TXOutputs[index]
+	prevTXs := map[string]Transaction{string(txid): *parent.Tx}
+	fee := 0.0
+	for {
+		value := prevOutput.Value - fee
+		if value < dustThreshold {
+			return nil, fmt.Errorf("output的金额%.8f不足以支付手续费%.8f", prevOutput.Value, fee)
+		}
+		input := TXInput{txid, int64(index), nil, nil, nil, SequenceDefault}
+		tx := NewRawTransaction([]TXInput{input}, []TXOutput{*NewTXOutput(value, prevOutput.Address())}, 0)
+		if _, complete := SignRawTransaction(tx, ws, prevTXs, SigHashAll); !complete {
+			return nil, errors.New("钱包中没有output的私钥")
+		}
+		size := tx.VirtualSize()
+		//子交易自己至少要支付转发费用
+		required := roundUpFee(math.Max(feeRate*float64(pkgSize+size)-pkgFee, incrementalRelayFeeRate*float64(size)))
+		if tx.Fee(prevTXs) >= required {
+			return tx, nil
+		}
+		fee = math.Max(required, fee+feePrecision)
+	}
+}
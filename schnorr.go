@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"log"
+	"math/big"
+)
+
+//Schnorr签名，参考BIP340，只用于secp256k1
+//1.公钥只保存x坐标（32字节），约定y坐标为偶数
+//2.签名为64字节: R的x坐标 || s，满足 s*G = R + e*P，e = H(R.x || P.x || m)
+//3.签名是线性的，多个签名可以合并成一个等式批量校验，多个公钥也可以聚合（见musig.go）
+
+//x-only公钥的字节数
+const schnorrPubKeySize = 32
+
+//带标签的hash: sha256(sha256(tag) || sha256(tag) || data)，不同用途的hash互不干扰
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hash := sha256.New()
+	hash.Write(tagHash[:])
+	hash.Write(tagHash[:])
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+func bytes32(x *big.Int) []byte {
+	return intToFixedBytes(x, 32)
+}
+
+func hasEvenY(y *big.Int) bool {
+	return y.Bit(0) == 0
+}
+
+//点取反: (x, p-y)
+func negatePoint(x, y *big.Int) (*big.Int, *big.Int) {
+	return x, new(big.Int).Sub(btcec.S256().P, y)
+}
+
+//btcec中无穷远点表示为(0, 0)
+func isInfinity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+//由x坐标恢复y为偶数的点
+func liftX(x *big.Int) (*big.Int, *big.Int, error) {
+	p := btcec.S256().P
+	if x.Cmp(p) >= 0 {
+		return nil, nil, errors.New("x坐标超出范围")
+	}
+	//y^2 = x^3 + 7
+	c := new(big.Int).Exp(x, big.NewInt(3), p)
+	c.Add(c, big.NewInt(7))
+	c.Mod(c, p)
+	//p % 4 == 3，平方根为 c^((p+1)/4)
+	e := new(big.Int).Add(p, big.NewInt(1))
+	e.Rsh(e, 2)
+	y := new(big.Int).Exp(c, e, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(c) != 0 {
+		return nil, nil, errors.New("x坐标不在曲线上")
+	}
+	if !hasEvenY(y) {
+		y.Sub(p, y)
+	}
+	return x, y, nil
+}
+
+//挑战值 e = H(R.x || P.x || m) mod n
+func schnorrChallenge(rx, px, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rx, px, msg))
+	return e.Mod(e, btcec.S256().N)
+}
+
+//私钥对应的x-only公钥
+func SchnorrPubKey(privateKey *ecdsa.PrivateKey) []byte {
+	return bytes32(privateKey.PublicKey.X)
+}
+
+//BIP340签名，辅助随机数固定为0，与ECDSA一样是确定性签名
+func SchnorrSign(privateKey *ecdsa.PrivateKey, msg []byte) []byte {
+	return schnorrSignAux(privateKey, msg, make([]byte, 32))
+}
+
+//指定32字节辅助随机数的BIP340签名
+func schnorrSignAux(privateKey *ecdsa.PrivateKey, msg, auxRand []byte) []byte {
+	curve := btcec.S256()
+	n := curve.N
+	//公钥的y为奇数时使用-d，使签名对应y为偶数的公钥
+	d := new(big.Int).Set(privateKey.D)
+	px, py := curve.ScalarBaseMult(bytes32(d))
+	if !hasEvenY(py) {
+		d.Sub(n, d)
+	}
+	aux := taggedHash("BIP0340/aux", auxRand)
+	t := bytes32(d)
+	for i := range t {
+		t[i] ^= aux[i]
+	}
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, bytes32(px), msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		log.Panic("Schnorr签名的随机数为0")
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if !hasEvenY(ry) {
+		k.Sub(n, k)
+	}
+	e := schnorrChallenge(bytes32(rx), bytes32(px), msg)
+	//s = k + e*d mod n
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(bytes32(rx), bytes32(s)...)
+}
+
+//解析公钥和签名，返回公钥的点以及r，s
+func parseSchnorr(pubKey, signature []byte) (*big.Int, *big.Int, *big.Int, *big.Int, error) {
+	if len(pubKey) != schnorrPubKeySize || len(signature) != signatureSize {
+		return nil, nil, nil, nil, errors.New("Schnorr公钥或签名长度错误")
+	}
+	px, py, err := liftX(new(big.Int).SetBytes(pubKey))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	curve := btcec.S256()
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return nil, nil, nil, nil, errors.New("Schnorr签名超出范围")
+	}
+	return px, py, r, s, nil
+}
+
+//BIP340校验: R = s*G - e*P，R不能是无穷远点，y为偶数并且x等于r
+func SchnorrVerify(pubKey, msg, signature []byte) bool {
+	px, py, r, s, err := parseSchnorr(pubKey, signature)
+	if err != nil {
+		return false
+	}
+	curve := btcec.S256()
+	e := schnorrChallenge(signature[:32], pubKey, msg)
+	sgx, sgy := curve.ScalarBaseMult(bytes32(s))
+	epx, epy := curve.ScalarMult(px, py, bytes32(new(big.Int).Sub(curve.N, e)))
+	rx, ry := curve.Add(sgx, sgy, epx, epy)
+	return !isInfinity(rx, ry) && hasEvenY(ry) && rx.Cmp(r) == 0
+}
+
+//Schnorr批量校验，先收集签名，最后一次校验
+//随机系数a_i: (a_1*s_1 + ... + a_u*s_u)*G = a_1*R_1 + ... + a_u*R_u + a_1*e_1*P_1 + ... + a_u*e_u*P_u
+//任何一个签名无效时等式不成立（除非能预测随机系数）
+type SchnorrBatch struct {
+	pubKeys    [][]byte
+	msgs       [][]byte
+	signatures [][]byte
+}
+
+func (batch *SchnorrBatch) Add(pubKey, msg, signature []byte) {
+	batch.pubKeys = append(batch.pubKeys, pubKey)
+	batch.msgs = append(batch.msgs, msg)
+	batch.signatures = append(batch.signatures, signature)
+}
+
+func (batch *SchnorrBatch) Len() int {
+	return len(batch.signatures)
+}
+
+func (batch *SchnorrBatch) Verify() bool {
+	if batch.Len() == 0 {
+		return true
+	}
+	if batch.Len() == 1 {
+		return SchnorrVerify(batch.pubKeys[0], batch.msgs[0], batch.signatures[0])
+	}
+	curve := btcec.S256()
+	n := curve.N
+	lhs := new(big.Int)
+	rhsX, rhsY := new(big.Int), new(big.Int)
+	for i := range batch.signatures {
+		px, py, r, s, err := parseSchnorr(batch.pubKeys[i], batch.signatures[i])
+		if err != nil {
+			return false
+		}
+		rx, ry, err := liftX(r)
+		if err != nil {
+			return false
+		}
+		//第一个系数为1，其余为随机数
+		a := big.NewInt(1)
+		if i > 0 {
+			a, err = rand.Int(rand.Reader, n)
+			if err != nil {
+				log.Panic(err)
+			}
+			if a.Sign() == 0 {
+				a.SetInt64(1)
+			}
+		}
+		e := schnorrChallenge(batch.signatures[i][:32], batch.pubKeys[i], batch.msgs[i])
+		lhs.Add(lhs, new(big.Int).Mul(a, s))
+		lhs.Mod(lhs, n)
+		arx, ary := curve.ScalarMult(rx, ry, bytes32(a))
+		ae := new(big.Int).Mul(a, e)
+		aepx, aepy := curve.ScalarMult(px, py, bytes32(ae.Mod(ae, n)))
+		rhsX, rhsY = curve.Add(rhsX, rhsY, arx, ary)
+		rhsX, rhsY = curve.Add(rhsX, rhsY, aepx, aepy)
+	}
+	lhsX, lhsY := curve.ScalarBaseMult(bytes32(lhs))
+	return lhsX.Cmp(rhsX) == 0 && lhsY.Cmp(rhsY) == 0
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func hexToBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("16进制格式错误: %s", s)
+	}
+	return data
+}
+
+//BIP340的官方测试向量(bip-0340/test-vectors.csv)
+//有私钥的向量同时校验签名结果，没有私钥的只校验验证结果
+var bip340Vectors = []struct {
+	d         string
+	pubKey    string
+	auxRand   string
+	msg       string
+	signature string
+	valid     bool
+	comment   string
+}{
+	{"0000000000000000000000000000000000000000000000000000000000000003",
+		"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		true, ""},
+	{"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		true, ""},
+	{"C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+		"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		"C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+		"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+		"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		true, ""},
+	{"0B432B2677937381AEF05BB02A66ECD012773062CF3FA2549E44F58ED2401710",
+		"25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		"7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3",
+		true, "消息不能对p或n取模"},
+	{"",
+		"D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9",
+		"",
+		"4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+		"00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4",
+		true, ""},
+	{"",
+		"EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false, "公钥不在曲线上"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2",
+		false, "R的y为奇数"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD",
+		false, "消息取反"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6",
+		false, "s取反"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051",
+		false, "sG - eP是无穷远点，r为0"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197",
+		false, "sG - eP是无穷远点，r为1"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false, "r不是曲线上点的x坐标"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false, "r等于p"},
+	{"",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141",
+		false, "s等于n"},
+	{"",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+		"",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false, "公钥超出p"},
+	{"0340034003400340034003400340034003400340034003400340034003400340",
+		"778CAA53B4393AC467774D09497A87224BF9FAB6F6E68B23086497324D6FD117",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"",
+		"71535DB165ECD9FBBC046E5FFAEA61186BB6AD436732FCCC25291A55895464CF6069CE26BF03466228F19A3A62DB8A649F2D560FAC652827D1AF0574E427AB63",
+		true, "消息长度为0"},
+	{"0340034003400340034003400340034003400340034003400340034003400340",
+		"778CAA53B4393AC467774D09497A87224BF9FAB6F6E68B23086497324D6FD117",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"11",
+		"08A20A0AFEF64124649232E0693C583AB1B9934AE63B4C3511F3AE1134C6A303EA3173BFEA6683BD101FA5AA5DBC1996FE7CACFC5A577D33EC14564CEC2BACBF",
+		true, "消息长度为1"},
+	{"0340034003400340034003400340034003400340034003400340034003400340",
+		"778CAA53B4393AC467774D09497A87224BF9FAB6F6E68B23086497324D6FD117",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0102030405060708090A0B0C0D0E0F1011",
+		"5130F39A4059B43BC7CAC09A19ECE52B5D8699D1A71E3C52DA9AFDB6B50AC370C4A482B77BF960F8681540E25B6771ECE1E5A37FD80E5A51897C5566A97EA5A5",
+		true, "消息长度为17"},
+}
+
+func TestSchnorrBIP340Vectors(t *testing.T) {
+	for i, test := range bip340Vectors {
+		pubKey := hexToBytes(t, test.pubKey)
+		msg := hexToBytes(t, test.msg)
+		signature := hexToBytes(t, test.signature)
+		if test.d != "" {
+			privateKey := PrivateKeyFromBytes(KeyTypeSchnorr, hexToBytes(t, test.d))
+			if !bytes.Equal(SchnorrPubKey(privateKey), pubKey) {
+				t.Errorf("向量%d: 公钥 = %X", i, SchnorrPubKey(privateKey))
+			}
+			sig := schnorrSignAux(privateKey, msg, hexToBytes(t, test.auxRand))
+			if !bytes.Equal(sig, signature) {
+				t.Errorf("向量%d: 签名 = %X", i, sig)
+			}
+		}
+		if SchnorrVerify(pubKey, msg, signature) != test.valid {
+			t.Errorf("向量%d(%s): 校验结果应为%v", i, test.comment, test.valid)
+		}
+	}
+}
+
+//批量校验的结果必须和逐个校验一致，任何一个签名无效整批都无效
+func TestSchnorrBatchVerify(t *testing.T) {
+	var batch SchnorrBatch
+	if !batch.Verify() {
+		t.Fatal("空的批量校验应该通过")
+	}
+	var pubKeys, msgs, signatures [][]byte
+	for i := 0; i < 5; i++ {
+		privateKey := NewPrivateKey(KeyTypeSchnorr)
+		msg := sha256.Sum256([]byte{byte(i)})
+		pubKeys = append(pubKeys, SchnorrPubKey(privateKey))
+		msgs = append(msgs, msg[:])
+		signatures = append(signatures, SchnorrSign(privateKey, msg[:]))
+		batch.Add(pubKeys[i], msgs[i], signatures[i])
+	}
+	if batch.Len() != 5 || !batch.Verify() {
+		t.Fatal("有效签名的批量校验失败")
+	}
+	//BIP340向量中验证通过的签名也可以一起批量校验
+	for _, test := range bip340Vectors {
+		if test.valid {
+			batch.Add(hexToBytes(t, test.pubKey), hexToBytes(t, test.msg), hexToBytes(t, test.signature))
+		}
+	}
+	if !batch.Verify() {
+		t.Fatal("BIP340向量的批量校验失败")
+	}
+
+	for bad := range signatures {
+		var batch SchnorrBatch
+		for i := range signatures {
+			signature := signatures[i]
+			if i == bad {
+				//s加1，单独校验也无效
+				signature = append([]byte{}, signature...)
+				signature[63]++
+			}
+			batch.Add(pubKeys[i], msgs[i], signature)
+		}
+		if batch.Verify() {
+			t.Errorf("第%d个签名无效，批量校验却通过了", bad)
+		}
+	}
+	//签名对应的消息错位
+	batch = SchnorrBatch{}
+	for i := range signatures {
+		batch.Add(pubKeys[i], msgs[(i+1)%len(msgs)], signatures[i])
+	}
+	if batch.Verify() {
+		t.Error("消息错位的批量校验通过了")
+	}
+	//BIP340中的无效签名加入批量校验
+	for i, test := range bip340Vectors {
+		if test.valid {
+			continue
+		}
+		batch := SchnorrBatch{}
+		batch.Add(pubKeys[0], msgs[0], signatures[0])
+		batch.Add(hexToBytes(t, test.pubKey), hexToBytes(t, test.msg), hexToBytes(t, test.signature))
+		if batch.Verify() {
+			t.Errorf("向量%d(%s): 批量校验通过了", i, test.comment)
+		}
+	}
+}
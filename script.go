@@ -0,0 +1,915 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ripemd160"
+	"strings"
+)
+
+//参考比特币脚本实现的一个小型堆栈脚本引擎
+//output使用锁定脚本(ScriptPubKey)锁定，input使用解锁脚本(ScriptSig)解锁
+//校验时先执行解锁脚本，再在同一个栈上执行锁定脚本，最后栈顶为真则校验通过
+
+//操作码
+const (
+	OP_0         = 0x00
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_1NEGATE   = 0x4f
+	OP_1         = 0x51
+	OP_16        = 0x60
+
+	//流程控制
+	OP_NOP    = 0x61
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_VERIFY = 0x69
+	OP_RETURN = 0x6a
+
+	//栈操作
+	OP_TOALTSTACK   = 0x6b
+	OP_FROMALTSTACK = 0x6c
+	OP_2DROP        = 0x6d
+	OP_2DUP         = 0x6e
+	OP_DEPTH        = 0x74
+	OP_DROP         = 0x75
+	OP_DUP          = 0x76
+	OP_NIP          = 0x77
+	OP_OVER         = 0x78
+	OP_SWAP         = 0x7c
+	OP_SIZE         = 0x82
+
+	//比较
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+
+	//算术
+	OP_1ADD        = 0x8b
+	OP_1SUB        = 0x8c
+	OP_NOT         = 0x91
+	OP_ADD         = 0x93
+	OP_SUB         = 0x94
+	OP_BOOLAND     = 0x9a
+	OP_BOOLOR      = 0x9b
+	OP_NUMEQUAL    = 0x9c
+	OP_LESSTHAN    = 0x9f
+	OP_GREATERTHAN = 0xa0
+	OP_WITHIN      = 0xa5
+
+	//哈希
+	OP_RIPEMD160 = 0xa6
+	OP_SHA256    = 0xa8
+	OP_HASH160   = 0xa9
+	OP_HASH256   = 0xaa
+
+	//签名
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	//时间锁
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+)
+
+//脚本限制
+const (
+	//脚本的最大字节数
+	maxScriptSize = 10000
+	//栈中单个元素的最大字节数
+	maxScriptElementSize = 520
+	//每个脚本中非push操作的最大个数
+	maxOpsPerScript = 201
+	//主栈和辅助栈元素个数之和的上限
+	maxStackSize = 1000
+	//多重签名中公钥的最大个数
+	maxPubKeysPerMultiSig = 20
+	//数字的最大字节数
+	maxScriptNumLen = 4
+	//时间锁的数字可以是5个字节，否则无法表示2038年之后的时间
+	maxLockTimeNumLen = 5
+)
+
+var opcodeNames = map[byte]string{
+	OP_0: "OP_0", OP_PUSHDATA1: "OP_PUSHDATA1", OP_PUSHDATA2: "OP_PUSHDATA2", OP_1NEGATE: "OP_1NEGATE",
+	OP_NOP: "OP_NOP", OP_IF: "OP_IF", OP_NOTIF: "OP_NOTIF", OP_ELSE: "OP_ELSE", OP_ENDIF: "OP_ENDIF",
+	OP_VERIFY: "OP_VERIFY", OP_RETURN: "OP_RETURN",
+	OP_TOALTSTACK: "OP_TOALTSTACK", OP_FROMALTSTACK: "OP_FROMALTSTACK", OP_2DROP: "OP_2DROP", OP_2DUP: "OP_2DUP",
+	OP_DEPTH: "OP_DEPTH", OP_DROP: "OP_DROP", OP_DUP: "OP_DUP", OP_NIP: "OP_NIP", OP_OVER: "OP_OVER",
+	OP_SWAP: "OP_SWAP", OP_SIZE: "OP_SIZE",
+	OP_EQUAL: "OP_EQUAL", OP_EQUALVERIFY: "OP_EQUALVERIFY",
+	OP_1ADD: "OP_1ADD", OP_1SUB: "OP_1SUB", OP_NOT: "OP_NOT", OP_ADD: "OP_ADD", OP_SUB: "OP_SUB",
+	OP_BOOLAND: "OP_BOOLAND", OP_BOOLOR: "OP_BOOLOR", OP_NUMEQUAL: "OP_NUMEQUAL",
+	OP_LESSTHAN: "OP_LESSTHAN", OP_GREATERTHAN: "OP_GREATERTHAN", OP_WITHIN: "OP_WITHIN",
+	OP_RIPEMD160: "OP_RIPEMD160", OP_SHA256: "OP_SHA256", OP_HASH160: "OP_HASH160", OP_HASH256: "OP_HASH256",
+	OP_CHECKSIG: "OP_CHECKSIG", OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG: "OP_CHECKMULTISIG", OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY", OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+//解析后的一条指令
+type ScriptOp struct {
+	Opcode byte
+	//push指令携带的数据
+	Data []byte
+}
+
+//是否为push数据的指令（包括OP_0、OP_1NEGATE、OP_1到OP_16）
+func (op ScriptOp) IsPush() bool {
+	return op.Opcode <= OP_PUSHDATA2 || op.Opcode == OP_1NEGATE || (op.Opcode >= OP_1 && op.Opcode <= OP_16)
+}
+
+//把脚本解析成指令序列
+func ParseScript(script []byte) ([]ScriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, errors.New("脚本超过最大长度")
+	}
+	var ops []ScriptOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+		var size int
+		switch {
+		case opcode > OP_0 && opcode < OP_PUSHDATA1:
+			size = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("脚本格式错误: OP_PUSHDATA1缺少长度")
+			}
+			size = int(script[i])
+			i++
+		case opcode == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("脚本格式错误: OP_PUSHDATA2缺少长度")
+			}
+			size = int(binary.LittleEndian.Uint16(script[i : i+2]))
+			i += 2
+		default:
+			ops = append(ops, ScriptOp{opcode, nil})
+			continue
+		}
+		if i+size > len(script) {
+			return nil, errors.New("脚本格式错误: push数据长度越界")
+		}
+		ops = append(ops, ScriptOp{opcode, script[i : i+size]})
+		i += size
+	}
+	return ops, nil
+}
+
+//判断脚本是否只包含push指令，解锁脚本必须满足这个条件
+func IsPushOnlyScript(script []byte) bool {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.IsPush() {
+			return false
+		}
+	}
+	return true
+}
+
+//把脚本转换成可读的字符串
+func DisasmScript(script []byte) string {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[错误: %s] %x", err, script)
+	}
+	var parts []string
+	for _, op := range ops {
+		switch {
+		case op.Opcode > OP_0 && op.Opcode <= OP_PUSHDATA2:
+			parts = append(parts, hex.EncodeToString(op.Data))
+		case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.Opcode-OP_1+1))
+		case opcodeNames[op.Opcode] != "":
+			parts = append(parts, opcodeNames[op.Opcode])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN(0x%02x)", op.Opcode))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+//脚本构造器
+type ScriptBuilder struct {
+	script []byte
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+//使用最短的方式push数据
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	size := len(data)
+	switch {
+	case size == 0:
+		b.script = append(b.script, OP_0)
+	case size < OP_PUSHDATA1:
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(size))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(size), byte(size>>8))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+//push一个数字，0到16使用专门的操作码
+func (b *ScriptBuilder) AddInt64(num int64) *ScriptBuilder {
+	switch {
+	case num == 0:
+		b.script = append(b.script, OP_0)
+	case num == -1:
+		b.script = append(b.script, OP_1NEGATE)
+	case num >= 1 && num <= 16:
+		b.script = append(b.script, byte(OP_1-1+num))
+	default:
+		b.AddData(EncodeScriptNum(num))
+	}
+	return b
+}
+
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+//脚本中的数字使用小端编码，最高字节的最高位是符号位
+func EncodeScriptNum(num int64) []byte {
+	if num == 0 {
+		return nil
+	}
+	negative := num < 0
+	if negative {
+		num = -num
+	}
+	var result []byte
+	for num > 0 {
+		result = append(result, byte(num&0xff))
+		num >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+func DecodeScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, errors.New("脚本数字超过最大长度")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var result int64
+	for i, b := range data {
+		result |= int64(b) << uint(8*i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		return -result, nil
+	}
+	return result, nil
+}
+
+//把栈中的元素当作布尔值，全0（包括负0）为假
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			//负0
+			if i == len(data)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func boolToStack(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return nil
+}
+
+func hash160(data []byte) []byte {
+	return HashPubKey(data)
+}
+
+//脚本执行环境
+type scriptEngine struct {
+	//正在校验的交易，以及input的索引
+	tx         *Transaction
+	inputIndex int
+	stack      [][]byte
+	altStack   [][]byte
+	//不为空时OP_CHECKSIG的非空Schnorr签名延迟到批量校验
+	batch *SchnorrBatch
+	//不为空时缓存中的签名不再校验，校验通过的签名加入缓存
+	sigCache *SigCache
+}
+
+//执行脚本并校验，解锁脚本和锁定脚本都执行成功并且栈顶为真时返回nil
+func VerifyScript(unlockingScript, lockingScript []byte, tx *Transaction, inputIndex int) error {
+	return VerifyScriptWithBatch(unlockingScript, lockingScript, tx, inputIndex, nil)
+}
+
+//batch不为空时，OP_CHECKSIG遇到的非空Schnorr签名先视为有效并加入batch，由调用方最后批量校验
+//校验失败的非空签名是脚本错误（NULLFAIL，见checkSigResult），结果为假的签名只能为空
+//所以延迟校验不会改变脚本的执行结果：批量校验失败与直接校验失败一样使整个input无效
+func VerifyScriptWithBatch(unlockingScript, lockingScript []byte, tx *Transaction, inputIndex int, batch *SchnorrBatch) error {
+	return VerifyScriptWithCache(unlockingScript, lockingScript, tx, inputIndex, batch, nil)
+}
+
+//sigCache不为空时使用签名缓存，可以与batch同时使用
+func VerifyScriptWithCache(unlockingScript, lockingScript []byte, tx *Transaction, inputIndex int, batch *SchnorrBatch, sigCache *SigCache) error {
+	if !IsPushOnlyScript(unlockingScript) {
+		return errors.New("解锁脚本只能包含push指令")
+	}
+	vm := scriptEngine{tx: tx, inputIndex: inputIndex, batch: batch, sigCache: sigCache}
+	if err := vm.execute(unlockingScript); err != nil {
+		return err
+	}
+	//P2SH需要在解锁脚本执行后的栈上再执行赎回脚本
+	stackCopy := append([][]byte{}, vm.stack...)
+	if err := vm.execute(lockingScript); err != nil {
+		return err
+	}
+	if len(vm.stack) == 0 || !castToBool(vm.stack[len(vm.stack)-1]) {
+		return errors.New("脚本执行结束后栈顶为假")
+	}
+
+	if _, ok := ExtractP2SHScriptHash(lockingScript); ok {
+		//锁定脚本已经校验了赎回脚本的hash，栈顶就是赎回脚本
+		redeemScript := stackCopy[len(stackCopy)-1]
+		vm.stack = stackCopy[:len(stackCopy)-1]
+		vm.altStack = nil
+		if err := vm.execute(redeemScript); err != nil {
+			return err
+		}
+		if len(vm.stack) == 0 || !castToBool(vm.stack[len(vm.stack)-1]) {
+			return errors.New("赎回脚本执行结束后栈顶为假")
+		}
+	}
+	return nil
+}
+
+func (vm *scriptEngine) push(data []byte) error {
+	if len(data) > maxScriptElementSize {
+		return errors.New("栈元素超过最大长度")
+	}
+	vm.stack = append(vm.stack, data)
+	if len(vm.stack)+len(vm.altStack) > maxStackSize {
+		return errors.New("栈超过最大深度")
+	}
+	return nil
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("栈为空")
+	}
+	data := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return data, nil
+}
+
+//查看栈顶往下第n个元素（0为栈顶）
+func (vm *scriptEngine) peek(n int) ([]byte, error) {
+	if n >= len(vm.stack) {
+		return nil, errors.New("栈中元素不足")
+	}
+	return vm.stack[len(vm.stack)-1-n], nil
+}
+
+func (vm *scriptEngine) popNum() (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	return DecodeScriptNum(data, maxScriptNumLen)
+}
+
+func (vm *scriptEngine) popBool() (bool, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return false, err
+	}
+	return castToBool(data), nil
+}
+
+//执行一段脚本，script同时作为签名时使用的子脚本
+func (vm *scriptEngine) execute(script []byte) error {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return err
+	}
+	//条件栈，记录每一层IF是否执行
+	var condStack []bool
+	opCount := 0
+	for _, op := range ops {
+		executing := true
+		for _, cond := range condStack {
+			if !cond {
+				executing = false
+				break
+			}
+		}
+		if !op.IsPush() {
+			opCount++
+			if opCount > maxOpsPerScript {
+				return errors.New("脚本操作数超过上限")
+			}
+		}
+
+		//条件语句即使在不执行的分支中也要处理嵌套关系
+		switch op.Opcode {
+		case OP_IF, OP_NOTIF:
+			value := false
+			if executing {
+				value, err = vm.popBool()
+				if err != nil {
+					return err
+				}
+				if op.Opcode == OP_NOTIF {
+					value = !value
+				}
+			}
+			condStack = append(condStack, value)
+			continue
+		case OP_ELSE:
+			if len(condStack) == 0 {
+				return errors.New("OP_ELSE没有对应的OP_IF")
+			}
+			condStack[len(condStack)-1] = !condStack[len(condStack)-1]
+			continue
+		case OP_ENDIF:
+			if len(condStack) == 0 {
+				return errors.New("OP_ENDIF没有对应的OP_IF")
+			}
+			condStack = condStack[:len(condStack)-1]
+			continue
+		}
+		if !executing {
+			continue
+		}
+		if err := vm.step(op, script); err != nil {
+			return err
+		}
+	}
+	if len(condStack) != 0 {
+		return errors.New("OP_IF没有对应的OP_ENDIF")
+	}
+	return nil
+}
+
+//执行一条指令
+func (vm *scriptEngine) step(op ScriptOp, script []byte) error {
+	switch {
+	case op.Opcode == OP_0:
+		return vm.push(nil)
+	case op.Opcode < OP_PUSHDATA1 || op.Opcode == OP_PUSHDATA1 || op.Opcode == OP_PUSHDATA2:
+		return vm.push(op.Data)
+	case op.Opcode == OP_1NEGATE:
+		return vm.push(EncodeScriptNum(-1))
+	case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+		return vm.push(EncodeScriptNum(int64(op.Opcode - OP_1 + 1)))
+	}
+
+	switch op.Opcode {
+	case OP_NOP:
+		return nil
+	case OP_VERIFY:
+		value, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+		if !value {
+			return errors.New("OP_VERIFY失败")
+		}
+		return nil
+	case OP_RETURN:
+		return errors.New("执行了OP_RETURN，output不可花费")
+
+	case OP_TOALTSTACK:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.altStack = append(vm.altStack, data)
+		return nil
+	case OP_FROMALTSTACK:
+		if len(vm.altStack) == 0 {
+			return errors.New("辅助栈为空")
+		}
+		data := vm.altStack[len(vm.altStack)-1]
+		vm.altStack = vm.altStack[:len(vm.altStack)-1]
+		return vm.push(data)
+	case OP_2DROP:
+		if _, err := vm.pop(); err != nil {
+			return err
+		}
+		_, err := vm.pop()
+		return err
+	case OP_2DUP:
+		a, err := vm.peek(1)
+		if err != nil {
+			return err
+		}
+		b, _ := vm.peek(0)
+		if err := vm.push(a); err != nil {
+			return err
+		}
+		return vm.push(b)
+	case OP_DEPTH:
+		return vm.push(EncodeScriptNum(int64(len(vm.stack))))
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+	case OP_DUP:
+		data, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		return vm.push(data)
+	case OP_NIP:
+		top, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if _, err := vm.pop(); err != nil {
+			return err
+		}
+		return vm.push(top)
+	case OP_OVER:
+		data, err := vm.peek(1)
+		if err != nil {
+			return err
+		}
+		return vm.push(data)
+	case OP_SWAP:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.stack = append(vm.stack, a, b)
+		return nil
+	case OP_SIZE:
+		data, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		return vm.push(EncodeScriptNum(int64(len(data))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op.Opcode == OP_EQUALVERIFY {
+			if !equal {
+				return errors.New("OP_EQUALVERIFY失败")
+			}
+			return nil
+		}
+		return vm.push(boolToStack(equal))
+
+	case OP_1ADD, OP_1SUB, OP_NOT:
+		num, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		switch op.Opcode {
+		case OP_1ADD:
+			num++
+		case OP_1SUB:
+			num--
+		case OP_NOT:
+			if num == 0 {
+				num = 1
+			} else {
+				num = 0
+			}
+		}
+		return vm.push(EncodeScriptNum(num))
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_LESSTHAN, OP_GREATERTHAN:
+		b, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		a, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		var result int64
+		switch op.Opcode {
+		case OP_ADD:
+			result = a + b
+		case OP_SUB:
+			result = a - b
+		case OP_BOOLAND:
+			result = boolToNum(a != 0 && b != 0)
+		case OP_BOOLOR:
+			result = boolToNum(a != 0 || b != 0)
+		case OP_NUMEQUAL:
+			result = boolToNum(a == b)
+		case OP_LESSTHAN:
+			result = boolToNum(a < b)
+		case OP_GREATERTHAN:
+			result = boolToNum(a > b)
+		}
+		return vm.push(EncodeScriptNum(result))
+	case OP_WITHIN:
+		max, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		min, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		x, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		return vm.push(boolToStack(min <= x && x < max))
+
+	case OP_RIPEMD160, OP_SHA256, OP_HASH160, OP_HASH256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		var result []byte
+		switch op.Opcode {
+		case OP_RIPEMD160:
+			hasher := ripemd160.New()
+			hasher.Write(data)
+			result = hasher.Sum(nil)
+		case OP_SHA256:
+			hash := sha256.Sum256(data)
+			result = hash[:]
+		case OP_HASH160:
+			result = hash160(data)
+		case OP_HASH256:
+			hash1 := sha256.Sum256(data)
+			hash2 := sha256.Sum256(hash1[:])
+			result = hash2[:]
+		}
+		return vm.push(result)
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		var valid bool
+		if vm.batch != nil && vm.tx != nil && len(signature) != 0 && GetPubKeyType(pubKey) == KeyTypeSchnorr {
+			//格式错误的签名在两种路径中都是脚本错误
+			sig, hashType, err := SplitSignature(signature)
+			if err != nil {
+				return err
+			}
+			hash, err := vm.tx.SignatureHashForType(vm.inputIndex, script, hashType)
+			if err != nil {
+				return err
+			}
+			if vm.sigCache == nil || !vm.sigCache.Contains(pubKey, hash, sig) {
+				vm.batch.Add(pubKey, hash, sig)
+			}
+			valid = true
+		} else {
+			valid = vm.checkSignature(pubKey, signature, script)
+			if err := checkSigResult(valid, [][]byte{signature}); err != nil {
+				return err
+			}
+		}
+		if op.Opcode == OP_CHECKSIGVERIFY {
+			if !valid {
+				return errors.New("OP_CHECKSIGVERIFY失败")
+			}
+			return nil
+		}
+		return vm.push(boolToStack(valid))
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := vm.checkMultiSig(script)
+		if err != nil {
+			return err
+		}
+		if op.Opcode == OP_CHECKMULTISIGVERIFY {
+			if !valid {
+				return errors.New("OP_CHECKMULTISIGVERIFY失败")
+			}
+			return nil
+		}
+		return vm.push(boolToStack(valid))
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		return vm.checkLockTime()
+	case OP_CHECKSEQUENCEVERIFY:
+		return vm.checkSequence()
+	}
+	return fmt.Errorf("未知的操作码: 0x%02x", op.Opcode)
+}
+
+//NULLFAIL：签名校验失败时所有签名都必须为空，否则是脚本错误
+//这样结果为假的签名操作（例如 <sig> <pubKey> OP_CHECKSIG OP_NOT）只能使用空签名
+//无论是否延迟到批量校验，非空的无效签名都会使input无效，交易池和区块的校验结果一致
+func checkSigResult(valid bool, signatures [][]byte) error {
+	if valid {
+		return nil
+	}
+	for _, signature := range signatures {
+		if len(signature) != 0 {
+			return errors.New("签名校验失败，结果为假的签名必须为空")
+		}
+	}
+	return nil
+}
+
+func boolToNum(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+//校验签名，签名数据由交易、当前执行的子脚本以及签名最后一个字节的签名类型生成
+func (vm *scriptEngine) checkSignature(pubKey, signature, subScript []byte) bool {
+	if vm.tx == nil || len(signature) == 0 {
+		return false
+	}
+	sig, hashType, err := SplitSignature(signature)
+	if err != nil {
+		return false
+	}
+	hash, err := vm.tx.SignatureHashForType(vm.inputIndex, subScript, hashType)
+	if err != nil {
+		return false
+	}
+	if vm.sigCache == nil {
+		return VerifySignature(pubKey, hash, sig)
+	}
+	if vm.sigCache.Contains(pubKey, hash, sig) {
+		return true
+	}
+	if !VerifySignature(pubKey, hash, sig) {
+		return false
+	}
+	vm.sigCache.Add(pubKey, hash, sig)
+	return true
+}
+
+//OP_CHECKLOCKTIMEVERIFY：栈顶的锁定时间不能大于交易的LockTime，两者必须同为高度或同为时间
+//栈顶元素不出栈，通常后面跟一个OP_DROP
+func (vm *scriptEngine) checkLockTime() error {
+	if vm.tx == nil {
+		return errors.New("OP_CHECKLOCKTIMEVERIFY需要交易")
+	}
+	data, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+	lockTime, err := DecodeScriptNum(data, maxLockTimeNumLen)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return errors.New("锁定时间不能为负数")
+	}
+	txLockTime := vm.tx.LockTime
+	if (uint64(lockTime) < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return errors.New("锁定时间类型不一致")
+	}
+	if uint64(lockTime) > txLockTime {
+		return errors.New("锁定时间未到")
+	}
+	//序列号为SequenceFinal时交易的LockTime不生效，必须禁止
+	if vm.tx.TXInputs[vm.inputIndex].Sequence == SequenceFinal {
+		return errors.New("input的序列号不能为SequenceFinal")
+	}
+	return nil
+}
+
+//OP_CHECKSEQUENCEVERIFY：栈顶的相对锁定时间不能大于input的序列号，两者必须同为高度或同为时间
+//栈顶元素不出栈，通常后面跟一个OP_DROP
+func (vm *scriptEngine) checkSequence() error {
+	if vm.tx == nil {
+		return errors.New("OP_CHECKSEQUENCEVERIFY需要交易")
+	}
+	data, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+	value, err := DecodeScriptNum(data, maxLockTimeNumLen)
+	if err != nil {
+		return err
+	}
+	if value < 0 {
+		return errors.New("相对锁定时间不能为负数")
+	}
+	sequence := uint32(value)
+	//设置了禁用标志时相当于OP_NOP
+	if sequence&SequenceLockTimeDisableFlag != 0 {
+		return nil
+	}
+	txSequence := vm.tx.TXInputs[vm.inputIndex].Sequence
+	if txSequence&SequenceLockTimeDisableFlag != 0 {
+		return errors.New("input没有启用相对时间锁")
+	}
+	if sequence&SequenceLockTimeTypeFlag != txSequence&SequenceLockTimeTypeFlag {
+		return errors.New("相对锁定时间类型不一致")
+	}
+	if sequence&SequenceLockTimeMask > txSequence&SequenceLockTimeMask {
+		return errors.New("相对锁定时间未到")
+	}
+	return nil
+}
+
+//多重签名校验，栈中的数据从栈顶开始依次是：
+//公钥个数n，n个公钥，签名个数m，m个签名
+//签名的顺序必须与公钥的顺序一致
+//注意：与比特币不同，这里不需要额外的OP_0占位
+func (vm *scriptEngine) checkMultiSig(script []byte) (bool, error) {
+	numPubKeys, err := vm.popNum()
+	if err != nil {
+		return false, err
+	}
+	if numPubKeys < 0 || numPubKeys > maxPubKeysPerMultiSig {
+		return false, errors.New("多重签名公钥个数无效")
+	}
+	pubKeys := make([][]byte, numPubKeys)
+	for i := int64(0); i < numPubKeys; i++ {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+	numSigs, err := vm.popNum()
+	if err != nil {
+		return false, err
+	}
+	if numSigs < 0 || numSigs > numPubKeys {
+		return false, errors.New("多重签名签名个数无效")
+	}
+	signatures := make([][]byte, numSigs)
+	for i := int64(0); i < numSigs; i++ {
+		signatures[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+	//公钥和签名都是按照push的逆序弹出的，依次匹配即可保持原来的顺序
+	keyIndex := 0
+	for _, signature := range signatures {
+		matched := false
+		for keyIndex < len(pubKeys) {
+			pubKey := pubKeys[keyIndex]
+			keyIndex++
+			if vm.checkSignature(pubKey, signature, script) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, checkSigResult(false, signatures)
+		}
+	}
+	return true, nil
+}
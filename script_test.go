@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+//创建一笔花费任意output的交易，用于执行脚本
+func newScriptTestTX() *Transaction {
+	input := TXInput{[]byte{0x01}, 0, nil, nil, nil, SequenceFinal}
+	tx := Transaction{nil, []TXInput{input}, []TXOutput{{1, nil, NewP2PKHScript(make([]byte, 20))}}, 0}
+	tx.SetHash()
+	return &tx
+}
+
+//NULLFAIL：非空的无效签名是脚本错误，直接校验和批量校验的结果一致
+func TestScriptNullFail(t *testing.T) {
+	wallet := NewWallet(KeyTypeSchnorr)
+	tx := newScriptTestTX()
+	checkSigNot := NewScriptBuilder().AddData(wallet.PubKey).AddOp(OP_CHECKSIG).AddOp(OP_NOT).Script()
+	multiSig, err := NewMultiSigScript(1, [][]byte{wallet.PubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiSigNot := append(multiSig, OP_NOT)
+
+	badSig := tx.SignInputWithScript(0, wallet, checkSigNot)
+	badSig[0] ^= 0xff
+	badMultiSig := tx.SignInputWithScript(0, wallet, multiSigNot)
+	badMultiSig[0] ^= 0xff
+	tests := []struct {
+		name      string
+		signature []byte
+		locking   []byte
+		valid     bool
+	}{
+		{"空签名", []byte{}, checkSigNot, true},
+		{"无效签名", badSig, checkSigNot, false},
+		{"格式错误的签名", []byte{0x01, 0x02}, checkSigNot, false},
+		{"多重签名空签名", []byte{}, multiSigNot, true},
+		{"多重签名无效签名", badMultiSig, multiSigNot, false},
+	}
+	for _, test := range tests {
+		unlocking := NewScriptBuilder().AddData(test.signature).Script()
+		if err := VerifyScript(unlocking, test.locking, tx, 0); (err == nil) != test.valid {
+			t.Errorf("%s: 直接校验的结果错误: %v", test.name, err)
+		}
+		batch := SchnorrBatch{}
+		err := VerifyScriptWithBatch(unlocking, test.locking, tx, 0, &batch)
+		if (err == nil && batch.Verify()) != test.valid {
+			t.Errorf("%s: 批量校验的结果错误: %v", test.name, err)
+		}
+	}
+}
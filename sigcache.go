@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"github.com/ShersBlockChain/bolt"
+	"sync"
+)
+
+//签名缓存，保存已经校验通过的(公钥, 签名数据, 签名)
+//交易加入交易池时校验过的签名写入数据库，区块到来时命中的签名不再重复校验
+//签名数据由交易内容和子脚本决定，所以缓存命中就说明同样的签名在同样的上下文中有效
+const sigCacheBucket = "sigCacheBucket"
+
+//缓存的最大条目数，超过时清空，避免数据库无限增长
+const maxSigCacheSize = 100000
+
+//多个校验线程共享，所有方法都是并发安全的
+type SigCache struct {
+	lock    sync.Mutex
+	entries map[string]bool
+	//新加入的条目，Save时写入数据库
+	added map[string]bool
+	//命中的条目，区块写入后从数据库删除
+	used map[string]bool
+}
+
+func NewSigCache() *SigCache {
+	return &SigCache{entries: make(map[string]bool), added: make(map[string]bool), used: make(map[string]bool)}
+}
+
+func sigCacheKey(pubKey, hash, signature []byte) string {
+	key := sha256.New()
+	key.Write(pubKey)
+	key.Write(hash)
+	key.Write(signature)
+	return string(key.Sum(nil))
+}
+
+func (cache *SigCache) Contains(pubKey, hash, signature []byte) bool {
+	key := sigCacheKey(pubKey, hash, signature)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if !cache.entries[key] {
+		return false
+	}
+	cache.used[key] = true
+	return true
+}
+
+func (cache *SigCache) Add(pubKey, hash, signature []byte) {
+	key := sigCacheKey(pubKey, hash, signature)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if !cache.entries[key] {
+		cache.entries[key] = true
+		cache.added[key] = true
+	}
+}
+
+func (cache *SigCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return len(cache.entries)
+}
+
+//从数据库加载签名缓存
+func (bc *BlockChain) LoadSigCache() *SigCache {
+	cache := NewSigCache()
+	bc.db.View(func(boltTx *bolt.Tx) error {
+		bucket := boltTx.Bucket([]byte(sigCacheBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			cache.entries[string(k)] = true
+			return nil
+		})
+	})
+	return cache
+}
+
+//把新加入的条目写入数据库
+func (cache *SigCache) Save(boltTx *bolt.Tx) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if len(cache.added) == 0 {
+		return nil
+	}
+	bucket, err := boltTx.CreateBucketIfNotExists([]byte(sigCacheBucket))
+	if err != nil {
+		return err
+	}
+	if bucket.Stats().KeyN+len(cache.added) > maxSigCacheSize {
+		if err := boltTx.DeleteBucket([]byte(sigCacheBucket)); err != nil {
+			return err
+		}
+		if bucket, err = boltTx.CreateBucket([]byte(sigCacheBucket)); err != nil {
+			return err
+		}
+	}
+	for key := range cache.added {
+		if err := bucket.Put([]byte(key), []byte{1}); err != nil {
+			return err
+		}
+	}
+	cache.added = make(map[string]bool)
+	return nil
+}
+
+//区块写入后，已经打包的签名不会再被校验，从数据库删除
+func (cache *SigCache) RemoveUsed(boltTx *bolt.Tx) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	bucket := boltTx.Bucket([]byte(sigCacheBucket))
+	if bucket == nil {
+		return
+	}
+	for key := range cache.used {
+		bucket.Delete([]byte(key))
+	}
+}
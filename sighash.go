@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//签名类型，附加在签名的最后一个字节，决定签名覆盖交易的哪些部分
+//ALL: 所有input和所有output（默认）
+//NONE: 所有input，不覆盖output，任何人都可以修改收款方
+//SINGLE: 所有input以及与当前input索引相同的那个output
+//ANYONECANPAY: 可以与上面三种组合，只覆盖当前input，其他人可以继续添加input（例如众筹）
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyOneCanPay SigHashType = 0x80
+)
+
+//去掉ANYONECANPAY之后的基本类型
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyOneCanPay
+}
+
+func (t SigHashType) IsValid() bool {
+	return t.base() >= SigHashAll && t.base() <= SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("未知(0x%02x)", byte(t))
+	}
+	if t&SigHashAnyOneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+//由名字得到签名类型，格式为 ALL、NONE、SINGLE，可以加上 |ANYONECANPAY
+func ParseSigHashType(name string) (SigHashType, error) {
+	parts := strings.Split(strings.ToUpper(name), "|")
+	var t SigHashType
+	switch parts[0] {
+	case "ALL":
+		t = SigHashAll
+	case "NONE":
+		t = SigHashNone
+	case "SINGLE":
+		t = SigHashSingle
+	default:
+		return 0, fmt.Errorf("未知的签名类型: %s", name)
+	}
+	if len(parts) == 2 && parts[1] == "ANYONECANPAY" {
+		t |= SigHashAnyOneCanPay
+	} else if len(parts) != 1 {
+		return 0, fmt.Errorf("未知的签名类型: %s", name)
+	}
+	return t, nil
+}
+
+//拆分签名，返回去掉类型字节的签名以及签名类型
+func SplitSignature(signature []byte) ([]byte, SigHashType, error) {
+	if len(signature) != signatureSize+1 {
+		return nil, 0, errors.New("签名长度错误")
+	}
+	hashType := SigHashType(signature[signatureSize])
+	if !hashType.IsValid() {
+		return nil, 0, fmt.Errorf("未知的签名类型: 0x%02x", byte(hashType))
+	}
+	return signature[:signatureSize], hashType, nil
+}
+
+//按照签名类型生成第i个input要签名的数据
+//从交易副本中去掉签名不覆盖的部分，再与签名类型一起做hash，不同类型的签名不能互相替换
+func (tx *Transaction) SignatureHashForType(i int, subScript []byte, hashType SigHashType) ([]byte, error) {
+	if i < 0 || i >= len(tx.TXInputs) {
+		return nil, errors.New("input索引无效")
+	}
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("未知的签名类型: 0x%02x", byte(hashType))
+	}
+	txCopy := tx.TrimmedCopy()
+	//交易id会随着input、output的增减而改变，不参与签名
+	txCopy.TXID = nil
+	txCopy.TXInputs[i].ScriptSig = subScript
+
+	switch hashType.base() {
+	case SigHashNone:
+		//不覆盖output，其他input的序列号也可以修改
+		txCopy.TXOutputs = nil
+		for j := range txCopy.TXInputs {
+			if j != i {
+				txCopy.TXInputs[j].Sequence = 0
+			}
+		}
+	case SigHashSingle:
+		//只覆盖索引相同的output，前面的output只占位
+		if i >= len(txCopy.TXOutputs) {
+			return nil, fmt.Errorf("SINGLE签名的第%d个input没有对应的output", i)
+		}
+		outputs := make([]TXOutput, i+1)
+		for j := 0; j < i; j++ {
+			outputs[j] = TXOutput{-1, nil, nil}
+		}
+		outputs[i] = txCopy.TXOutputs[i]
+		txCopy.TXOutputs = outputs
+		for j := range txCopy.TXInputs {
+			if j != i {
+				txCopy.TXInputs[j].Sequence = 0
+			}
+		}
+	}
+	if hashType&SigHashAnyOneCanPay != 0 {
+		txCopy.TXInputs = txCopy.TXInputs[i : i+1]
+	}
+
+	//子脚本放在ScriptSig中，所以要对完整的副本做hash，而不是使用不含见证数据的交易id
+	txHash := sha256.Sum256(txCopy.HashData())
+	hash := sha256.Sum256(append(txHash[:], byte(hashType)))
+	return hash[:], nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+//ECDSA签名
+//1.随机数k按照RFC 6979由私钥和签名数据确定性地生成，同一笔交易每次签名的结果都相同
+//2.s规范化为低值（s <= N/2），s和N-s只接受前者，签名无法在不改变内容的情况下被修改
+//3.签名固定为64字节: r、s各32字节，不足的补前导0
+
+//签名的字节数
+const signatureSize = 64
+
+//把整数编码为固定长度的字节
+func intToFixedBytes(x *big.Int, size int) []byte {
+	buf := make([]byte, size)
+	x.FillBytes(buf)
+	return buf
+}
+
+//RFC 6979 2.3.2 bits2int: 取前qlen位转换为整数
+func bits2int(data []byte, qlen int) *big.Int {
+	v := new(big.Int).SetBytes(data)
+	if len(data)*8 > qlen {
+		v.Rsh(v, uint(len(data)*8-qlen))
+	}
+	return v
+}
+
+//RFC 6979 2.3.4 bits2octets
+func bits2octets(data []byte, n *big.Int) []byte {
+	z := bits2int(data, n.BitLen())
+	if z.Cmp(n) >= 0 {
+		z.Sub(z, n)
+	}
+	return intToFixedBytes(z, (n.BitLen()+7)/8)
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+//RFC 6979 3.2 生成确定性的随机数，每次调用next返回下一个候选的k
+func nonceRFC6979(n, d *big.Int, hash []byte) func() *big.Int {
+	qlen := n.BitLen()
+	rolen := (qlen + 7) / 8
+	x := intToFixedBytes(d, rolen)
+	h := bits2octets(hash, n)
+
+	V := make([]byte, sha256.Size)
+	for i := range V {
+		V[i] = 0x01
+	}
+	K := make([]byte, sha256.Size)
+	K = hmacSHA256(K, V, []byte{0x00}, x, h)
+	V = hmacSHA256(K, V)
+	K = hmacSHA256(K, V, []byte{0x01}, x, h)
+	V = hmacSHA256(K, V)
+
+	first := true
+	return func() *big.Int {
+		for {
+			if !first {
+				K = hmacSHA256(K, V, []byte{0x00})
+				V = hmacSHA256(K, V)
+			}
+			first = false
+			var T []byte
+			for len(T) < rolen {
+				V = hmacSHA256(K, V)
+				T = append(T, V...)
+			}
+			k := bits2int(T[:rolen], qlen)
+			if k.Sign() > 0 && k.Cmp(n) < 0 {
+				return k
+			}
+		}
+	}
+}
+
+//s是否为低值
+func isLowS(curve elliptic.Curve, s *big.Int) bool {
+	halfOrder := new(big.Int).Rsh(curve.Params().N, 1)
+	return s.Cmp(halfOrder) <= 0
+}
+
+//对hash做确定性签名，返回64字节的r||s
+func SignHash(privateKey *ecdsa.PrivateKey, hash []byte) []byte {
+	curve := privateKey.Curve
+	n := curve.Params().N
+	e := bits2int(hash, n.BitLen())
+	nextK := nonceRFC6979(n, privateKey.D, hash)
+	for {
+		k := nextK()
+		//r = (kG).x mod N
+		x, _ := curve.ScalarBaseMult(intToFixedBytes(k, (n.BitLen()+7)/8))
+		r := new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		//s = k^-1 * (e + r*d) mod N
+		s := new(big.Int).Mul(r, privateKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+		if !isLowS(curve, s) {
+			s.Sub(n, s)
+		}
+		return append(intToFixedBytes(r, signatureSize/2), intToFixedBytes(s, signatureSize/2)...)
+	}
+}
+
+//解析64字节的签名，得到r，s
+func ParseSignature(signature []byte) (*big.Int, *big.Int, error) {
+	if len(signature) != signatureSize {
+		return nil, nil, errors.New("签名长度错误")
+	}
+	r := new(big.Int).SetBytes(signature[:signatureSize/2])
+	s := new(big.Int).SetBytes(signature[signatureSize/2:])
+	return r, s, nil
+}
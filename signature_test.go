@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func hexToInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+	x, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		t.Fatalf("16进制整数格式错误: %s", s)
+	}
+	return x
+}
+
+//RFC 6979确定性随机数和签名的测试向量
+//P256来自RFC 6979 A.2.5（SHA-256，消息"sample"），RFC中的s是高值，这里规范化为N-s
+//secp256k1是常用的测试向量：私钥为1，消息"Satoshi Nakamoto"
+func TestSignHashRFC6979(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType KeyType
+		d       string
+		msg     string
+		k       string
+		r       string
+		s       string
+	}{
+		{"P256", KeyTypeP256,
+			"C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", "sample",
+			"A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8"},
+		{"secp256k1", KeyTypeSecp256k1,
+			"1", "Satoshi Nakamoto",
+			"8F8A276C19F4149656B280621E358CCE24F5F52542772691EE69063B74F15D15",
+			"934B1EA10A4B3C1757E2B0C017D0B6143CE3C9A7E6A4A49860D7A6AB210EE3D8",
+			"2442CE9D2B916064108014783E923EC36B49743E2FFA1C4496F01A512AAFD9E5"},
+	}
+	for _, test := range tests {
+		privateKey := PrivateKeyFromBytes(test.keyType, hexToInt(t, test.d).Bytes())
+		n := privateKey.Curve.Params().N
+		hash := sha256.Sum256([]byte(test.msg))
+		if k := nonceRFC6979(n, privateKey.D, hash[:])(); k.Cmp(hexToInt(t, test.k)) != 0 {
+			t.Errorf("%s: k = %X", test.name, k)
+		}
+		s := hexToInt(t, test.s)
+		if !isLowS(privateKey.Curve, s) {
+			s.Sub(n, s)
+		}
+		expected := append(intToFixedBytes(hexToInt(t, test.r), 32), intToFixedBytes(s, 32)...)
+		signature := SignHash(privateKey, hash[:])
+		if !bytes.Equal(signature, expected) {
+			t.Errorf("%s: 签名 = %x", test.name, signature)
+		}
+		//同一个hash重复签名结果相同
+		if !bytes.Equal(SignHash(privateKey, hash[:]), signature) {
+			t.Errorf("%s: 签名不是确定性的", test.name)
+		}
+		pubKey := SerializePubKey(test.keyType, &privateKey.PublicKey)
+		if !VerifySignature(pubKey, hash[:], signature) {
+			t.Errorf("%s: 签名校验失败", test.name)
+		}
+	}
+}
+
+//签名固定为64字节，r、s不足32字节时补前导0，解析后得到相同的r、s
+func TestSignatureEncoding(t *testing.T) {
+	r, s := big.NewInt(1), hexToInt(t, "00FF00000000000000000000000000000000000000000000000000000000AB")
+	signature := append(intToFixedBytes(r, signatureSize/2), intToFixedBytes(s, signatureSize/2)...)
+	if len(signature) != signatureSize {
+		t.Fatalf("签名长度%d", len(signature))
+	}
+	if hex.EncodeToString(signature[:signatureSize/2]) != "0000000000000000000000000000000000000000000000000000000000000001" {
+		t.Fatalf("r编码错误: %x", signature[:signatureSize/2])
+	}
+	parsedR, parsedS, err := ParseSignature(signature)
+	if err != nil || parsedR.Cmp(r) != 0 || parsedS.Cmp(s) != 0 {
+		t.Fatalf("解析结果错误: %v %v %v", parsedR, parsedS, err)
+	}
+	for _, size := range []int{0, signatureSize - 1, signatureSize + 1} {
+		if _, _, err := ParseSignature(make([]byte, size)); err == nil {
+			t.Errorf("%d字节的签名应该解析失败", size)
+		}
+	}
+}
+
+//N-s与s都是数学上有效的签名，只接受低s值，签名无法在不改变内容的情况下被修改
+func TestVerifyRejectsHighS(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeP256, KeyTypeSecp256k1} {
+		wallet := NewWallet(keyType)
+		hash := sha256.Sum256([]byte("high s"))
+		signature := SignHash(wallet.Private, hash[:])
+		if !VerifySignature(wallet.PubKey, hash[:], signature) {
+			t.Fatalf("%s: 低s值的签名校验失败", keyType)
+		}
+		r, s, _ := ParseSignature(signature)
+		highS := new(big.Int).Sub(wallet.Private.Curve.Params().N, s)
+		malleated := append(intToFixedBytes(r, signatureSize/2), intToFixedBytes(highS, signatureSize/2)...)
+		if VerifySignature(wallet.PubKey, hash[:], malleated) {
+			t.Errorf("%s: 高s值的签名应该校验失败", keyType)
+		}
+	}
+}
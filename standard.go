@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+//标准脚本模板
+
+//HTLC秘密值的字节数
+const htlcSecretSize = 32
+
+//数据output最多携带的字节数
+const maxDataCarrierSize = 80
+
+//P2PKH锁定脚本: OP_DUP OP_HASH160 <公钥hash> OP_EQUALVERIFY OP_CHECKSIG
+func NewP2PKHScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+//P2PKH解锁脚本: <签名> <公钥>
+func NewP2PKHUnlockingScript(signature, pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+//判断是否为P2PKH锁定脚本，是的话返回公钥hash
+func ExtractP2PKHPubKeyHash(script []byte) ([]byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil, false
+	}
+	if ops[0].Opcode != OP_DUP || ops[1].Opcode != OP_HASH160 || len(ops[2].Data) != 20 ||
+		ops[3].Opcode != OP_EQUALVERIFY || ops[4].Opcode != OP_CHECKSIG {
+		return nil, false
+	}
+	//确认是最短编码，避免同一个模板有多种写法
+	if !bytes.Equal(script, NewP2PKHScript(ops[2].Data)) {
+		return nil, false
+	}
+	return ops[2].Data, true
+}
+
+//M-of-N多重签名锁定脚本: M <公钥1> ... <公钥N> N OP_CHECKMULTISIG
+func NewMultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > 16 {
+		return nil, errors.New("多重签名的公钥个数必须在1到16之间")
+	}
+	if m < 1 || m > n {
+		return nil, errors.New("多重签名的签名个数必须在1到公钥个数之间")
+	}
+	builder := NewScriptBuilder().AddInt64(int64(m))
+	for _, pubKey := range pubKeys {
+		if len(pubKey) == 0 {
+			return nil, errors.New("多重签名的公钥不能为空")
+		}
+		builder.AddData(pubKey)
+	}
+	return builder.AddInt64(int64(n)).AddOp(OP_CHECKMULTISIG).Script(), nil
+}
+
+//判断是否为多重签名锁定脚本，是的话返回需要的签名个数和公钥
+func ExtractMultiSig(script []byte) (int, [][]byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].Opcode != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+	first, last := ops[0].Opcode, ops[len(ops)-2].Opcode
+	if first < OP_1 || first > OP_16 || last < OP_1 || last > OP_16 {
+		return 0, nil, false
+	}
+	m := int(first - OP_1 + 1)
+	n := int(last - OP_1 + 1)
+	if n != len(ops)-3 || m > n {
+		return 0, nil, false
+	}
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if len(op.Data) == 0 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.Data)
+	}
+	return m, pubKeys, true
+}
+
+//P2SH锁定脚本: OP_HASH160 <脚本hash> OP_EQUAL
+//花费时解锁脚本的最后一项是赎回脚本，赎回脚本的hash匹配后再执行赎回脚本
+func NewP2SHScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+//判断是否为P2SH锁定脚本，是的话返回脚本hash
+func ExtractP2SHScriptHash(script []byte) ([]byte, bool) {
+	if len(script) != 23 || script[0] != OP_HASH160 || script[1] != 20 || script[22] != OP_EQUAL {
+		return nil, false
+	}
+	return script[2:22], true
+}
+
+//时间锁P2PKH脚本: <锁定时间> OP_CHECKLOCKTIMEVERIFY OP_DROP <P2PKH脚本>
+//op为OP_CHECKSEQUENCEVERIFY时是相对时间锁，lockTime为序列号
+//通常作为P2SH的赎回脚本使用，例如团队份额的锁仓
+func NewTimeLockScript(op byte, lockTime int64, pubKeyHash []byte) []byte {
+	builder := NewScriptBuilder().AddInt64(lockTime).AddOp(op).AddOp(OP_DROP)
+	return append(builder.Script(), NewP2PKHScript(pubKeyHash)...)
+}
+
+//判断是否为时间锁P2PKH脚本，是的话返回时间锁操作码、锁定时间和公钥hash
+func ExtractTimeLock(script []byte) (byte, int64, []byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) != 8 || len(script) < 25 || ops[2].Opcode != OP_DROP {
+		return 0, 0, nil, false
+	}
+	op := ops[1].Opcode
+	if op != OP_CHECKLOCKTIMEVERIFY && op != OP_CHECKSEQUENCEVERIFY {
+		return 0, 0, nil, false
+	}
+	lockTime, err := pushedNum(ops[0])
+	if err != nil || lockTime < 0 {
+		return 0, 0, nil, false
+	}
+	pubKeyHash, ok := ExtractP2PKHPubKeyHash(script[len(script)-25:])
+	//确认是最短编码
+	if !ok || !bytes.Equal(script, NewTimeLockScript(op, lockTime, pubKeyHash)) {
+		return 0, 0, nil, false
+	}
+	return op, lockTime, pubKeyHash, true
+}
+
+//返回push指令压入的数字（OP_1到OP_16或者数据），用于解析时间锁
+func pushedNum(op ScriptOp) (int64, error) {
+	if op.Opcode >= OP_1 && op.Opcode <= OP_16 {
+		return int64(op.Opcode - OP_1 + 1), nil
+	}
+	if !op.IsPush() || op.Opcode == OP_1NEGATE {
+		return 0, errors.New("不是push数字的指令")
+	}
+	return DecodeScriptNum(op.Data, maxLockTimeNumLen)
+}
+
+//哈希时间锁合约（HTLC）
+type HTLC struct {
+	//秘密值的sha256
+	SecretHash []byte
+	//提供秘密值即可花费的收款方公钥hash
+	RecipientHash []byte
+	//超时后可以取回的付款方公钥hash
+	RefundHash []byte
+	//超时时间，区块高度或unix时间
+	LockTime int64
+}
+
+//HTLC锁定脚本:
+//OP_IF
+//	OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <秘密值hash> OP_EQUALVERIFY OP_DUP OP_HASH160 <收款方公钥hash>
+//OP_ELSE
+//	<超时时间> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <付款方公钥hash>
+//OP_ENDIF
+//OP_EQUALVERIFY OP_CHECKSIG
+//限制秘密值的长度，避免两条链对秘密值长度的限制不同导致只能在一条链上兑换
+func NewHTLCScript(htlc *HTLC) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).AddInt64(htlcSecretSize).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).AddData(htlc.SecretHash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(htlc.RecipientHash).
+		AddOp(OP_ELSE).
+		AddInt64(htlc.LockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(htlc.RefundHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+}
+
+//判断是否为HTLC脚本，是的话返回合约内容
+func ExtractHTLC(script []byte) (*HTLC, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) != 20 {
+		return nil, false
+	}
+	if len(ops[5].Data) != sha256.Size || len(ops[9].Data) != 20 || len(ops[16].Data) != 20 {
+		return nil, false
+	}
+	lockTime, err := pushedNum(ops[11])
+	if err != nil || lockTime < 0 {
+		return nil, false
+	}
+	htlc := HTLC{ops[5].Data, ops[9].Data, ops[16].Data, lockTime}
+	//确认与模板完全一致
+	if !bytes.Equal(script, NewHTLCScript(&htlc)) {
+		return nil, false
+	}
+	return &htlc, true
+}
+
+//数据锁定脚本: OP_RETURN <数据>
+//执行到OP_RETURN就失败，所以output可以被证明不可花费，不会进入utxo集合
+func NewDataScript(data []byte) ([]byte, error) {
+	if len(data) > maxDataCarrierSize {
+		return nil, fmt.Errorf("数据不能超过%d字节", maxDataCarrierSize)
+	}
+	return NewScriptBuilder().AddOp(OP_RETURN).AddData(data).Script(), nil
+}
+
+//判断是否为数据脚本，是的话返回携带的数据
+func ExtractData(script []byte) ([]byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || len(ops) == 0 || len(ops) > 2 || ops[0].Opcode != OP_RETURN {
+		return nil, false
+	}
+	if len(ops) == 1 {
+		return []byte{}, true
+	}
+	if !ops[1].IsPush() {
+		return nil, false
+	}
+	return ops[1].Data, true
+}
+
+//以OP_RETURN开头的脚本一定不能花费
+func IsUnspendableScript(script []byte) bool {
+	return len(script) > 0 && script[0] == OP_RETURN
+}
+
+//output中用于按地址查找的hash（TXOutput.PubKeyHash）
+//P2PKH是公钥hash，P2SH是脚本hash，裸多重签名使用脚本的hash，这样可以用对应的P2SH地址查询到它
+func ScriptIndexHash(script []byte) []byte {
+	if pubKeyHash, ok := ExtractP2PKHPubKeyHash(script); ok {
+		return pubKeyHash
+	}
+	if scriptHash, ok := ExtractP2SHScriptHash(script); ok {
+		return scriptHash
+	}
+	if _, _, ok := ExtractMultiSig(script); ok {
+		return hash160(script)
+	}
+	return nil
+}
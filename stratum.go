@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//stratum挖矿协议（v1），外部挖矿程序通过TCP连接节点挖矿
+//1.每行一条JSON-RPC消息：请求{"id","method","params"}，响应{"id","result","error"}，通知的id为null
+//2.mining.subscribe返回[订阅, extranonce1, extranonce2的字节数]，每个连接的extranonce1不同
+//3.mining.authorize [矿工名称, 密码]登记矿工，提交份额时使用
+//4.服务器通过mining.set_difficulty设置份额难度，通过mining.notify下发任务：
+//  [任务id, 前区块hash, coinb1, coinb2, 默克尔路径, 版本号, 难度值, 时间戳, 是否清除旧任务, 区块高度]
+//  这条链的区块头包含区块高度（见pow.go），所以在标准参数之后增加了区块高度
+//5.矿机拼接 coinb1 + extranonce1 + extranonce2 + coinb2 得到挖矿交易（不包含交易id），sha256得到交易id，
+//  沿着默克尔路径计算默克尔根，再拼装区块头计算hash
+//6.mining.submit [矿工名称, 任务id, extranonce2, 时间戳, 随机数]，hash小于份额目标值时接受份额，
+//  小于区块目标值时组装区块写入区块链，然后下发新的任务
+//难度1对应区块的目标值（见PowTarget），份额的目标值 = 区块目标值 / 份额难度，所以份额难度小于1
+
+//extranonce1和extranonce2的字节数，加起来就是挖矿交易中随机数的长度（见NewCoinbaseTX）
+const extraNonce1Size = 4
+const extraNonce2Size = 4
+
+//默认的份额难度，平均每16个份额找到一个区块
+const defaultShareDifficulty = 1.0 / 16
+
+//没有新区块时，每隔这么长时间使用交易池中的新交易更新任务
+const stratumJobInterval = 30 * time.Second
+
+//份额的时间戳最多比当前时间晚多少秒
+const maxFutureBlockTime = 2 * 60 * 60
+
+//保留的任务数，矿机提交旧任务（同一个区块高度）的份额时仍然接受
+const maxStratumJobs = 4
+
+//stratum错误码
+const (
+	stratumErrOther          = 20
+	stratumErrJobNotFound    = 21
+	stratumErrDuplicateShare = 22
+	stratumErrLowDifficulty  = 23
+	stratumErrUnauthorized   = 24
+	stratumErrNotSubscribed  = 25
+)
+
+type stratumError struct {
+	Code    int
+	Message string
+}
+
+func (err *stratumError) Error() string {
+	return fmt.Sprintf("%d: %s", err.Code, err.Message)
+}
+
+//发送的请求和通知
+type stratumRequest struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+//发送的响应
+type stratumResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+//接收的消息，可能是请求、响应或者通知
+type stratumMessage struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+//把参数解析成字符串，null解析为空字符串
+func (msg *stratumMessage) stringParams() ([]string, error) {
+	var raw []interface{}
+	if err := json.Unmarshal(msg.Params, &raw); err != nil {
+		return nil, errors.New("参数格式错误")
+	}
+	var params []string
+	for _, param := range raw {
+		switch value := param.(type) {
+		case string:
+			params = append(params, value)
+		case nil:
+			params = append(params, "")
+		default:
+			params = append(params, fmt.Sprint(value))
+		}
+	}
+	return params, nil
+}
+
+//矿机和服务器计算区块头需要的数据
+type stratumWork struct {
+	PrevHash     []byte
+	Coinbase1    []byte
+	Coinbase2    []byte
+	MerkleBranch [][]byte
+	Version      uint64
+	Difficulty   uint64
+	TimeStamp    uint64
+	Height       uint64
+}
+
+//使用extranonce计算默克尔根，挖矿交易是默克尔树的第一个叶子，所以兄弟节点都在右边
+func (work *stratumWork) merkleRoot(extraNonce []byte) []byte {
+	hash := sha256.Sum256(bytes.Join([][]byte{work.Coinbase1, extraNonce, work.Coinbase2}, nil))
+	root := hash[:]
+	for _, sibling := range work.MerkleBranch {
+		root = merkleParent(root, sibling)
+	}
+	return root
+}
+
+//只有区块头的区块，用于计算hash
+func (work *stratumWork) header(merkleRoot []byte, timeStamp uint64) *Block {
+	return &Block{work.Version, work.PrevHash, merkleRoot, timeStamp, work.Difficulty, 0, nil, work.Height, nil}
+}
+
+//mining.notify的参数
+func (work *stratumWork) notifyParams(jobID string, clean bool) []interface{} {
+	var branch []string
+	for _, hash := range work.MerkleBranch {
+		branch = append(branch, hex.EncodeToString(hash))
+	}
+	return []interface{}{jobID, hex.EncodeToString(work.PrevHash), hex.EncodeToString(work.Coinbase1),
+		hex.EncodeToString(work.Coinbase2), branch, fmt.Sprintf("%016x", work.Version),
+		fmt.Sprintf("%016x", work.Difficulty), fmt.Sprintf("%016x", work.TimeStamp), clean,
+		fmt.Sprintf("%016x", work.Height)}
+}
+
+//hash作为整数与目标值比较
+func hashBelowTarget(hash []byte, target *big.Int) bool {
+	tmpInt := big.Int{}
+	tmpInt.SetBytes(hash)
+	return tmpInt.Cmp(target) == -1
+}
+
+//份额难度对应的目标值
+func shareTarget(difficulty float64) *big.Int {
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(PowTarget()), big.NewFloat(difficulty)).Int(nil)
+	return target
+}
+
+//服务器下发的任务
+type stratumJob struct {
+	ID string
+	stratumWork
+	//挖矿交易，随机数由矿机的extranonce代替
+	coinbase *Transaction
+	//除挖矿交易之外的交易
+	txs []*Transaction
+	//已经提交的份额，防止重复提交
+	shares map[string]bool
+}
+
+//使用区块模板创建任务，挖矿交易付给miner，金额包括模板中交易的手续费
+func newStratumJob(id string, template *BlockTemplate, prevHash []byte, miner string) (*stratumJob, error) {
+	txs := template.Transactions()
+	coinbase := template.NewCoinbase(miner, "")
+	//挖矿交易的随机数就是extranonce，在它的位置把计算交易id的字节流拆开（挖矿交易的txid包含所有字段）
+	placeholder := coinbase.TXInputs[0].PubKey
+	if len(placeholder) != extraNonce1Size+extraNonce2Size {
+		return nil, errors.New("挖矿交易的随机数长度与extranonce不一致")
+	}
+	//见证承诺不依赖挖矿交易的随机数，先加入挖矿交易
+	block := Block{Transactions: append([]*Transaction{coinbase}, txs...)}
+	block.AddWitnessCommitment()
+	data := coinbase.HashData()
+	if bytes.Count(data, placeholder) != 1 {
+		return nil, errors.New("无法拆分挖矿交易")
+	}
+	index := bytes.Index(data, placeholder)
+
+	leaves := [][]byte{coinbase.TXID}
+	for _, tx := range txs {
+		leaves = append(leaves, tx.TXID)
+	}
+	var branch [][]byte
+	for _, step := range MerkleProof(leaves, 0) {
+		branch = append(branch, step.Hash)
+	}
+	work := stratumWork{prevHash, data[:index], data[index+len(placeholder):], branch, 0, 0, uint64(time.Now().Unix()), template.Height}
+	return &stratumJob{id, work, coinbase, txs, make(map[string]bool)}, nil
+}
+
+//使用矿机找到的extranonce、时间戳和随机数组装完整的区块
+func (job *stratumJob) buildBlock(extraNonce []byte, timeStamp, nonce uint64) (*Block, error) {
+	if len(extraNonce) != len(job.coinbase.TXInputs[0].PubKey) {
+		return nil, errors.New("extranonce长度错误")
+	}
+	coinbase := *job.coinbase
+	coinbase.TXInputs = []TXInput{coinbase.TXInputs[0]}
+	coinbase.TXInputs[0].PubKey = extraNonce
+	coinbase.SetHash()
+	block := job.header(job.merkleRoot(extraNonce), timeStamp)
+	block.Nonce = nonce
+	block.Transactions = append([]*Transaction{&coinbase}, job.txs...)
+	hash := sha256.Sum256(NewProofOfWork(block).prepareData(nonce))
+	block.Hash = hash[:]
+	return block, nil
+}
+
+//一个矿机连接
+type stratumConn struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	//为空表示还没有订阅
+	extraNonce1 []byte
+	//已经登记的矿工名称
+	workers map[string]bool
+}
+
+func (client *stratumConn) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	client.conn.Write(append(data, '\n'))
+}
+
+type StratumServer struct {
+	bc *BlockChain
+	//挖矿交易的收款地址
+	miner           string
+	shareDifficulty float64
+	shareTarget     *big.Int
+	//保护下面的字段以及区块链的修改
+	lock sync.Mutex
+	//最新的任务在最后
+	jobs            []*stratumJob
+	nextJobID       uint64
+	nextExtraNonce1 uint32
+	clients         map[*stratumConn]bool
+	//矿池模式（见pool.go），为空时所有奖励都付给miner
+	pool *Pool
+}
+
+//pool不为空时是矿池模式，miner是矿池地址
+func NewStratumServer(bc *BlockChain, miner string, shareDifficulty float64, pool *Pool) *StratumServer {
+	return &StratumServer{
+		bc:              bc,
+		miner:           miner,
+		shareDifficulty: shareDifficulty,
+		shareTarget:     shareTarget(shareDifficulty),
+		clients:         make(map[*stratumConn]bool),
+		pool:            pool,
+	}
+}
+
+//监听address，接受矿机连接，一直运行直到出错
+func (server *StratumServer) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	server.lock.Lock()
+	//上次运行时已经成熟的区块先支付
+	server.processPayouts()
+	err = server.newJob(true)
+	server.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("stratum服务器已启动: %s 份额难度: %g\n", listener.Addr(), server.shareDifficulty)
+	//定期使用交易池中的新交易更新任务
+	go func() {
+		for range time.Tick(stratumJobInterval) {
+			server.lock.Lock()
+			if err := server.newJob(false); err != nil {
+				fmt.Printf("更新任务失败: %s\n", err)
+			}
+			server.lock.Unlock()
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handleConn(conn)
+	}
+}
+
+//创建新的任务并通知所有矿机，clean为true时旧的任务作废（有了新区块），调用方持有lock
+func (server *StratumServer) newJob(clean bool) error {
+	template, err := server.bc.NewBlockTemplate(0)
+	if err != nil {
+		return err
+	}
+	server.nextJobID++
+	job, err := newStratumJob(strconv.FormatUint(server.nextJobID, 16), template, server.bc.tail, server.miner)
+	if err != nil {
+		return err
+	}
+	if clean {
+		server.jobs = nil
+	}
+	server.jobs = append(server.jobs, job)
+	if len(server.jobs) > maxStratumJobs {
+		server.jobs = server.jobs[len(server.jobs)-maxStratumJobs:]
+	}
+	for client := range server.clients {
+		if client.extraNonce1 != nil {
+			client.send(stratumRequest{nil, "mining.notify", job.notifyParams(job.ID, clean)})
+		}
+	}
+	return nil
+}
+
+//矿池模式下支付已经成熟的区块，支付交易在下一个任务中打包，调用方持有lock
+func (server *StratumServer) processPayouts() {
+	if server.pool == nil {
+		return
+	}
+	if err := server.pool.ProcessPayouts(); err != nil {
+		fmt.Printf("支付失败: %s\n", err)
+	}
+}
+
+func (server *StratumServer) findJob(id string) *stratumJob {
+	for _, job := range server.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+//处理一个矿机连接的所有请求
+func (server *StratumServer) handleConn(conn net.Conn) {
+	client := &stratumConn{conn: conn, workers: make(map[string]bool)}
+	server.lock.Lock()
+	server.clients[client] = true
+	server.lock.Unlock()
+	defer func() {
+		server.lock.Lock()
+		delete(server.clients, client)
+		server.lock.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg stratumMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return
+		}
+		result, err := server.handleRequest(client, &msg)
+		response := stratumResponse{msg.ID, result, nil}
+		if err != nil {
+			code, message := stratumErrOther, err.Error()
+			if stratumErr, ok := err.(*stratumError); ok {
+				code, message = stratumErr.Code, stratumErr.Message
+			}
+			response = stratumResponse{msg.ID, nil, []interface{}{code, message, nil}}
+		}
+		client.send(response)
+		//订阅之后立即下发份额难度和当前的任务
+		if msg.Method == "mining.subscribe" && err == nil {
+			server.lock.Lock()
+			client.send(stratumRequest{nil, "mining.set_difficulty", []interface{}{server.shareDifficulty}})
+			job := server.jobs[len(server.jobs)-1]
+			client.send(stratumRequest{nil, "mining.notify", job.notifyParams(job.ID, true)})
+			server.lock.Unlock()
+		}
+	}
+}
+
+func (server *StratumServer) handleRequest(client *stratumConn, msg *stratumMessage) (interface{}, error) {
+	params, err := msg.stringParams()
+	if err != nil && msg.Method != "mining.subscribe" {
+		return nil, err
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	switch msg.Method {
+	case "mining.subscribe":
+		if client.extraNonce1 == nil {
+			server.nextExtraNonce1++
+			client.extraNonce1 = make([]byte, extraNonce1Size)
+			binary.BigEndian.PutUint32(client.extraNonce1, server.nextExtraNonce1)
+		}
+		id := hex.EncodeToString(client.extraNonce1)
+		subscriptions := [][]string{{"mining.set_difficulty", id}, {"mining.notify", id}}
+		return []interface{}{subscriptions, id, extraNonce2Size}, nil
+	case "mining.authorize":
+		if len(params) < 1 || params[0] == "" {
+			return nil, &stratumError{stratumErrUnauthorized, "矿工名称不能为空"}
+		}
+		//矿池模式下矿工名称是收款地址
+		if server.pool != nil {
+			if _, err := poolWorkerAddress(params[0]); err != nil {
+				return nil, &stratumError{stratumErrUnauthorized, err.Error()}
+			}
+		}
+		client.workers[params[0]] = true
+		return true, nil
+	case "mining.submit":
+		if len(params) != 5 {
+			return nil, &stratumError{stratumErrOther, "参数个数错误"}
+		}
+		if err := server.submitShare(client, params[0], params[1], params[2], params[3], params[4]); err != nil {
+			return nil, err
+		}
+		return true, nil
+	case "pool.stats":
+		if server.pool == nil {
+			return nil, &stratumError{stratumErrOther, "服务器不是矿池模式"}
+		}
+		return server.pool.Stats(server.shareDifficulty), nil
+	default:
+		return nil, &stratumError{stratumErrOther, "不支持的方法: " + msg.Method}
+	}
+}
+
+//校验矿机提交的份额，满足区块目标值时写入区块链，调用方持有lock
+func (server *StratumServer) submitShare(client *stratumConn, worker, jobID, extraNonce2Hex, timeHex, nonceHex string) error {
+	if client.extraNonce1 == nil {
+		return &stratumError{stratumErrNotSubscribed, "没有订阅"}
+	}
+	if !client.workers[worker] {
+		return &stratumError{stratumErrUnauthorized, "矿工没有登记: " + worker}
+	}
+	job := server.findJob(jobID)
+	if job == nil {
+		return &stratumError{stratumErrJobNotFound, "任务不存在或者已经过期"}
+	}
+	extraNonce2, err := hex.DecodeString(extraNonce2Hex)
+	if err != nil || len(extraNonce2) != extraNonce2Size {
+		return &stratumError{stratumErrOther, "extranonce2格式错误"}
+	}
+	timeStamp, err := strconv.ParseUint(timeHex, 16, 64)
+	if err != nil || timeStamp < job.TimeStamp || timeStamp > uint64(time.Now().Unix())+maxFutureBlockTime {
+		return &stratumError{stratumErrOther, "时间戳无效"}
+	}
+	nonce, err := strconv.ParseUint(nonceHex, 16, 64)
+	if err != nil {
+		return &stratumError{stratumErrOther, "随机数格式错误"}
+	}
+	extraNonce := append(append([]byte{}, client.extraNonce1...), extraNonce2...)
+	key := fmt.Sprintf("%x:%x:%x", extraNonce, timeStamp, nonce)
+	if job.shares[key] {
+		return &stratumError{stratumErrDuplicateShare, "重复的份额"}
+	}
+	header := job.header(job.merkleRoot(extraNonce), timeStamp)
+	hash := sha256.Sum256(NewProofOfWork(header).prepareData(nonce))
+	if !hashBelowTarget(hash[:], server.shareTarget) {
+		return &stratumError{stratumErrLowDifficulty, "份额难度不够"}
+	}
+	job.shares[key] = true
+	fmt.Printf("接受份额: 矿工: %s 任务: %s hash: %x\n", worker, jobID, hash)
+	if server.pool != nil {
+		if err := server.pool.RecordShare(worker, server.shareDifficulty); err != nil {
+			fmt.Printf("记录份额失败: %s\n", err)
+		}
+	}
+
+	if !hashBelowTarget(hash[:], PowTarget()) {
+		return nil
+	}
+	block, err := job.buildBlock(extraNonce, timeStamp, nonce)
+	if err != nil {
+		fmt.Printf("组装区块失败: %s\n", err)
+		return nil
+	}
+	if err := server.bc.SubmitBlock(block); err != nil {
+		fmt.Printf("提交区块失败: %s\n", err)
+		return nil
+	}
+	fmt.Printf("矿工%s找到区块: 高度: %d hash: %x 交易数: %d\n", worker, block.Height, block.Hash, len(block.Transactions))
+	if server.pool != nil {
+		if err := server.pool.RecordBlock(block); err != nil {
+			fmt.Printf("记录区块失败: %s\n", err)
+		}
+	}
+	server.processPayouts()
+	if err := server.newJob(true); err != nil {
+		fmt.Printf("更新任务失败: %s\n", err)
+	}
+	return nil
+}
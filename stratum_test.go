@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+//矿机使用Coinbase1、extranonce和Coinbase2计算的默克尔根与服务器组装的区块一致
+func TestStratumJobBuildBlock(t *testing.T) {
+	address := NewWallet(KeyTypeP256).NewAddress()
+	bc := newTestBlockChain(t, address)
+	template, err := bc.NewBlockTemplate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := newStratumJob("1", template, bc.tail, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extraNonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	block, err := job.buildBlock(extraNonce, job.TimeStamp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := block.Transactions[0]
+	if !bytes.Equal(coinbase.TXInputs[0].PubKey, extraNonce) || !coinbase.HasValidTXID() {
+		t.Fatalf("挖矿交易组装错误: %+v", coinbase.TXInputs[0])
+	}
+	if !bytes.Equal(block.MerkelRoot, job.merkleRoot(extraNonce)) || !bytes.Equal(block.MerkelRoot, block.MakeMerkelRoot()) {
+		t.Fatalf("默克尔根不一致: %x", block.MerkelRoot)
+	}
+	//组装区块不能修改任务中的挖矿交易
+	if bytes.Equal(job.coinbase.TXInputs[0].PubKey, extraNonce) {
+		t.Fatal("任务中的挖矿交易被修改")
+	}
+	if _, err := job.buildBlock(extraNonce[:4], job.TimeStamp, 0); err == nil {
+		t.Fatal("extranonce长度错误时应该失败")
+	}
+}
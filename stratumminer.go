@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//stratum测试矿机，用于在本地测试stratum服务器（见stratum.go）
+//1.订阅并登记矿工，接收份额难度和任务
+//2.对每个extranonce2遍历随机数，hash小于份额目标值时提交份额
+//3.有新任务时切换到新任务，找到指定个数的区块后退出
+//只使用一个线程，不需要区块链数据库
+
+//每计算这么多次hash检查一次是否有新任务
+const stratumMinerCheckInterval = 4096
+
+//矿机收到的任务
+type stratumMinerJob struct {
+	ID string
+	stratumWork
+}
+
+//解析mining.notify的参数，返回任务以及是否清除旧任务
+func parseStratumNotify(data json.RawMessage) (*stratumMinerJob, bool, error) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(data, &params); err != nil || len(params) < 10 {
+		return nil, false, errors.New("任务参数个数错误")
+	}
+	var id, prevHash, coinbase1, coinbase2, version, difficulty, timeStamp, height string
+	var branch []string
+	var clean bool
+	fields := []interface{}{&id, &prevHash, &coinbase1, &coinbase2, &branch, &version, &difficulty, &timeStamp, &clean, &height}
+	for i, field := range fields {
+		if err := json.Unmarshal(params[i], field); err != nil {
+			return nil, false, fmt.Errorf("第%d个参数格式错误", i+1)
+		}
+	}
+	var hashes [][]byte
+	for _, field := range append([]string{prevHash, coinbase1, coinbase2}, branch...) {
+		hash, err := hex.DecodeString(field)
+		if err != nil {
+			return nil, false, err
+		}
+		hashes = append(hashes, hash)
+	}
+	var numbers []uint64
+	for _, field := range []string{version, difficulty, timeStamp, height} {
+		number, err := strconv.ParseUint(field, 16, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		numbers = append(numbers, number)
+	}
+	work := stratumWork{hashes[0], hashes[1], hashes[2], hashes[3:], numbers[0], numbers[1], numbers[2], numbers[3]}
+	return &stratumMinerJob{id, work}, clean, nil
+}
+
+type stratumMiner struct {
+	conn   net.Conn
+	worker string
+	lock   sync.Mutex
+	//订阅之后才有
+	extraNonce1     []byte
+	extraNonce2Size int
+	shareTarget     *big.Int
+	job             *stratumMinerJob
+	//收到新任务时加1，挖矿循环据此切换任务
+	jobVersion uint64
+	//已经提交的请求id对应的份额是否满足区块目标值
+	pending map[uint64]bool
+	nextID  uint64
+	//服务器接受的份额和区块
+	accepted int
+	rejected int
+	blocks   int
+	//服务器断开连接时的错误
+	err error
+}
+
+func (miner *stratumMiner) send(id uint64, method string, params ...interface{}) {
+	data, _ := json.Marshal(stratumRequest{id, method, params})
+	miner.conn.Write(append(data, '\n'))
+}
+
+//提交份额，先记录请求id再发送，避免响应先到达
+func (miner *stratumMiner) submit(job *stratumMinerJob, extraNonce2 []byte, timeStamp, nonce uint64, isBlock bool) {
+	miner.lock.Lock()
+	miner.nextID++
+	id := miner.nextID
+	miner.pending[id] = isBlock
+	miner.lock.Unlock()
+	miner.send(id, "mining.submit", miner.worker, job.ID, hex.EncodeToString(extraNonce2),
+		fmt.Sprintf("%016x", timeStamp), fmt.Sprintf("%016x", nonce))
+}
+
+//处理服务器发送的响应和通知，直到连接断开
+func (miner *stratumMiner) readLoop() {
+	scanner := bufio.NewScanner(miner.conn)
+	scanner.Buffer(make([]byte, 64*1024), 4*maxBlockSize)
+	for scanner.Scan() {
+		var msg stratumMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		miner.handleMessage(&msg)
+	}
+	miner.lock.Lock()
+	miner.err = errors.New("服务器断开了连接")
+	miner.lock.Unlock()
+}
+
+func (miner *stratumMiner) handleMessage(msg *stratumMessage) {
+	miner.lock.Lock()
+	defer miner.lock.Unlock()
+	switch msg.Method {
+	case "mining.set_difficulty":
+		var params []float64
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) == 0 || params[0] <= 0 {
+			return
+		}
+		miner.shareTarget = shareTarget(params[0])
+		fmt.Printf("份额难度: %g\n", params[0])
+		return
+	case "mining.notify":
+		job, clean, err := parseStratumNotify(msg.Params)
+		if err != nil {
+			fmt.Printf("任务格式错误: %s\n", err)
+			return
+		}
+		miner.job = job
+		miner.jobVersion++
+		fmt.Printf("新任务: %s 区块高度: %d 清除旧任务: %t\n", job.ID, job.Height, clean)
+		return
+	}
+
+	//响应
+	id, ok := msg.ID.(float64)
+	if !ok {
+		return
+	}
+	failed := len(msg.Error) != 0 && string(msg.Error) != "null"
+	switch uint64(id) {
+	case 1:
+		var result []json.RawMessage
+		if failed || json.Unmarshal(msg.Result, &result) != nil || len(result) < 3 {
+			miner.err = errors.New("订阅失败")
+			return
+		}
+		var extraNonce1 string
+		json.Unmarshal(result[1], &extraNonce1)
+		json.Unmarshal(result[2], &miner.extraNonce2Size)
+		if miner.extraNonce2Size < 1 || miner.extraNonce2Size > 4 {
+			miner.err = fmt.Errorf("不支持的extranonce2长度: %d", miner.extraNonce2Size)
+			return
+		}
+		miner.extraNonce1, _ = hex.DecodeString(extraNonce1)
+	case 2:
+		if failed {
+			miner.err = fmt.Errorf("登记矿工失败: %s", msg.Error)
+		}
+	default:
+		isBlock, ok := miner.pending[uint64(id)]
+		if !ok {
+			return
+		}
+		delete(miner.pending, uint64(id))
+		if failed {
+			miner.rejected++
+			fmt.Printf("份额被拒绝: %s\n", msg.Error)
+			return
+		}
+		miner.accepted++
+		if isBlock {
+			miner.blocks++
+			fmt.Printf("找到区块，已找到%d个区块\n", miner.blocks)
+		}
+	}
+}
+
+//连接stratum服务器挖矿，找到maxBlocks个区块后返回，maxBlocks为0时一直挖矿
+func RunStratumMiner(address, worker string, maxBlocks int) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	//订阅和登记的请求id固定为1和2，份额从3开始
+	miner := stratumMiner{conn: conn, worker: worker, pending: make(map[uint64]bool), nextID: 2}
+	go miner.readLoop()
+	miner.send(1, "mining.subscribe", "stratumMiner")
+	miner.send(2, "mining.authorize", worker, "")
+
+	blockTarget := PowTarget()
+	var job *stratumMinerJob
+	var jobVersion uint64
+	var extraNonce2 uint32
+	var nonce uint64
+	var header *Block
+	var extraNonce []byte
+	var extraNonce2Bytes []byte
+	var target *big.Int
+	for {
+		miner.lock.Lock()
+		err, blocks, accepted, rejected := miner.err, miner.blocks, miner.accepted, miner.rejected
+		ready := miner.job != nil && miner.extraNonce1 != nil && miner.shareTarget != nil
+		if ready && miner.jobVersion != jobVersion {
+			job, jobVersion = miner.job, miner.jobVersion
+			header = nil
+		}
+		target = miner.shareTarget
+		miner.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		if maxBlocks > 0 && blocks >= maxBlocks {
+			fmt.Printf("挖矿结束: 接受的份额: %d 拒绝的份额: %d 区块: %d\n", accepted, rejected, blocks)
+			return nil
+		}
+		if job == nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		for i := 0; i < stratumMinerCheckInterval; i++ {
+			//新任务或者随机数用完时换一个extranonce2
+			if header == nil || nonce == ^uint64(0) {
+				extraNonce2++
+				nonce = 0
+				en2 := make([]byte, 4)
+				binary.BigEndian.PutUint32(en2, extraNonce2)
+				miner.lock.Lock()
+				extraNonce2Bytes = en2[4-miner.extraNonce2Size:]
+				extraNonce = append(append([]byte{}, miner.extraNonce1...), extraNonce2Bytes...)
+				miner.lock.Unlock()
+				header = job.header(job.merkleRoot(extraNonce), job.TimeStamp)
+			}
+			hash := sha256.Sum256(NewProofOfWork(header).prepareData(nonce))
+			if hashBelowTarget(hash[:], target) {
+				miner.submit(job, extraNonce2Bytes, header.TimeStamp, nonce, hashBelowTarget(hash[:], blockTarget))
+			}
+			nonce++
+		}
+	}
+}
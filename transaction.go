@@ -3,13 +3,13 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
+	"math"
 )
 
 const reward = 12.5
@@ -20,6 +20,8 @@ type Transaction struct {
 	//交易输入数组
 	TXInputs  []TXInput
 	TXOutputs []TXOutput
+	//锁定时间，小于LockTimeThreshold表示区块高度，否则是unix时间，0表示不锁定
+	LockTime uint64
 }
 
 //定义交易输入
@@ -34,6 +36,10 @@ type TXInput struct {
 	Signature []byte
 	//这里的PubKey不存储原始的公钥，而是存储X，Y拼接的字符串，在校验端重新拆分（参考r，s传递）
 	PubKey []byte
+	//解锁脚本，为空时由Signature和PubKey组成P2PKH的解锁脚本
+	ScriptSig []byte
+	//序列号，用于相对时间锁，SequenceFinal表示不使用任何时间锁
+	Sequence uint32
 }
 
 //定义交易输出
@@ -42,16 +48,70 @@ type TXOutput struct {
 	Value float64
 	//锁定脚本，我们用地址模拟
 	//PubKeyHash string
-	//收款方的公钥的hash
+	//收款方的公钥的hash，非标准脚本时为空
 	PubKeyHash []byte
+	//锁定脚本，为空时（旧版本的output）按照PubKeyHash的P2PKH脚本处理
+	ScriptPubKey []byte
+}
+
+//返回output的锁定脚本
+func (output *TXOutput) LockingScript() []byte {
+	if len(output.ScriptPubKey) != 0 {
+		return output.ScriptPubKey
+	}
+	return NewP2PKHScript(output.PubKeyHash)
+}
+
+//返回input的解锁脚本
+func (input *TXInput) UnlockingScript() []byte {
+	if len(input.ScriptSig) != 0 {
+		return input.ScriptSig
+	}
+	return NewP2PKHUnlockingScript(input.Signature, input.PubKey)
 }
 
 //由于现在存储的字段是地址的公钥hash，所以无法直接创建TXoutput
 //为了能够得到公钥hash，我们需要处理一下，写一个Lock函数
 func (output *TXOutput) Lock(address string) {
-	pubKeyHash := GetPubKeyHashFromAddress(address)
+	pubKeyHash, addressType, err := DecodeAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
 	//真正的锁定动作
 	output.PubKeyHash = pubKeyHash
+	//根据地址类型生成对应的锁定脚本
+	switch addressType {
+	case AddressScriptHash:
+		output.ScriptPubKey = NewP2SHScript(pubKeyHash)
+	default:
+		output.ScriptPubKey = NewP2PKHScript(pubKeyHash)
+	}
+}
+
+//返回output对应的地址，裸多重签名等没有地址的脚本返回空字符串
+func (output *TXOutput) Address() string {
+	script := output.LockingScript()
+	if pubKeyHash, ok := ExtractP2PKHPubKeyHash(script); ok {
+		return PubKeyHashToAddress(pubKeyHash)
+	}
+	if scriptHash, ok := ExtractP2SHScriptHash(script); ok {
+		return ScriptHashToAddress(scriptHash)
+	}
+	return ""
+}
+
+//创建携带数据的output，金额为0，不可花费
+func NewDataOutput(data []byte) (*TXOutput, error) {
+	script, err := NewDataScript(data)
+	if err != nil {
+		return nil, err
+	}
+	return &TXOutput{0, nil, script}, nil
+}
+
+//是否为不可花费的output（例如数据output），这样的output不计入utxo
+func (output *TXOutput) IsUnspendable() bool {
+	return IsUnspendableScript(output.ScriptPubKey)
 }
 
 //给TXOutput提供一个创建的方法，否则无法调用Lock
@@ -63,18 +123,74 @@ func NewTXOutput(value float64, address string) *TXOutput {
 	return &output
 }
 
-//设置交易ID
+//设置交易ID，不包含见证数据（见witness.go），签名前后交易ID不变
 func (tx *Transaction) SetHash() {
+	tx.TXID = tx.Hash()
+}
+
+//将交易序列化成字节流，原始交易的16进制编码就是它
+func (tx *Transaction) Serialize() []byte {
 	var buffer bytes.Buffer
 	encoder := gob.NewEncoder(&buffer)
 	err := encoder.Encode(tx)
 	if err != nil {
 		log.Panic(err)
 	}
+	return buffer.Bytes()
+}
+
+//计算交易hash（txid、wtxid、签名hash）使用的字节流，不包含TXID字段
+//gob按照类型第一次编码的顺序分配类型id，并写入序列化的结果，所以不能用gob的结果计算hash
+//格式固定：整数使用大端序，字节数组前面加4字节长度，金额使用float64的二进制表示
+func (tx *Transaction) HashData() []byte {
+	var buffer bytes.Buffer
+	writeHashBytes := func(data []byte) {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(data)))
+		buffer.Write(data)
+	}
+	binary.Write(&buffer, binary.BigEndian, uint32(len(tx.TXInputs)))
+	for _, input := range tx.TXInputs {
+		writeHashBytes(input.TXid)
+		binary.Write(&buffer, binary.BigEndian, input.Index)
+		writeHashBytes(input.Signature)
+		writeHashBytes(input.PubKey)
+		writeHashBytes(input.ScriptSig)
+		binary.Write(&buffer, binary.BigEndian, input.Sequence)
+	}
+	binary.Write(&buffer, binary.BigEndian, uint32(len(tx.TXOutputs)))
+	for _, output := range tx.TXOutputs {
+		binary.Write(&buffer, binary.BigEndian, math.Float64bits(output.Value))
+		writeHashBytes(output.PubKeyHash)
+		writeHashBytes(output.ScriptPubKey)
+	}
+	binary.Write(&buffer, binary.BigEndian, tx.LockTime)
+	return buffer.Bytes()
+}
+
+//反序列化交易，数据来自用户输入，出错时返回错误而不是panic
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&tx)
+	if err != nil {
+		return nil, errors.New("交易解码出错")
+	}
+	return &tx, nil
+}
 
-	data := buffer.Bytes()
-	hash := sha256.Sum256(data)
-	tx.TXID = hash[:]
+//交易手续费：输入总额减去输出总额，prevTXs是所有input引用的交易
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) float64 {
+	if tx.IsCoinbase() {
+		return 0
+	}
+	fee := 0.0
+	for _, input := range tx.TXInputs {
+		fee += prevTXs[string(input.TXid)].TXOutputs[input.Index].Value
+	}
+	for _, output := range tx.TXOutputs {
+		fee -= output.Value
+	}
+	return fee
 }
 
 //实现一个函数，判断当前交易是否为挖矿交易
@@ -88,68 +204,185 @@ func (tx *Transaction) IsCoinbase() bool {
 	return false
 }
 
+//转账选项
+type SendOptions struct {
+	//手动指定要花费的utxo（coin control），为空时自动选择
+	Inputs []TXInput
+	//找零地址，为空时找零给付款方
+	ChangeAddress string
+	//选币策略，为空时使用钱包设置的策略
+	Selector string
+	//每字节的手续费
+	FeeRate float64
+	//不为0时使用手续费估计，手续费率取在ConfTarget个区块内确认需要的值，没有足够的数据时使用FeeRate
+	ConfTarget int
+	//交易的锁定时间，0表示不锁定
+	LockTime uint64
+	//所有input的序列号，用于相对时间锁，0表示使用默认值
+	Sequence uint32
+	//是否允许在交易池中被手续费更高的交易替换
+	Replaceable bool
+	//附加在交易中的数据，不为空时创建一个数据output
+	Data []byte
+}
+
 //2.创建交易
 func NewTransaction(from, to string, amount float64, bc *BlockChain) *Transaction {
+	return NewTransactionToMany(from, []TXOutput{*NewTXOutput(amount, to)}, SendOptions{}, bc)
+}
+
+//创建一笔向多个收款方付款的交易
+func NewTransactionToMany(from string, outputs []TXOutput, opts SendOptions, bc *BlockChain) *Transaction {
 	//1.创建交易之后要进行数字签名，所以需要私钥->打开钱包(NewWallets())
 	ws := NewWallets()
+	//watch-only地址本地没有私钥，不能直接花费，只能走外部签名流程
+	if ws.IsWatchOnly(from) {
+		fmt.Println("该地址为watch-only地址，本地没有私钥，请使用外部签名流程创建交易!")
+		return nil
+	}
 	//2.根据地址找到自己的wallet
 	wallet := ws.WalletsMap[from]
 	if wallet == nil {
 		fmt.Println("没有找到该地址的钱包，交易创建失败!")
 		return nil
 	}
-	//3.得到对应的公钥私钥
+	//3.得到对应的公钥，签名时使用钱包中的私钥
 	pubKey := wallet.PubKey
-	privateKey := wallet.Private
-	//传递公钥的hash
-	pubKeyHash := HashPubKey(pubKey)
-	//1.找到最合理的UTXO集合 map[string]uint64
-	utxos, resValue := bc.FindNeedUTXOS(pubKeyHash, amount)
-	if resValue < amount {
-		fmt.Println("余额不足，交易失败")
+
+	tx := NewUnsignedTransactionToMany(from, outputs, pubKey, opts, bc)
+	if tx == nil {
 		return nil
 	}
 
+	bc.SignTransaction(tx, wallet)
+	return tx
+}
+
+//创建未签名的交易，只需要付款方的地址（公钥可选），签名由外部完成
+//本地钱包和watch-only地址都使用这个函数构建交易
+func NewUnsignedTransaction(from, to string, amount float64, pubKey []byte, bc *BlockChain) *Transaction {
+	return NewUnsignedTransactionToMany(from, []TXOutput{*NewTXOutput(amount, to)}, pubKey, SendOptions{}, bc)
+}
+
+func NewUnsignedTransactionToMany(from string, outputs []TXOutput, pubKey []byte, opts SendOptions, bc *BlockChain) *Transaction {
+	//传递公钥的hash
+	pubKeyHash := GetPubKeyHashFromAddress(from)
+	amount := 0.0
+	for _, output := range outputs {
+		amount += output.Value
+	}
+
+	if opts.ConfTarget != 0 {
+		if feeRate, err := bc.EstimateFee(opts.ConfTarget); err == nil {
+			opts.FeeRate = feeRate
+			fmt.Printf("使用估计的手续费率: %.8f（%d个区块内确认）\n", feeRate, opts.ConfTarget)
+		}
+	}
+	sequence := opts.Sequence
+	if sequence == 0 {
+		sequence = SequenceDefault
+		if opts.Replaceable {
+			sequence = MaxRBFSequence
+		}
+	}
 	var inputs []TXInput
-	var outputs []TXOutput
-	//2.将这些UTXO逐一转成inputs
-	for id, indexArray := range utxos {
-		for _, i := range indexArray {
-			input := TXInput{[]byte(id), int64(i), nil, pubKey}
-			inputs = append(inputs, input)
+	var selection *CoinSelection
+	if len(opts.Inputs) == 0 {
+		//1.使用选币策略找到合适的UTXO集合
+		selectorName := opts.Selector
+		if selectorName == "" {
+			selectorName = NewWallets().CoinSelector
+		}
+		selector, err := GetCoinSelector(selectorName)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		params := SelectionParams{Target: amount, NumOutputs: len(outputs), FeeRate: opts.FeeRate}
+		selection, err = selector.Select(bc.FindSpendableUTXOList(pubKeyHash), params)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		//2.将这些UTXO逐一转成inputs
+		for _, utxo := range selection.UTXOs {
+			inputs = append(inputs, TXInput{utxo.TXID, utxo.Index, nil, pubKey, nil, sequence})
+		}
+	} else {
+		//手动指定的utxo必须属于付款方并且没有花费过
+		selected, err := bc.CheckSelectedInputs(pubKeyHash, opts.Inputs)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		for _, input := range opts.Inputs {
+			inputs = append(inputs, TXInput{input.TXid, input.Index, nil, pubKey, nil, sequence})
+		}
+		//手续费和找零的计算与自动选择相同
+		params := SelectionParams{Target: amount, NumOutputs: len(outputs), FeeRate: opts.FeeRate}
+		selection, err = finishSelection(selected, params)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+	}
+
+	//3.创建outputs，复制一份，避免修改调用方的数据
+	outputs = append([]TXOutput{}, outputs...)
+	if opts.Data != nil {
+		output, err := NewDataOutput(opts.Data)
+		if err != nil {
+			fmt.Println(err)
+			return nil
 		}
+		outputs = append(outputs, *output)
 	}
-	//3.创建outputs
-	//output := TXOutput{amount, to}
-	output := NewTXOutput(amount, to)
-	outputs = append(outputs, *output)
 	//4.如果有零钱需要找零
-	if resValue > amount {
-		output = NewTXOutput(resValue-amount, from)
+	if selection.Change > 0 {
+		changeAddress := from
+		if opts.ChangeAddress != "" {
+			changeAddress = opts.ChangeAddress
+		}
+		output := NewTXOutput(selection.Change, changeAddress)
 		outputs = append(outputs, *output)
 	}
 
-	tx := Transaction{[]byte{}, inputs, outputs}
+	tx := Transaction{[]byte{}, inputs, outputs, opts.LockTime}
 	tx.SetHash()
-
-	bc.SignTransaction(&tx, privateKey)
 	return &tx
 }
 
 //3.创建挖矿交易
 func NewCoinbaseTX(address string, data string) *Transaction {
+	return NewCoinbaseTXWithFees(address, data, 0)
+}
+
+//创建挖矿交易，金额为出块奖励加上区块中所有交易的手续费
+func NewCoinbaseTXWithFees(address string, data string, fees float64) *Transaction {
 	//挖矿交易特点
 	//1.只有一个input
 	//2.无需引用交易id
 	//3.无需引用index
 	//矿工由于挖矿时无需指定签名，所以PubKey字段可以由矿工自由填写
-	//签名先填写为空
-	input := TXInput{[]byte{}, -1, nil, []byte(data)}
+	//这里填写随机数，保证不同区块的挖矿交易id不同，矿工的数据放到数据output中
+	extraNonce := make([]byte, 8)
+	_, err := rand.Read(extraNonce)
+	if err != nil {
+		log.Panic(err)
+	}
+	input := TXInput{[]byte{}, -1, nil, extraNonce, nil, SequenceFinal}
 	//output := TXOutput{reward, address}
 	//新的创建方法
-	output := NewTXOutput(reward, address)
-	//对于挖矿交易来说只有一个input和一个output
-	tx := Transaction{[]byte{}, []TXInput{input}, []TXOutput{*output}}
+	output := NewTXOutput(reward+fees, address)
+	outputs := []TXOutput{*output}
+	if data != "" {
+		dataOutput, err := NewDataOutput([]byte(data))
+		if err != nil {
+			log.Panic(err)
+		}
+		outputs = append(outputs, *dataOutput)
+	}
+	tx := Transaction{[]byte{}, []TXInput{input}, outputs, 0}
 	tx.SetHash()
 
 	return &tx
@@ -159,92 +392,135 @@ func NewCoinbaseTX(address string, data string) *Transaction {
 
 //签名的具体实现,参数为：私钥，inputs里面所有引用的交易的结构map[string]Transaction
 //map[A] TransactionA
-func (tx *Transaction) Sign(privateKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) Sign(wallet *Wallet, prevTXs map[string]Transaction) {
 	//对coinbase交易不签名
 	if tx.IsCoinbase() {
 		return
 	}
-	//1.创建一个当期交易的副本:txCopy，使用函数:TrimmedCopy：要把Signature和PubKey字段设置为nil
-	txCopy := tx.TrimmedCopy()
-	//2.循环遍历txCopy的inputs，得到input所引用的output的公钥哈希
-	for i, input := range txCopy.TXInputs {
+	//循环遍历inputs，得到input所引用的output，逐一签名
+	for i, input := range tx.TXInputs {
 		prevTX := prevTXs[string(input.TXid)]
-		if len(prevTX.TXID) == 0 {
+		if len(prevTX.TXID) == 0 || input.Index < 0 || int(input.Index) >= len(prevTX.TXOutputs) {
 			log.Panic("引用的交易无效")
 		}
-		//不要对input进行赋值，这是一个副本，要对txCopy.TXInputs[i]进行操作
-		txCopy.TXInputs[i].PubKey = prevTX.TXOutputs[input.Index].PubKeyHash
-		//所需要的三个数据都具备了，开始做哈希处理
-		//3.生成要签名的数据，要签名的数据一定是哈希值
-		txCopy.SetHash()
-		//还原，以免影响后面input的签名
-		//a.我们对每一个input都要签名一次，签名数据是由当前input引用的output的哈希+当前的outputs（都在当前tx的副本里）
-		//b.要对拼好的txCopy进行哈希处理，SetHash得到TXID，这个TXID就是我们要签名的最终数据
-		txCopy.TXInputs[i].PubKey = nil
-		signDataHash := txCopy.TXID
-		//4.进行签名动作
-		r, s, err := ecdsa.Sign(rand.Reader, privateKey, signDataHash)
-		if err != nil {
-			log.Panic(err)
-		}
-		signature := append(r.Bytes(), s.Bytes()...)
-		//5.放到我们的input的Signature中
-		txCopy.TXInputs[i].Signature = signature
+		//放到我们的input的Signature中，注意要对tx本身操作，而不是副本
+		tx.TXInputs[i].Signature = tx.SignInput(i, wallet, prevTX.TXOutputs[input.Index])
+	}
+}
+
+//对第i个input签名，只需要它引用的output，不需要整个区块链（离线签名使用）
+func (tx *Transaction) SignInput(i int, wallet *Wallet, prevOutput TXOutput) []byte {
+	return tx.SignInputWithScript(i, wallet, prevOutput.LockingScript())
+}
+
+//使用指定的子脚本对第i个input签名，签名类型为ALL
+func (tx *Transaction) SignInputWithScript(i int, wallet *Wallet, subScript []byte) []byte {
+	signature, err := tx.SignInputWithType(i, wallet, subScript, SigHashAll)
+	if err != nil {
+		log.Panic(err)
+	}
+	return signature
+}
+
+//使用指定的子脚本和签名类型对第i个input签名，签名的最后一个字节是签名类型
+func (tx *Transaction) SignInputWithType(i int, wallet *Wallet, subScript []byte, hashType SigHashType) ([]byte, error) {
+	signDataHash, err := tx.SignatureHashForType(i, subScript, hashType)
+	if err != nil {
+		return nil, err
 	}
+	//确定性签名，同一笔交易重复签名得到相同的结果
+	return append(wallet.SignHash(signDataHash), byte(hashType)), nil
+}
+
+//生成第i个input要签名的数据
+func (tx *Transaction) SignatureHash(i int, prevOutput TXOutput) []byte {
+	return tx.SignatureHashForScript(i, prevOutput.LockingScript())
+}
 
+//a.我们对每一个input都要签名一次，签名数据是由当前input的子脚本（通常是引用的output的锁定脚本）+当前的outputs（都在当前tx的副本里）
+//b.要对拼好的txCopy进行哈希处理（包括放在ScriptSig中的子脚本），再与签名类型一起hash就是我们要签名的最终数据
+//每个input的签名数据互相独立，与签名的顺序无关
+//这里使用ALL类型，其他签名类型见SignatureHashForType
+func (tx *Transaction) SignatureHashForScript(i int, subScript []byte) []byte {
+	hash, err := tx.SignatureHashForType(i, subScript, SigHashAll)
+	if err != nil {
+		log.Panic(err)
+	}
+	return hash
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 	for _, input := range tx.TXInputs {
-		inputs = append(inputs, TXInput{input.TXid, input.Index, nil, nil})
+		inputs = append(inputs, TXInput{input.TXid, input.Index, nil, nil, nil, input.Sequence})
 	}
 
 	for _, output := range tx.TXOutputs {
 		outputs = append(outputs, output)
 	}
-	return Transaction{tx.TXID, inputs, outputs}
+	return Transaction{tx.TXID, inputs, outputs, tx.LockTime}
 }
 
 //分析校验过程
 //所需要的数据：公钥、数据（txCopy、生成哈希）签名
 //我们要对每一个签名过得input进行校验
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	return tx.VerifyWithCache(prevTXs, nil, nil)
+}
+
+//batch不为空时Schnorr签名只加入batch，调用方最后统一校验
+//sigCache不为空时使用签名缓存
+func (tx *Transaction) VerifyWithCache(prevTXs map[string]Transaction, batch *SchnorrBatch, sigCache *SigCache) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
-	//1.得到签名数据
-	txCopy := tx.TrimmedCopy()
 	for i, input := range tx.TXInputs {
 		prevTX := prevTXs[string(input.TXid)]
 		if len(prevTX.TXID) == 0 {
 			log.Panic("引用的交易无效")
 		}
-		txCopy.TXInputs[i].PubKey = prevTX.TXOutputs[input.Index].PubKeyHash
-		txCopy.SetHash()
-		dataHash := txCopy.TXID
-		//2.得到Signature,反推r，s
-		signature := input.Signature //r,s
-		pubKey := input.PubKey       //拆x,y
-		r := big.Int{}
-		s := big.Int{}
-
-		r.SetBytes(signature[:len(signature)/2])
-		s.SetBytes(signature[len(signature)/2:])
-
-		//3.拆解PubKey，得到x，y
-		X := big.Int{}
-		Y := big.Int{}
-
-		X.SetBytes(pubKey[:len(pubKey)/2])
-		Y.SetBytes(pubKey[len(pubKey)/2:])
-		pubKeyOrigin := ecdsa.PublicKey{elliptic.P256(), &X, &Y}
-		//4.Verify
-		if !ecdsa.Verify(&pubKeyOrigin, dataHash, &r, &s) {
+		if input.Index < 0 || int(input.Index) >= len(prevTX.TXOutputs) {
+			return false
+		}
+		err := VerifyScriptWithCache(input.UnlockingScript(), prevTX.TXOutputs[input.Index].LockingScript(), tx, i, batch, sigCache)
+		if err != nil {
 			return false
 		}
 	}
 	return true
 }
+
+//校验第i个input，只需要它引用的output
+//执行input的解锁脚本和output的锁定脚本
+func (tx *Transaction) VerifyInput(i int, prevOutput TXOutput) bool {
+	input := tx.TXInputs[i]
+	err := VerifyScript(input.UnlockingScript(), prevOutput.LockingScript(), tx, i)
+	return err == nil
+}
+
+//校验签名
+//所需要的数据：公钥、签名数据的哈希、签名
+func VerifySignature(pubKey, dataHash, signature []byte) bool {
+	//1.得到Signature,反推r，s
+	//2.解析PubKey，根据公钥的编码得到曲线
+	r, s, err := ParseSignature(signature)
+	if err != nil {
+		return false
+	}
+	pubKeyOrigin, keyType, err := ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+	//32字节的x-only公钥使用Schnorr签名
+	if keyType == KeyTypeSchnorr {
+		return SchnorrVerify(pubKey, dataHash, signature)
+	}
+	//3.只接受低s值的签名，防止签名被修改
+	if !isLowS(pubKeyOrigin.Curve, s) {
+		return false
+	}
+	//4.Verify
+	return ecdsa.Verify(pubKeyOrigin, dataHash, r, s)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+//交易id只由交易内容决定，与gob的类型注册顺序无关，格式改变时这里的值也会改变
+func TestTransactionHash(t *testing.T) {
+	tx := Transaction{nil, []TXInput{{[]byte{0x01, 0x02}, 1, []byte{0x30}, []byte{0x04}, nil, SequenceFinal}},
+		[]TXOutput{{1.5, []byte{0xab}, []byte{0x76, 0xa9}}}, 100}
+	txid := hex.EncodeToString(tx.Hash())
+	if txid != "5147de07a3780127fd83fde2e483a6e0fd66f145f66e3ed392697b0a84370983" {
+		t.Fatalf("交易id: %s", txid)
+	}
+	wtxid := hex.EncodeToString(tx.WitnessHash())
+	if wtxid != "b531848fadd13fc580be7617ebe3eab0c75bd58114328aded312e2039ff74611" {
+		t.Fatalf("wtxid: %s", wtxid)
+	}
+	//大小按规范编码计算，去掉见证数据时少了签名和公钥各1字节
+	if tx.TotalSize() != 67 || tx.BaseSize() != 65 || tx.Weight() != 262 || tx.VirtualSize() != 66 {
+		t.Fatalf("交易大小: %d %d %d %d", tx.TotalSize(), tx.BaseSize(), tx.Weight(), tx.VirtualSize())
+	}
+	//区块头5个uint64（前区块hash和默克尔根为空），交易个数4字节
+	block := Block{Transactions: []*Transaction{&tx}}
+	if block.Size() != 40+4+67 {
+		t.Fatalf("区块大小: %d", block.Size())
+	}
+	//修改见证数据不改变交易id，但是改变wtxid
+	tx.TXInputs[0].Signature = []byte{0x31}
+	if hex.EncodeToString(tx.Hash()) != txid || hex.EncodeToString(tx.WitnessHash()) == wtxid {
+		t.Fatal("见证数据影响了交易id")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ShersBlockChain/bolt"
+	"log"
+	"strconv"
+)
+
+//output的花费规则
+//1.每个output只能被花费一次，同一个区块中也不能有两个input引用同一个output
+//2.挖矿交易的output需要等待coinbaseMaturity个区块之后才能花费，防止区块被替换后挖矿奖励消失，花费它的交易全部失效
+
+//挖矿交易成熟需要的区块数的默认值
+const defaultCoinbaseMaturity = 100
+
+//挖矿交易成熟需要的区块数，保存在区块bucket中
+const coinbaseMaturityKey = "coinbaseMaturity"
+
+//output的唯一标识 txid:index
+func outpointKey(txid []byte, index int64) string {
+	return fmt.Sprintf("%x:%d", txid, index)
+}
+
+//把交易中所有input引用的output标记为已花费
+func markSpentOutputs(spent map[string]bool, txs []*Transaction) {
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.TXInputs {
+			spent[outpointKey(input.TXid, input.Index)] = true
+		}
+	}
+}
+
+//挖矿交易成熟需要的区块数
+func (bc *BlockChain) CoinbaseMaturity() uint64 {
+	maturity := uint64(defaultCoinbaseMaturity)
+	bc.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(blockBucket))
+		if bucket == nil {
+			log.Panic("bucket不应该为空，请检查")
+		}
+		if value := bucket.Get([]byte(coinbaseMaturityKey)); value != nil {
+			n, err := strconv.ParseUint(string(value), 10, 64)
+			if err == nil {
+				maturity = n
+			}
+		}
+		return nil
+	})
+	return maturity
+}
+
+//设置挖矿交易成熟需要的区块数，之后的校验（包括verifyChain）都使用新的值
+func (bc *BlockChain) SetCoinbaseMaturity(maturity uint64) error {
+	if maturity < 1 {
+		return errors.New("成熟区块数至少为1")
+	}
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(blockBucket))
+		if bucket == nil {
+			log.Panic("bucket不应该为空，请检查")
+		}
+		return bucket.Put([]byte(coinbaseMaturityKey), []byte(strconv.FormatUint(maturity, 10)))
+	})
+}
+
+//utxo在指定高度的区块中是否可以花费
+func (utxo *UTXO) IsMature(height, maturity uint64) bool {
+	return !utxo.Coinbase || height >= utxo.Height+maturity
+}
+
+//校验交易的input引用的output都没有被花费过，并且挖矿交易的output已经成熟
+//height是交易所在（或者将要打包进）的区块高度
+//input可以引用txs中排在前面的交易（父交易和子交易打包进同一个区块）
+func (bc *BlockChain) CheckInputsSpendable(txs []*Transaction, height uint64) error {
+	//交易在txs中的位置
+	position := make(map[string]int)
+	for i, tx := range txs {
+		position[string(tx.TXID)] = i
+	}
+	//同一批交易中不能重复花费
+	needed := make(map[string]bool)
+	prevIDs := make(map[string]bool)
+	for i, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.TXInputs {
+			key := outpointKey(input.TXid, input.Index)
+			if needed[key] {
+				return fmt.Errorf("双重花费: output %s被花费了两次", key)
+			}
+			needed[key] = true
+			if j, ok := position[string(input.TXid)]; ok {
+				if j >= i {
+					return fmt.Errorf("交易%x引用了排在它后面的交易%x", tx.TXID, input.TXid)
+				}
+				if txs[j].IsCoinbase() {
+					return fmt.Errorf("挖矿交易%x的output需要%d个区块才能花费", input.TXid, bc.CoinbaseMaturity())
+				}
+				continue
+			}
+			prevIDs[string(input.TXid)] = true
+		}
+	}
+	if len(prevIDs) == 0 {
+		return nil
+	}
+	maturity := bc.CoinbaseMaturity()
+	//从最新的区块开始遍历，花费output的交易一定不早于创建它的交易，所以找到所有引用的交易时就可以停止
+	found := 0
+	it := bc.NewIterator()
+	for found < len(prevIDs) {
+		block := it.Next()
+		spent := make(map[string]bool)
+		markSpentOutputs(spent, block.Transactions)
+		for key := range spent {
+			if needed[key] {
+				return fmt.Errorf("双重花费: output %s已经在区块%x中被花费", key, block.Hash)
+			}
+		}
+		for _, tx := range block.Transactions {
+			if !prevIDs[string(tx.TXID)] {
+				continue
+			}
+			found++
+			if tx.IsCoinbase() && height < block.Height+maturity {
+				return fmt.Errorf("挖矿交易%x的output需要%d个区块才能花费，当前只有%d个", tx.TXID, maturity, height-block.Height)
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	if found < len(prevIDs) {
+		return errors.New("引用的交易不存在")
+	}
+	return nil
+}
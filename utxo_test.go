@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckInputsSpendable(t *testing.T) {
+	wallet := NewWallet(KeyTypeP256)
+	address := wallet.NewAddress()
+	other := NewWallet(KeyTypeP256).NewAddress()
+	bc := newTestBlockChain(t, address)
+	genesisCoinbase := bc.NewIterator().Next().Transactions[0]
+	spend1 := newTestSpend(t, wallet, genesisCoinbase, 0, reward, address)
+	spend2 := newTestSpend(t, wallet, genesisCoinbase, 0, reward, other)
+	coinbase := NewCoinbaseTX(address, "")
+	spendCoinbase := newTestSpend(t, wallet, coinbase, 0, reward, other)
+
+	//默认需要100个区块才能花费挖矿交易
+	maturity := bc.CoinbaseMaturity()
+	tests := []struct {
+		name   string
+		txs    []*Transaction
+		height uint64
+		err    string
+	}{
+		{"未成熟的挖矿交易", []*Transaction{spend1}, maturity - 1, "才能花费"},
+		{"已成熟的挖矿交易", []*Transaction{spend1}, maturity, ""},
+		{"同一个区块中双重花费", []*Transaction{spend1, spend2}, maturity, "被花费了两次"},
+		{"花费同一个区块的挖矿交易", []*Transaction{coinbase, spendCoinbase}, maturity, "才能花费"},
+		{"引用排在后面的交易", []*Transaction{spendCoinbase, coinbase}, maturity, "排在它后面"},
+	}
+	for _, test := range tests {
+		err := bc.CheckInputsSpendable(test.txs, test.height)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: 错误应该包含%q，实际是%v", test.name, test.err, err)
+		}
+	}
+
+	//跨区块双重花费：spend1打包之后，花费同一个output的spend2无效
+	if err := bc.SetCoinbaseMaturity(1); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), spend1}) {
+		t.Fatal("花费已成熟的挖矿交易的区块应该有效")
+	}
+	err := bc.CheckInputsSpendable([]*Transaction{spend2}, 2)
+	if err == nil || !strings.Contains(err.Error(), "已经在区块") {
+		t.Fatalf("跨区块的双重花费应该失败: %v", err)
+	}
+	if bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), spend2}) {
+		t.Fatal("包含双重花费的区块应该无效")
+	}
+}
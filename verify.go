@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+//并行校验区块中的交易
+//1.遍历一次区块链找到所有引用的交易，而不是每个input遍历一次
+//2.每个input的脚本校验是一个任务，由多个线程执行，任何一个失败时停止分配剩余的任务
+//3.每个线程有自己的SchnorrBatch，最后各自批量校验
+
+//校验线程数，默认为CPU核数
+var verifyWorkers = runtime.NumCPU()
+
+//一个input的校验任务
+type inputCheck struct {
+	tx         *Transaction
+	inputIndex int
+	prevOutput TXOutput
+}
+
+func (check inputCheck) verify(batch *SchnorrBatch, sigCache *SigCache) error {
+	input := check.tx.TXInputs[check.inputIndex]
+	err := VerifyScriptWithCache(input.UnlockingScript(), check.prevOutput.LockingScript(), check.tx, check.inputIndex, batch, sigCache)
+	if err != nil {
+		return fmt.Errorf("交易%x的第%d个input无效: %s", check.tx.TXID, check.inputIndex, err)
+	}
+	return nil
+}
+
+//遍历一次区块链，找到所有交易的input引用的交易，key是交易id
+//引用的交易也可以在txs中（同一个区块先打包父交易再打包子交易，或者交易池中未确认的父交易）
+func (bc *BlockChain) FindPrevTransactionsForBlock(txs []*Transaction) (map[string]Transaction, error) {
+	listed := make(map[string]*Transaction)
+	for _, tx := range txs {
+		listed[string(tx.TXID)] = tx
+	}
+	prevTXs := make(map[string]Transaction)
+	needed := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.TXInputs {
+			if parent, ok := listed[string(input.TXid)]; ok {
+				prevTXs[string(input.TXid)] = *parent
+				continue
+			}
+			needed[string(input.TXid)] = true
+		}
+	}
+	found := 0
+	it := bc.NewIterator()
+	for found < len(needed) {
+		block := it.Next()
+		for _, tx := range block.Transactions {
+			if needed[string(tx.TXID)] {
+				prevTXs[string(tx.TXID)] = *tx
+				found++
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	for id := range needed {
+		if _, ok := prevTXs[id]; !ok {
+			return nil, fmt.Errorf("引用的交易不存在: %x", id)
+		}
+	}
+	return prevTXs, nil
+}
+
+//检查交易id、引用的output以及手续费，返回所有input的校验任务
+func buildInputChecks(txs []*Transaction, prevTXs map[string]Transaction) ([]inputCheck, error) {
+	var checks []inputCheck
+	for _, tx := range txs {
+		//交易id必须与内容一致，否则可以用别人的交易id冒充
+		if !tx.HasValidTXID() {
+			return nil, fmt.Errorf("交易id与交易内容不一致: %x", tx.TXID)
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for i, input := range tx.TXInputs {
+			prevTX, ok := prevTXs[string(input.TXid)]
+			if !ok || input.Index < 0 || int(input.Index) >= len(prevTX.TXOutputs) {
+				return nil, fmt.Errorf("交易%x的第%d个input引用的output无效", tx.TXID, i)
+			}
+			checks = append(checks, inputCheck{tx, i, prevTX.TXOutputs[input.Index]})
+		}
+		//输出总额不能超过输入总额
+		if tx.Fee(prevTXs) < 0 {
+			return nil, fmt.Errorf("交易%x的输出总额大于输入总额", tx.TXID)
+		}
+	}
+	return checks, nil
+}
+
+//使用workers个线程执行校验任务，返回第一个失败的错误
+func runInputChecks(checks []inputCheck, workers int, sigCache *SigCache) error {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan inputCheck)
+	abort := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(abort)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := SchnorrBatch{}
+			for check := range jobs {
+				if err := check.verify(&batch, sigCache); err != nil {
+					fail(err)
+				}
+			}
+			select {
+			case <-abort:
+				return
+			default:
+			}
+			if !batch.Verify() {
+				fail(fmt.Errorf("批量校验%d个Schnorr签名失败", batch.Len()))
+			}
+		}()
+	}
+	//已经失败时不再分配任务
+feed:
+	for _, check := range checks {
+		select {
+		case jobs <- check:
+		case <-abort:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+//并行校验区块中的所有交易
+//返回input引用的交易
+func (bc *BlockChain) VerifyBlockTransactions(txs []*Transaction, sigCache *SigCache, workers int) (map[string]Transaction, error) {
+	prevTXs, err := bc.FindPrevTransactionsForBlock(txs)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := buildInputChecks(txs, prevTXs)
+	if err != nil {
+		return nil, err
+	}
+	return prevTXs, runInputChecks(checks, workers, sigCache)
+}
+
+//重新校验整条区块链上的所有签名和脚本以及output的花费，不使用签名缓存，返回区块数和input数
+//可以用不同的线程数执行，比较校验的速度
+func (bc *BlockChain) VerifyChain(workers int) (int, int, error) {
+	var blocks []*Block
+	allTXs := make(map[string]Transaction)
+	//交易所在区块的高度，用于检查挖矿交易是否成熟
+	heights := make(map[string]uint64)
+	it := bc.NewIterator()
+	for {
+		block := it.Next()
+		blocks = append(blocks, block)
+		for _, tx := range block.Transactions {
+			allTXs[string(tx.TXID)] = *tx
+			heights[string(tx.TXID)] = block.Height
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	//从创世区块开始检查每个output只被花费一次
+	maturity := bc.CoinbaseMaturity()
+	spent := make(map[string]bool)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, input := range tx.TXInputs {
+				key := outpointKey(input.TXid, input.Index)
+				if spent[key] {
+					return 0, 0, fmt.Errorf("区块%x: 双重花费: output %s", blocks[i].Hash, key)
+				}
+				spent[key] = true
+				prevTX := allTXs[string(input.TXid)]
+				if prevTX.IsCoinbase() && blocks[i].Height < heights[string(input.TXid)]+maturity {
+					return 0, 0, fmt.Errorf("区块%x: 花费了未成熟的挖矿交易%x", blocks[i].Hash, input.TXid)
+				}
+			}
+		}
+	}
+	var checks []inputCheck
+	for _, block := range blocks {
+		if err := block.CheckWitnessCommitment(); err != nil {
+			return 0, 0, fmt.Errorf("区块%x: %s", block.Hash, err)
+		}
+		if err := block.CheckLimits(allTXs); err != nil {
+			return 0, 0, fmt.Errorf("区块%x: %s", block.Hash, err)
+		}
+		if err := CheckCoinbaseValue(block.Transactions, allTXs); err != nil {
+			return 0, 0, fmt.Errorf("区块%x: %s", block.Hash, err)
+		}
+		blockChecks, err := buildInputChecks(block.Transactions, allTXs)
+		if err != nil {
+			return 0, 0, fmt.Errorf("区块%x: %s", block.Hash, err)
+		}
+		checks = append(checks, blockChecks...)
+	}
+	return len(blocks), len(checks), runInputChecks(checks, workers, nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+//基准测试使用的区块交易：一笔交易把创世区块的奖励拆成count个output，每个output再由一笔交易花费
+//output轮流付给P256、secp256k1和Schnorr地址，覆盖ECDSA签名和批量校验的Schnorr签名
+func newBenchmarkBlockTransactions(b *testing.B, count int) (*BlockChain, []*Transaction) {
+	genesisWallet := NewWallet(KeyTypeP256)
+	bc := newTestBlockChain(b, genesisWallet.NewAddress())
+	genesisCoinbase := bc.NewIterator().Next().Transactions[0]
+	wallets := []*Wallet{NewWallet(KeyTypeP256), NewWallet(KeyTypeSecp256k1), NewWallet(KeyTypeSchnorr)}
+
+	value := reward / float64(count)
+	var outputs []TXOutput
+	for i := 0; i < count; i++ {
+		outputs = append(outputs, *NewTXOutput(value, wallets[i%len(wallets)].NewAddress()))
+	}
+	input := TXInput{genesisCoinbase.TXID, 0, nil, genesisWallet.PubKey, nil, SequenceFinal}
+	fanOut := Transaction{nil, []TXInput{input}, outputs, 0}
+	fanOut.SetHash()
+	fanOut.Sign(genesisWallet, map[string]Transaction{string(genesisCoinbase.TXID): *genesisCoinbase})
+
+	txs := []*Transaction{NewCoinbaseTX(genesisWallet.NewAddress(), ""), &fanOut}
+	for i := 0; i < count; i++ {
+		wallet := wallets[i%len(wallets)]
+		tx := Transaction{nil, []TXInput{{fanOut.TXID, int64(i), nil, wallet.PubKey, nil, SequenceFinal}},
+			[]TXOutput{*NewTXOutput(value, genesisWallet.NewAddress())}, 0}
+		tx.SetHash()
+		tx.Sign(wallet, map[string]Transaction{string(fanOut.TXID): fanOut})
+		txs = append(txs, &tx)
+	}
+	return bc, txs
+}
+
+//不同的GOMAXPROCS（校验线程数与之相同）下校验区块交易的速度，以及签名缓存命中时的速度
+func BenchmarkVerifyBlockTransactions(b *testing.B) {
+	bc, txs := newBenchmarkBlockTransactions(b, 300)
+	prevTXs, err := bc.FindPrevTransactionsForBlock(txs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	//交易加入交易池时逐笔校验，所有签名（包括Schnorr签名）都进入缓存
+	sigCache := NewSigCache()
+	for _, tx := range txs[1:] {
+		if !tx.VerifyWithCache(prevTXs, nil, sigCache) {
+			b.Fatalf("交易%x无效", tx.TXID)
+		}
+	}
+	for _, procs := range []int{1, 2, 4, 8} {
+		for _, cached := range []bool{false, true} {
+			b.Run(fmt.Sprintf("procs=%d/cache=%t", procs, cached), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+				var cache *SigCache
+				if cached {
+					cache = sigCache
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := bc.VerifyBlockTransactions(txs, cache, procs); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
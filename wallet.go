@@ -3,44 +3,127 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
+	"errors"
 	"github.com/btcsuite/btcutil/base58"
 	"golang.org/x/crypto/ripemd160"
 	"log"
+	"time"
 )
 
+//地址的版本号，决定了地址的类型
+const pubKeyHashVersion = byte(0x00)
+
+//P2SH地址的版本号，与P2PKH地址区分，花费之前不会暴露赎回脚本
+const scriptHashVersion = byte(0x05)
+
+//地址类型
+type AddressType int
+
+const (
+	AddressUnknown AddressType = iota
+	//支付到公钥hash
+	AddressPubKeyHash
+	//支付到脚本hash
+	AddressScriptHash
+)
+
+func (t AddressType) String() string {
+	switch t {
+	case AddressPubKeyHash:
+		return "P2PKH"
+	case AddressScriptHash:
+		return "P2SH"
+	default:
+		return "unknown"
+	}
+}
+
 //这里的钱包是一个结构，每一个钱包保存了公钥私钥对
 type Wallet struct {
 	Private *ecdsa.PrivateKey
 	//PubKey *ecdsa.PublicKey
-	//这里的PubKey不存储原始的公钥，而是存储X，Y拼接的字符串，在校验端重新拆分（参考r，s传递）
+	//这里的PubKey不存储原始的公钥，P256存储X，Y拼接的字符串，在校验端重新拆分（参考r，s传递）
+	//secp256k1存储33字节的压缩公钥
 	PubKey []byte
+	//创建时间，用于地址列表排序
+	CreateTime int64
+	//密钥类型
+	KeyType KeyType
 }
 
-//创建钱包
-func NewWallet() *Wallet {
-	curve := elliptic.P256()
-	//生成私钥
-	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
-	if err != nil {
-		log.Panic()
+//钱包文件中保存的格式，私钥只保存D，加载时根据类型重新计算公钥
+//gob无法直接编码椭圆曲线
+type walletData struct {
+	KeyType    KeyType
+	D          []byte
+	PubKey     []byte
+	CreateTime int64
+}
+
+func (w *Wallet) GobEncode() ([]byte, error) {
+	data := walletData{w.KeyType, nil, w.PubKey, w.CreateTime}
+	if w.Private != nil {
+		data.D = w.Private.D.Bytes()
+	}
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(data)
+	return buffer.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return err
 	}
+	*w = Wallet{nil, data.PubKey, data.CreateTime, data.KeyType}
+	if data.D != nil {
+		w.Private = PrivateKeyFromBytes(data.KeyType, data.D)
+	}
+	return nil
+}
+
+//创建指定类型的钱包
+func NewWallet(keyType KeyType) *Wallet {
+	//生成私钥
+	privateKey := NewPrivateKey(keyType)
 	//生成公钥
-	pubKeyOrigin := privateKey.PublicKey
-	pubKey := append(pubKeyOrigin.X.Bytes(), pubKeyOrigin.Y.Bytes()...)
+	pubKey := SerializePubKey(keyType, &privateKey.PublicKey)
 	return &Wallet{
-		Private: privateKey,
-		PubKey:  pubKey,
+		Private:    privateKey,
+		PubKey:     pubKey,
+		CreateTime: time.Now().Unix(),
+		KeyType:    keyType,
 	}
 }
 
+//使用钱包的私钥对hash签名，根据密钥类型选择Schnorr或者ECDSA
+func (w *Wallet) SignHash(hash []byte) []byte {
+	if w.KeyType == KeyTypeSchnorr {
+		return SchnorrSign(w.Private, hash)
+	}
+	return SignHash(w.Private, hash)
+}
+
 //生成地址
 func (w *Wallet) NewAddress() string {
 	pubKey := w.PubKey
 	rip160HashValue := HashPubKey(pubKey)
-	version := byte(00)
+	return PubKeyHashToAddress(rip160HashValue)
+}
+
+//由公钥hash生成地址
+func PubKeyHashToAddress(rip160HashValue []byte) string {
+	return encodeAddress(pubKeyHashVersion, rip160HashValue)
+}
+
+//由脚本hash生成P2SH地址
+func ScriptHashToAddress(scriptHash []byte) string {
+	return encodeAddress(scriptHashVersion, scriptHash)
+}
+
+func encodeAddress(version byte, rip160HashValue []byte) string {
 	//拼接version
 	payload := append([]byte{version}, rip160HashValue...)
 	//checksum
@@ -74,17 +157,47 @@ func CheckSum(data []byte) []byte {
 	return checkCode
 }
 
-func IsValidAddress(address string) bool {
-	//1.解码
+//解码地址，返回地址中的hash（公钥hash或脚本hash）和地址类型
+//校验码错误、长度错误或者版本号未知都返回错误
+func DecodeAddress(address string) ([]byte, AddressType, error) {
+	//1.解码，1字节version + 20字节hash + 4字节校验码
 	addressByte := base58.Decode(address)
-	if len(addressByte) < 4 {
-		return false
+	if len(addressByte) != 25 {
+		return nil, AddressUnknown, errors.New("地址长度错误")
 	}
 	//2.截取数据
 	payload := addressByte[:len(addressByte)-4]
 	checkSum1 := addressByte[len(addressByte)-4:]
-	//3.做checkSum函数
-	checkSum2 := CheckSum(payload)
-	//4.比较
-	return bytes.Equal(checkSum1, checkSum2)
+	//3.做checkSum函数并比较
+	if !bytes.Equal(checkSum1, CheckSum(payload)) {
+		return nil, AddressUnknown, errors.New("地址校验码错误")
+	}
+	//4.根据version确定类型
+	switch payload[0] {
+	case pubKeyHashVersion:
+		return payload[1:], AddressPubKeyHash, nil
+	case scriptHashVersion:
+		return payload[1:], AddressScriptHash, nil
+	default:
+		return nil, AddressUnknown, errors.New("未知的地址版本号")
+	}
+}
+
+//返回地址的类型，无效地址返回AddressUnknown
+func GetAddressType(address string) AddressType {
+	_, addressType, err := DecodeAddress(address)
+	if err != nil {
+		return AddressUnknown
+	}
+	return addressType
+}
+
+//判断地址是否为P2SH地址
+func IsScriptHashAddress(address string) bool {
+	return GetAddressType(address) == AddressScriptHash
+}
+
+func IsValidAddress(address string) bool {
+	_, _, err := DecodeAddress(address)
+	return err == nil
 }
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
-	"github.com/btcsuite/btcutil/base58"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
+	"time"
 )
 
 const walletFile = "wallet.dat"
@@ -15,18 +18,88 @@ const walletFile = "wallet.dat"
 //定义一个Wallets结构，保存所有的wallet以及它的地址
 type Wallets struct {
 	WalletsMap map[string]*Wallet
+	//watch-only地址，只用于监控余额和交易记录，不持有私钥
+	WatchOnlyMap map[string]*WatchOnly
+	//自己地址（包括watch-only地址）的标签，key是地址
+	Labels map[string]string
+	//通讯录，保存交易对手的地址，key是标签
+	AddressBook map[string]string
+	//默认的选币策略，为空时使用defaultCoinSelector
+	CoinSelector string
+	//多重签名地址，key是P2SH地址
+	MultiSigMap map[string]*MultiSig
+	//时间锁地址，key是P2SH地址
+	TimeLockMap map[string]*TimeLock
+	//MuSig聚合公钥地址，key是P2PKH地址
+	MuSigMap map[string]*MuSig
+	//MuSig第一轮生成的秘密nonce，key见musigNonceKey，生成部分签名之后立即删除
+	MuSigNonces map[string][]byte
+}
+
+//MuSig聚合公钥地址，链上与普通的单签名地址相同，花费时需要所有签名方配合
+type MuSig struct {
+	Address string
+	//聚合后的x-only公钥
+	AggKey     []byte
+	PubKeys    [][]byte
+	CreateTime int64
+}
+
+//时间锁地址，到期之前output不能花费，到期之后由Owner签名花费
+type TimeLock struct {
+	Address      string
+	RedeemScript []byte
+	Owner        string
+	//绝对时间锁是锁定的区块高度或unix时间，相对时间锁是input的序列号
+	LockTime   uint64
+	Relative   bool
+	CreateTime int64
+}
+
+//多重签名地址，保存赎回脚本，花费时需要它
+type MultiSig struct {
+	Address      string
+	RedeemScript []byte
+	//需要的签名个数
+	M          int
+	PubKeys    [][]byte
+	CreateTime int64
+}
+
+//watch-only条目，只保存地址，公钥可选（导入公钥时才有）
+type WatchOnly struct {
+	Address    string
+	PubKey     []byte
+	PubKeyHash []byte
+	CreateTime int64
+}
+
+//列举地址时使用的地址信息
+type AddressInfo struct {
+	Address    string
+	Label      string
+	CreateTime int64
+	WatchOnly  bool
+	MultiSig   bool
 }
 
 //创建方法
 func NewWallets() *Wallets {
 	var ws Wallets
 	ws.WalletsMap = make(map[string]*Wallet)
+	ws.WatchOnlyMap = make(map[string]*WatchOnly)
+	ws.Labels = make(map[string]string)
+	ws.AddressBook = make(map[string]string)
+	ws.MultiSigMap = make(map[string]*MultiSig)
+	ws.TimeLockMap = make(map[string]*TimeLock)
+	ws.MuSigMap = make(map[string]*MuSig)
+	ws.MuSigNonces = make(map[string][]byte)
 	ws.LoadFile()
 	return &ws
 }
 
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+func (ws *Wallets) CreateWallet(keyType KeyType) string {
+	wallet := NewWallet(keyType)
 	address := wallet.NewAddress()
 	//wallets.WalletsMap = make(map[string]*Wallet)
 	ws.WalletsMap[address] = wallet
@@ -37,7 +110,6 @@ func (ws *Wallets) CreateWallet() string {
 //保存方法，把新建的wallet添加进去
 func (ws *Wallets) SaveToFile() {
 	var buffer bytes.Buffer
-	gob.Register(elliptic.P256())
 	encode := gob.NewEncoder(&buffer)
 	err := encode.Encode(ws)
 	if err != nil {
@@ -58,7 +130,6 @@ func (ws *Wallets) LoadFile() {
 	if err != nil {
 		log.Panic(err)
 	}
-	gob.Register(elliptic.P256())
 	//解码
 	decoder := gob.NewDecoder(bytes.NewReader(content))
 	var wsLocal Wallets
@@ -67,21 +138,347 @@ func (ws *Wallets) LoadFile() {
 		log.Panic(err)
 	}
 	ws.WalletsMap = wsLocal.WalletsMap
+	//旧版本的钱包文件中没有watch-only数据
+	if wsLocal.WatchOnlyMap != nil {
+		ws.WatchOnlyMap = wsLocal.WatchOnlyMap
+	}
+	if wsLocal.Labels != nil {
+		ws.Labels = wsLocal.Labels
+	}
+	if wsLocal.AddressBook != nil {
+		ws.AddressBook = wsLocal.AddressBook
+	}
+	ws.CoinSelector = wsLocal.CoinSelector
+	if wsLocal.MultiSigMap != nil {
+		ws.MultiSigMap = wsLocal.MultiSigMap
+	}
+	if wsLocal.TimeLockMap != nil {
+		ws.TimeLockMap = wsLocal.TimeLockMap
+	}
+	if wsLocal.MuSigMap != nil {
+		ws.MuSigMap = wsLocal.MuSigMap
+	}
+	if wsLocal.MuSigNonces != nil {
+		ws.MuSigNonces = wsLocal.MuSigNonces
+	}
 }
 
+//设置钱包默认的选币策略
+func (ws *Wallets) SetCoinSelector(name string) error {
+	if _, err := GetCoinSelector(name); err != nil {
+		return err
+	}
+	ws.CoinSelector = name
+	ws.SaveToFile()
+	return nil
+}
+
+//返回排好序的地址，避免map遍历的随机顺序
 func (ws *Wallets) ListAllAddresses() []string {
 	var addresses []string
 	for address := range ws.WalletsMap {
 		addresses = append(addresses, address)
 	}
+	sort.Strings(addresses)
 	return addresses
 }
 
-//通过地址返回公钥哈希
+//钱包中所有地址（包括watch-only、多重签名、MuSig和时间锁地址）的信息，key是地址
+func (ws *Wallets) addressInfos() map[string]AddressInfo {
+	infos := make(map[string]AddressInfo)
+	for address, wallet := range ws.WalletsMap {
+		infos[address] = AddressInfo{address, ws.Labels[address], wallet.CreateTime, false, false}
+	}
+	for address, watchOnly := range ws.WatchOnlyMap {
+		infos[address] = AddressInfo{address, ws.Labels[address], watchOnly.CreateTime, true, false}
+	}
+	for address, multiSig := range ws.MultiSigMap {
+		infos[address] = AddressInfo{address, ws.Labels[address], multiSig.CreateTime, false, true}
+	}
+	for address, muSig := range ws.MuSigMap {
+		infos[address] = AddressInfo{address, ws.Labels[address], muSig.CreateTime, false, true}
+	}
+	for address, timeLock := range ws.TimeLockMap {
+		infos[address] = AddressInfo{address, ws.Labels[address], timeLock.CreateTime, false, false}
+	}
+	return infos
+}
+
+//地址是否属于钱包（任何一种地址）
+func (ws *Wallets) HasAddress(address string) bool {
+	_, ok := ws.addressInfos()[address]
+	return ok
+}
+
+//返回钱包中所有地址的信息，按创建时间排序，时间相同按地址排序
+func (ws *Wallets) ListAddressInfos() []AddressInfo {
+	var infos []AddressInfo
+	for _, info := range ws.addressInfos() {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].CreateTime != infos[j].CreateTime {
+			return infos[i].CreateTime < infos[j].CreateTime
+		}
+		return infos[i].Address < infos[j].Address
+	})
+	return infos
+}
+
+//给自己的地址设置标签，标签为空表示删除
+func (ws *Wallets) SetLabel(address, label string) error {
+	if !ws.HasAddress(address) {
+		return errors.New("钱包中没有该地址，无法设置标签")
+	}
+	if label == "" {
+		delete(ws.Labels, address)
+	} else {
+		ws.Labels[address] = label
+	}
+	ws.SaveToFile()
+	return nil
+}
+
+//向通讯录添加联系人，同一个标签会被覆盖
+func (ws *Wallets) AddContact(label, address string) error {
+	if label == "" {
+		return errors.New("联系人标签不能为空")
+	}
+	//标签不能是一个合法地址，否则转账时无法区分
+	if IsValidAddress(label) {
+		return errors.New("联系人标签不能是地址")
+	}
+	if !IsValidAddress(address) {
+		return errors.New("联系人地址无效")
+	}
+	ws.AddressBook[label] = address
+	ws.SaveToFile()
+	return nil
+}
+
+func (ws *Wallets) RemoveContact(label string) error {
+	if _, ok := ws.AddressBook[label]; !ok {
+		return errors.New("通讯录中没有该联系人")
+	}
+	delete(ws.AddressBook, label)
+	ws.SaveToFile()
+	return nil
+}
+
+//返回按标签排序的联系人标签
+func (ws *Wallets) ListContacts() []string {
+	var labels []string
+	for label := range ws.AddressBook {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+//将地址或者联系人标签解析成地址，合法地址直接返回
+func (ws *Wallets) ResolveAddress(addressOrLabel string) (string, bool) {
+	if IsValidAddress(addressOrLabel) {
+		return addressOrLabel, true
+	}
+	address, ok := ws.AddressBook[addressOrLabel]
+	return address, ok
+}
+
+//导入一个watch-only地址
+func (ws *Wallets) AddWatchOnlyAddress(address string) error {
+	if !IsValidAddress(address) {
+		return errors.New("地址无效，无法导入")
+	}
+	if ws.WalletsMap[address] != nil {
+		return errors.New("该地址已经在钱包中，持有私钥，无需导入")
+	}
+	ws.WatchOnlyMap[address] = &WatchOnly{
+		Address:    address,
+		PubKeyHash: GetPubKeyHashFromAddress(address),
+		CreateTime: time.Now().Unix(),
+	}
+	ws.SaveToFile()
+	return nil
+}
+
+//导入一个watch-only公钥，返回对应的地址
+//带公钥的watch-only条目可以构建未签名交易，交给外部签名
+func (ws *Wallets) AddWatchOnlyPubKey(pubKey []byte) (string, error) {
+	if _, _, err := ParsePubKey(pubKey); err != nil {
+		return "", fmt.Errorf("公钥无效，无法导入: %s", err)
+	}
+	wallet := Wallet{PubKey: pubKey}
+	address := wallet.NewAddress()
+	if ws.WalletsMap[address] != nil {
+		return "", errors.New("该地址已经在钱包中，持有私钥，无需导入")
+	}
+	ws.WatchOnlyMap[address] = &WatchOnly{
+		Address:    address,
+		PubKey:     pubKey,
+		PubKeyHash: HashPubKey(pubKey),
+		CreateTime: time.Now().Unix(),
+	}
+	ws.SaveToFile()
+	return address, nil
+}
+
+func (ws *Wallets) ListWatchOnlyAddresses() []string {
+	var addresses []string
+	for address := range ws.WatchOnlyMap {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+//创建一个M-of-N多重签名地址并保存到钱包中
+func (ws *Wallets) AddMultiSig(m int, pubKeys [][]byte) (*MultiSig, error) {
+	redeemScript, err := NewMultiSigScript(m, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	multiSig := MultiSig{
+		Address:      ScriptHashToAddress(hash160(redeemScript)),
+		RedeemScript: redeemScript,
+		M:            m,
+		PubKeys:      pubKeys,
+		CreateTime:   time.Now().Unix(),
+	}
+	ws.MultiSigMap[multiSig.Address] = &multiSig
+	ws.SaveToFile()
+	return &multiSig, nil
+}
+
+func (ws *Wallets) ListMultiSigAddresses() []string {
+	var addresses []string
+	for address := range ws.MultiSigMap {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+//创建一个时间锁地址并保存到钱包中，owner必须是本钱包的地址
+//relative为true时lockTime是input的序列号（相对时间锁）
+func (ws *Wallets) AddTimeLock(owner string, lockTime uint64, relative bool) (*TimeLock, error) {
+	if ws.WalletsMap[owner] == nil {
+		return nil, errors.New("时间锁的所有者必须是本钱包的地址")
+	}
+	op := byte(OP_CHECKLOCKTIMEVERIFY)
+	if relative {
+		op = OP_CHECKSEQUENCEVERIFY
+	}
+	redeemScript := NewTimeLockScript(op, int64(lockTime), GetPubKeyHashFromAddress(owner))
+	timeLock := TimeLock{
+		Address:      ScriptHashToAddress(hash160(redeemScript)),
+		RedeemScript: redeemScript,
+		Owner:        owner,
+		LockTime:     lockTime,
+		Relative:     relative,
+		CreateTime:   time.Now().Unix(),
+	}
+	ws.TimeLockMap[timeLock.Address] = &timeLock
+	ws.SaveToFile()
+	return &timeLock, nil
+}
+
+//时间锁的说明，用于列举地址
+func (timeLock *TimeLock) String() string {
+	if timeLock.Relative {
+		return fmt.Sprintf("timelock 转入%d个区块后", timeLock.LockTime&SequenceLockTimeMask)
+	}
+	if timeLock.LockTime < LockTimeThreshold {
+		return fmt.Sprintf("timelock 高度%d之后", timeLock.LockTime)
+	}
+	return fmt.Sprintf("timelock %s之后", time.Unix(int64(timeLock.LockTime), 0).Format("2006-01-02 15:04:05"))
+}
+
+func (ws *Wallets) ListTimeLockAddresses() []string {
+	var addresses []string
+	for address := range ws.TimeLockMap {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+//创建一个MuSig聚合公钥地址并保存到钱包中，公钥必须是Schnorr公钥
+func (ws *Wallets) AddMuSig(pubKeys [][]byte) (*MuSig, error) {
+	aggKey, err := MuSigAggregateKey(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	muSig := MuSig{
+		Address:    PubKeyHashToAddress(HashPubKey(aggKey)),
+		AggKey:     aggKey,
+		PubKeys:    sortPubKeys(pubKeys),
+		CreateTime: time.Now().Unix(),
+	}
+	ws.MuSigMap[muSig.Address] = &muSig
+	ws.SaveToFile()
+	return &muSig, nil
+}
+
+func (ws *Wallets) ListMuSigAddresses() []string {
+	var addresses []string
+	for address := range ws.MuSigMap {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+//根据公钥hash查找MuSig地址
+func (ws *Wallets) FindMuSig(pubKeyHash []byte) *MuSig {
+	for _, muSig := range ws.MuSigMap {
+		if bytes.Equal(HashPubKey(muSig.AggKey), pubKeyHash) {
+			return muSig
+		}
+	}
+	return nil
+}
+
+//秘密nonce的key: 签名数据的hash + 签名方的公钥
+func musigNonceKey(msg, pubKey []byte) string {
+	return hex.EncodeToString(msg) + ":" + hex.EncodeToString(pubKey)
+}
+
+//根据脚本hash查找钱包中保存的赎回脚本
+func (ws *Wallets) FindRedeemScript(scriptHash []byte) []byte {
+	for _, multiSig := range ws.MultiSigMap {
+		if bytes.Equal(hash160(multiSig.RedeemScript), scriptHash) {
+			return multiSig.RedeemScript
+		}
+	}
+	for _, timeLock := range ws.TimeLockMap {
+		if bytes.Equal(hash160(timeLock.RedeemScript), scriptHash) {
+			return timeLock.RedeemScript
+		}
+	}
+	return nil
+}
+
+//根据公钥查找钱包
+func (ws *Wallets) FindWalletByPubKey(pubKey []byte) *Wallet {
+	for _, wallet := range ws.WalletsMap {
+		if bytes.Equal(wallet.PubKey, pubKey) {
+			return wallet
+		}
+	}
+	return nil
+}
+
+//判断地址是否为watch-only地址
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	return ws.WatchOnlyMap[address] != nil
+}
+
+//通过地址返回公钥哈希，P2SH地址返回脚本hash，无效地址返回nil
+//需要区分地址类型时使用DecodeAddress
 func GetPubKeyHashFromAddress(address string) []byte {
-	//1.解码
-	addressByte := base58.Decode(address) //25字节
-	//2.截取出公钥hash，去除version--1字节，去除校验码--4字节
-	pubKeyHash := addressByte[1 : len(addressByte)-4]
+	pubKeyHash, _, err := DecodeAddress(address)
+	if err != nil {
+		return nil
+	}
 	return pubKeyHash
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+//所有类型的地址都可以设置标签，并且出现在地址列表中
+func TestSetLabelAllAddressTypes(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//设置标签会保存钱包文件
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	owner := NewWallet(KeyTypeSchnorr)
+	cosigner := NewWallet(KeyTypeSchnorr)
+	ws := newTestWallets(owner)
+	addresses := map[string]string{owner.NewAddress(): "钱包"}
+	if err := ws.AddWatchOnlyAddress(NewWallet(KeyTypeP256).NewAddress()); err != nil {
+		t.Fatal(err)
+	}
+	for address := range ws.WatchOnlyMap {
+		addresses[address] = "watch-only"
+	}
+	multiSig, err := ws.AddMultiSig(1, [][]byte{owner.PubKey, cosigner.PubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses[multiSig.Address] = "多重签名"
+	muSig, err := ws.AddMuSig([][]byte{owner.PubKey, cosigner.PubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses[muSig.Address] = "MuSig"
+	timeLock, err := ws.AddTimeLock(owner.NewAddress(), 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses[timeLock.Address] = "时间锁"
+
+	for address, label := range addresses {
+		if err := ws.SetLabel(address, label); err != nil {
+			t.Errorf("%s: %s", label, err)
+		}
+	}
+	if err := ws.SetLabel(cosigner.NewAddress(), "其他人"); err == nil {
+		t.Error("不属于钱包的地址也能设置标签")
+	}
+	infos := ws.ListAddressInfos()
+	if len(infos) != len(addresses) {
+		t.Fatalf("地址列表有%d个地址，应该是%d个", len(infos), len(addresses))
+	}
+	for _, info := range infos {
+		if info.Label != addresses[info.Address] {
+			t.Errorf("%s的标签是%q，应该是%q", info.Address, info.Label, addresses[info.Address])
+		}
+	}
+}
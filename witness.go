@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"log"
+)
+
+//隔离见证数据
+//1.input中的Signature、PubKey、ScriptSig是见证数据，交易id(txid)不包含它们，第三方修改签名的编码不会改变txid
+//2.包含见证数据的hash是wtxid，区块中所有交易的wtxid组成默克尔树，树根写在挖矿交易的数据output中（见证承诺）
+//3.挖矿交易没有见证数据，PubKey中的随机数保证了挖矿交易id的唯一性，所以挖矿交易不去掉任何字段
+//4.区块权重 = 去掉见证数据的大小*3 + 完整大小，见证数据按1/4计算
+
+//见证承诺的前缀，后面是32字节的wtxid默克尔根
+var witnessCommitmentHeader = []byte{0xaa, 0x21, 0xa9, 0xed}
+
+//去掉见证数据的字节数的权重系数
+const witnessScaleFactor = 4
+
+//去掉见证数据的交易副本，用于计算txid
+func (tx *Transaction) StrippedCopy() Transaction {
+	var inputs []TXInput
+	for _, input := range tx.TXInputs {
+		if !tx.IsCoinbase() {
+			input = TXInput{input.TXid, input.Index, nil, nil, nil, input.Sequence}
+		}
+		inputs = append(inputs, input)
+	}
+	return Transaction{nil, inputs, tx.TXOutputs, tx.LockTime}
+}
+
+//交易是否包含见证数据
+func (tx *Transaction) HasWitness() bool {
+	if tx.IsCoinbase() {
+		return false
+	}
+	for _, input := range tx.TXInputs {
+		if len(input.Signature) != 0 || len(input.PubKey) != 0 || len(input.ScriptSig) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//计算txid，不包含见证数据
+func (tx *Transaction) Hash() []byte {
+	txCopy := tx.StrippedCopy()
+	hash := sha256.Sum256(txCopy.HashData())
+	return hash[:]
+}
+
+//计算wtxid，包含见证数据，没有见证数据时与txid相同
+func (tx *Transaction) WitnessHash() []byte {
+	hash := sha256.Sum256(tx.HashData())
+	return hash[:]
+}
+
+//交易id是否与内容一致
+func (tx *Transaction) HasValidTXID() bool {
+	return bytes.Equal(tx.TXID, tx.Hash())
+}
+
+//去掉见证数据之后的字节数，按计算txid的规范编码计算
+//gob编码包含类型信息，长度与编码顺序有关，不能用于共识
+func (tx *Transaction) BaseSize() int {
+	txCopy := tx.StrippedCopy()
+	return len(txCopy.HashData())
+}
+
+//完整的字节数，按计算wtxid的规范编码计算
+func (tx *Transaction) TotalSize() int {
+	return len(tx.HashData())
+}
+
+//交易权重
+func (tx *Transaction) Weight() int {
+	return tx.BaseSize()*(witnessScaleFactor-1) + tx.TotalSize()
+}
+
+//虚拟大小，权重除以4向上取整，计算手续费率时使用
+func (tx *Transaction) VirtualSize() int {
+	return (tx.Weight() + witnessScaleFactor - 1) / witnessScaleFactor
+}
+
+//区块权重，所有交易的权重之和
+func (block *Block) Weight() int {
+	weight := 0
+	for _, tx := range block.Transactions {
+		weight += tx.Weight()
+	}
+	return weight
+}
+
+//所有交易的wtxid组成的默克尔根，挖矿交易的wtxid记为全0（它包含承诺本身）
+func WitnessMerkleRoot(txs []*Transaction) []byte {
+	var leaves [][]byte
+	for i, tx := range txs {
+		if i == 0 && tx.IsCoinbase() {
+			leaves = append(leaves, make([]byte, sha256.Size))
+			continue
+		}
+		leaves = append(leaves, tx.WitnessHash())
+	}
+	return MerkleRoot(leaves)
+}
+
+//从挖矿交易中找到见证承诺，有多个时使用最后一个
+func ExtractWitnessCommitment(coinbase *Transaction) ([]byte, bool) {
+	var commitment []byte
+	for _, output := range coinbase.TXOutputs {
+		if IsWitnessCommitment(output.ScriptPubKey) {
+			data, _ := ExtractData(output.ScriptPubKey)
+			commitment = data[len(witnessCommitmentHeader):]
+		}
+	}
+	return commitment, commitment != nil
+}
+
+//脚本是否是见证承诺
+func IsWitnessCommitment(script []byte) bool {
+	data, ok := ExtractData(script)
+	return ok && len(data) == len(witnessCommitmentHeader)+sha256.Size && bytes.HasPrefix(data, witnessCommitmentHeader)
+}
+
+//有交易包含见证数据时，把见证承诺加入挖矿交易，需要在计算区块的默克尔根之前调用
+func (block *Block) AddWitnessCommitment() {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return
+	}
+	hasWitness := false
+	for _, tx := range block.Transactions {
+		if tx.HasWitness() {
+			hasWitness = true
+			break
+		}
+	}
+	if !hasWitness {
+		return
+	}
+	coinbase := block.Transactions[0]
+	output, err := NewDataOutput(append(append([]byte{}, witnessCommitmentHeader...), WitnessMerkleRoot(block.Transactions)...))
+	if err != nil {
+		log.Panic(err)
+	}
+	coinbase.TXOutputs = append(coinbase.TXOutputs, *output)
+	coinbase.SetHash()
+}
+
+//校验见证承诺: 有交易包含见证数据时必须有承诺，有承诺时必须与wtxid的默克尔根一致
+func (block *Block) CheckWitnessCommitment() error {
+	if len(block.Transactions) == 0 {
+		return nil
+	}
+	commitment, ok := ExtractWitnessCommitment(block.Transactions[0])
+	if !ok {
+		for _, tx := range block.Transactions {
+			if tx.HasWitness() {
+				return errors.New("区块包含见证数据，但是挖矿交易中没有见证承诺")
+			}
+		}
+		return nil
+	}
+	if !bytes.Equal(commitment, WitnessMerkleRoot(block.Transactions)) {
+		return errors.New("见证承诺与区块中的交易不一致")
+	}
+	return nil
+}